@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/reconcile"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runApply(args []string) int {
+	fs := newFlagSet("apply", `Plan the creates, updates and prunes reconciling the cluster with the rbac yaml files, then apply them with server-side apply when approved.
+	i.e. apply ../rbac/, to show the plan
+	or   apply -dry_run server ../rbac/, to validate the plan with the apiserver without persisting it
+	or   apply -approve ../rbac/
+	or   apply -prune -approve ../rbac/, to also delete the objects of ours removed from ../rbac/`)
+	kubeconfig := addKubeconfigFlag(fs)
+	owner := fs.String("owner", reconcile.DefaultOwner, "(optional) value of the "+reconcile.OwnerLabel+" label of the applied objects")
+	prune := fs.Bool("prune", false, "prune the objects labeled with -owner missing from the files, the files must be the whole repo")
+	field_manager := fs.String("field_manager", reconcile.DefaultFieldManager, "(optional) field manager of the server-side apply")
+	dry_run := fs.String("dry_run", "none", "\"none\" or \"server\", to send the requests as a server dry run")
+	approve := fs.Bool("approve", false, "apply the plan, it is only shown otherwise")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || (*dry_run != "none" && *dry_run != "server") {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	files, err := lint.CollectYamlFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to collect rbac yaml files: %s\n", err.Error())
+		return 2
+	}
+	desired, err := rbac_objects.LoadFiles(files)
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	ctx := context.Background()
+	clientset, err := rbac_objects.NewClientset(*kubeconfig)
+	if err != nil {
+		fmt.Printf("Failed to connect to the cluster: %s\n", err.Error())
+		return 2
+	}
+	live, err := rbac_objects.LoadClientset(ctx, clientset)
+	if err != nil {
+		fmt.Printf("Failed to load the live roles and bindings: %s\n", err.Error())
+		return 2
+	}
+
+	plan := reconcile.Compute(desired, live, *owner, *prune)
+	switch *format {
+	case "text":
+		reconcile.WriteText(os.Stdout, plan)
+	case "json":
+		if err := reconcile.WriteJson(os.Stdout, plan); err != nil {
+			fmt.Printf("Failed to write plan: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	if !plan.Changed() {
+		return 0
+	}
+	server_dry_run := *dry_run == "server"
+	if !*approve && !server_dry_run {
+		if *format == "text" {
+			fmt.Println("Run with -approve to apply the plan.")
+		}
+		return 0
+	}
+
+	if err := reconcile.Apply(ctx, clientset, plan, *field_manager, server_dry_run); err != nil {
+		fmt.Printf("Failed to apply the plan: %s\n", err.Error())
+		return 1
+	}
+	logger.Info("Applied the plan", "dry_run", *dry_run, "create", plan.Count(reconcile.OpCreate), "update", plan.Count(reconcile.OpUpdate),
+		"replace", plan.Count(reconcile.OpReplace), "prune", plan.Count(reconcile.OpPrune))
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/coverage"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runCoverage(args []string) int {
+	fs := newFlagSet("coverage", `Report the api resources granted by no role or only through wildcards, exits with 1 when resources are new since -previous_api_resources.
+	i.e. coverage -api_resources ./bin/prod-api-resources.txt -previous_api_resources ./bin/old-api-resources.txt ../rbac/`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	previous_api_resources := fs.String("previous_api_resources", "", "(optional) absolute path to a previous cluster api_resource file, to list the new resources")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	var previous map[string]proc_rules.ApiGroupValueType
+	if *previous_api_resources != "" {
+		proc_rules.ParseAllApiresources(*previous_api_resources)
+		previous = proc_rules.AllResourcesMap
+	}
+	proc_rules.ParseAllApiresources(*api_resources)
+
+	files, err := lint.CollectYamlFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to collect role files: %s\n", err.Error())
+		return 2
+	}
+	var roles []proc_rules.ExpandedRole
+	for _, file := range files {
+		role, _ := proc_rules.ExpandRbacYaml(logger, file)
+		if len(role.Rules) == 0 {
+			// i.e. bindings
+			continue
+		}
+		roles = append(roles, role)
+	}
+
+	report := coverage.Analyze(proc_rules.AllResourcesMap, roles, previous)
+	switch *format {
+	case "text":
+		report.WriteText(os.Stdout, previous != nil)
+	case "json":
+		if err := report.WriteJson(os.Stdout); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	if len(report.New) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/defaults"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runDefaults(args []string) int {
+	fs := newFlagSet("defaults", `Describe each role as a default Kubernetes ClusterRole plus additions minus removals.
+	i.e. defaults -api_resources ./bin/prod-api-resources.txt -base namespace-admin=edit ../rbac/`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	versions := defaults.Versions()
+	k8s_version := fs.String("k8s_version", versions[len(versions)-1], fmt.Sprintf("kubernetes minor version of the default roles, one of %v", versions))
+	base := fs.String("base", "", "(optional) default role of each role, i.e. \"cluster-operator=admin,namespace-viewer=view\", the closest default role otherwise")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	bases := make(map[string]string)
+	if *base != "" {
+		entries, _ := utils.SplitString(*base, ",")
+		for _, entry := range entries {
+			kv, splited := utils.SplitString(entry, "=")
+			if !splited || len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				fmt.Printf("Invalid base %q, expecting role=default\n", entry)
+				return 2
+			}
+			bases[kv[0]] = kv[1]
+		}
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	proc_rules.ParseAllApiresources(*api_resources)
+	default_roles := make(map[string]proc_rules.ExpandedRole)
+	var all_defaults []proc_rules.ExpandedRole
+	for _, name := range defaults.Names(*k8s_version) {
+		role, err := defaults.Load(logger, *k8s_version, name)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 2
+		}
+		default_roles[name] = role
+		all_defaults = append(all_defaults, role)
+	}
+	if len(all_defaults) == 0 {
+		fmt.Printf("No default roles for kubernetes %s, embedded versions: %v\n", *k8s_version, versions)
+		return 2
+	}
+
+	files, err := lint.CollectYamlFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to collect role files: %s\n", err.Error())
+		return 2
+	}
+	var comparisons []defaults.Comparison
+	for _, file := range files {
+		role, _ := proc_rules.ExpandRbacYaml(logger, file)
+		if len(role.Rules) == 0 {
+			// i.e. bindings
+			continue
+		}
+		if name, ok := bases[role.Name]; ok {
+			default_role, ok := default_roles[name]
+			if !ok {
+				fmt.Printf("Unknown default role %q for %s, one of %v\n", name, role.Name, defaults.Names(*k8s_version))
+				return 2
+			}
+			comparisons = append(comparisons, defaults.Compare(role, default_role, *k8s_version))
+		} else {
+			comparisons = append(comparisons, defaults.Closest(role, all_defaults, *k8s_version))
+		}
+	}
+
+	switch *format {
+	case "text":
+		defaults.WriteText(os.Stdout, comparisons)
+	case "json":
+		if err := defaults.WriteJson(os.Stdout, comparisons); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/results_diff"
+)
+
+func runDiff(args []string) int {
+	fs := newFlagSet("diff", "Compare two verification runs saved with -output_json and report the changes, exits with 1 on regressions.")
+	old_json := fs.String("old", "", "absolute path to the json results of the previous run")
+	new_json := fs.String("new", "", "absolute path to the json results of the current run")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	fs.Parse(args)
+
+	if *old_json == "" || *new_json == "" {
+		fs.Usage()
+		return 2
+	}
+	old_run, err := verify.LoadRunResult(*old_json)
+	if err != nil {
+		fmt.Printf("Failed to load %s: %s\n", *old_json, err.Error())
+		return 2
+	}
+	new_run, err := verify.LoadRunResult(*new_json)
+	if err != nil {
+		fmt.Printf("Failed to load %s: %s\n", *new_json, err.Error())
+		return 2
+	}
+
+	report := results_diff.Diff(old_run, new_run)
+	switch *format {
+	case "text":
+		report.WriteText(os.Stdout)
+	case "json":
+		if err := report.WriteJson(os.Stdout); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+
+	if report.Regressions() > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/drift"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runDrift(args []string) int {
+	fs := newFlagSet("drift", `Compare the live roles and bindings named like the rbac yaml files with them, semantically, and report the live bindings granting the OIDC groups something the files do not describe.
+	i.e. drift -api_resources ./bin/prod-api-resources.txt ../rbac/
+	or   drift -snapshot ./bin/prod-snapshot.tar.gz ../rbac/, offline`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	kubeconfig := addKubeconfigFlag(fs)
+	snapshot_file := addSnapshotFlag(fs)
+	prefix := fs.String("oidc_groups_prefix", "oidc:", "the --oidc-groups-prefix of the apiserver, prefixing the groups of the identity provider")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if (*api_resources == "" && *snapshot_file == "") || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	files, err := lint.CollectYamlFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to collect rbac yaml files: %s\n", err.Error())
+		return 2
+	}
+	desired, err := rbac_objects.LoadFiles(files)
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	live, err := loadLive(*kubeconfig, archive)
+	if err != nil {
+		fmt.Printf("Failed to load the live roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	if err := loadCatalog(*api_resources, archive); err != nil {
+		fmt.Printf("Failed to load the resource catalog: %s\n", err.Error())
+		return 2
+	}
+
+	report := drift.Compare(logger, desired, live, *prefix)
+	switch *format {
+	case "text":
+		drift.WriteText(os.Stdout, report)
+	case "json":
+		if err := drift.WriteJson(os.Stdout, report); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	if report.Drifted() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/minimize"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runEffective(args []string) int {
+	fs := newFlagSet("effective", `Compute the effective permissions of a user and its groups across all bindings, cluster-wide and per namespace.
+	i.e. effective -api_resources ./bin/prod-api-resources.txt -user alice -groups oidc:cluster-operator,oidc:mldev-namespace-admin ../rbac/`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	user := fs.String("user", "", "user name, i.e. \"alice\" or \"system:serviceaccount:mldev:deployer\"")
+	groups := fs.String("groups", "", "(optional) groups of the user, separately by \",\"")
+	cluster, kubeconfig, snapshot_file := addObjectsFlags(fs)
+	output_dir := fs.String("output_dir", "", "(optional) directory to write each scope as a minimized ClusterRole yaml, i.e. for verify -rbac_yaml or role-diff")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\", the json is in the shape of the rbac rules map")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if *user == "" || (!*cluster && fs.NArg() == 0) {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	objects, err := loadObjects(*cluster, *kubeconfig, archive, fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	identity := effective.Identity{User: *user, Groups: []string{}}
+	if *groups != "" {
+		identity.Groups, _ = utils.SplitString(*groups, ",")
+	}
+	if err := loadCatalog(*api_resources, archive); err != nil {
+		fmt.Printf("Failed to load the resource catalog: %s\n", err.Error())
+		return 2
+	}
+	permissions, err := effective.Compute(logger, objects, identity)
+	if err != nil {
+		fmt.Printf("Failed to compute effective permissions: %s\n", err.Error())
+		return 2
+	}
+
+	switch *format {
+	case "text":
+		permissions.WriteText(os.Stdout)
+	case "json":
+		if err := permissions.WriteJson(os.Stdout); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+
+	if *output_dir != "" {
+		for _, scope := range permissions.Scopes() {
+			role := permissions.Role(scope)
+			if scope == "" {
+				scope = "cluster"
+			}
+			name := roleNameOf(*user + "-" + scope)
+			data, err := minimize.Marshal(name, minimize.Minimize(role.Rules))
+			if err != nil {
+				fmt.Printf("Failed to marshal %s: %s\n", name, err.Error())
+				return 2
+			}
+			if err := os.WriteFile(filepath.Join(*output_dir, name+".yaml"), data, 0644); err != nil {
+				fmt.Printf("Failed to write %s: %s\n", name, err.Error())
+				return 2
+			}
+		}
+	}
+	return 0
+}
+
+// roleNameOf turns a user name into a valid role and file name, i.e. "system-serviceaccount-mldev-deployer"
+func roleNameOf(name string) string {
+	name = regexp.MustCompile(`[^a-z0-9.-]+`).ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(name, "-")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/escalation"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/expectations"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runEscalation(args []string) int {
+	fs := newFlagSet("escalation", `Report the identities and permissions a persona reaches by creating pods, creating tokens or impersonating, with the shortest path, exits with 1 when it gains permissions.
+	i.e. escalation -api_resources ./bin/prod-api-resources.txt -groups oidc:mldev-namespace-admin ../rbac/
+	or   escalation -api_resources ./bin/prod-api-resources.txt -expectations ./proposal-expectations.yaml -cluster`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	user := fs.String("user", "", "user name of the persona")
+	groups := fs.String("groups", "", "(optional) groups of the persona, separately by \",\"")
+	expectations_file := fs.String("expectations", "", "(optional) absolute path to an expectation spec yaml file, its personas are analyzed instead of -user and -groups")
+	cluster, kubeconfig, snapshot_file := addObjectsFlags(fs)
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if (*user == "" && *groups == "" && *expectations_file == "") || (!*cluster && fs.NArg() == 0) {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	personas := make(map[string]effective.Identity)
+	if *expectations_file != "" {
+		spec, err := expectations.Load(*expectations_file)
+		if err != nil {
+			fmt.Printf("Failed to load expectations: %s\n", err.Error())
+			return 2
+		}
+		for name, s := range spec.Personas {
+			personas[name] = effective.Identity{User: s.User, Groups: s.Groups}
+		}
+	} else {
+		identity := effective.Identity{User: *user, Groups: []string{}}
+		if *groups != "" {
+			identity.Groups, _ = utils.SplitString(*groups, ",")
+		}
+		name := *user
+		if name == "" {
+			name = strings.Join(identity.Groups, ",")
+		}
+		personas[name] = identity
+	}
+	names := make([]string, 0, len(personas))
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	objects, err := loadObjects(*cluster, *kubeconfig, archive, fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	if err := loadCatalog(*api_resources, archive); err != nil {
+		fmt.Printf("Failed to load the resource catalog: %s\n", err.Error())
+		return 2
+	}
+
+	var reports []escalation.Report
+	escalates := false
+	for _, name := range names {
+		report, err := escalation.Analyze(logger, objects, name, personas[name])
+		if err != nil {
+			fmt.Printf("Failed to analyze %s: %s\n", name, err.Error())
+			return 2
+		}
+		escalates = escalates || report.Escalates()
+		reports = append(reports, report)
+	}
+
+	switch *format {
+	case "text":
+		escalation.WriteText(os.Stdout, reports)
+	case "json":
+		if err := escalation.WriteJson(os.Stdout, reports); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	if escalates {
+		return 1
+	}
+	return 0
+}
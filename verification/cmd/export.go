@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/csv_export"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runExport(args []string) int {
+	fs := newFlagSet("export", "Export the expanded allowed and forbidden permission sets of a rbac yaml file as csv, without a cluster.")
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	rbac_yaml := fs.String("rbac_yaml", "", "absolute path to the rbac yaml file")
+	namespace := fs.String("namespace", "smoke-test", "list of namespaces of the namespaced resources, separately by \",\"")
+	results_json := fs.String("results", "", "(optional) absolute path to the json results of a run saved with -output_json, to fill in the actual verdicts")
+	output := fs.String("output", "", "(optional) absolute path to the csv file, stdout by default")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	var results []verify.ReviewResult
+	if *results_json != "" {
+		run, err := verify.LoadRunResult(*results_json)
+		if err != nil {
+			fmt.Printf("Failed to load %s: %s\n", *results_json, err.Error())
+			return 2
+		}
+		results = run.Results
+	}
+
+	proc_rules.ParseAllApiresources(*api_resources)
+	proc_rules.ParseK8sRbacYaml(logger, *rbac_yaml)
+	proc_rules.FilterRules()
+
+	namespaces, _ := utils.SplitString(*namespace, ",")
+	if err := writeCsv(*output, namespaces, results); err != nil {
+		fmt.Printf("Failed to export csv: %s\n", err.Error())
+		return 2
+	}
+	return 0
+}
+
+// writeCsv exports the current RbacRulesMap and ForbiddenRulesMap to path, or to stdout when path is empty
+func writeCsv(path string, namespaces []string, results []verify.ReviewResult) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return csv_export.Write(w, proc_rules.RbacRulesMap, proc_rules.ForbiddenRulesMap, namespaces, results)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_graph"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runGraph(args []string) int {
+	fs := newFlagSet("graph", `Draw the subjects, bindings and roles as a Graphviz DOT file or a Mermaid flowchart, grouped by namespace.
+	i.e. graph -format dot -output ./bin/rbac.dot ../rbac/
+	or   graph -cluster -format mermaid -namespace mldev`)
+	subject := fs.String("subject", "", "(optional) only draw the bindings naming the user, group, or \"<namespace>/<name>\" service account")
+	namespace := fs.String("namespace", "", "(optional) only draw the bindings of the namespace")
+	output := fs.String("output", "", "(optional) path of the file to write, stdout by default")
+	cluster, kubeconfig, snapshot_file := addObjectsFlags(fs)
+	format := fs.String("format", "dot", "output format, \"dot\" or \"mermaid\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if !*cluster && fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "dot" && *format != "mermaid" {
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	objects, err := loadObjects(*cluster, *kubeconfig, archive, fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	g := rbac_graph.Build(objects, rbac_graph.Filter{Subject: *subject, Namespace: *namespace})
+	logger.Info("Built rbac graph", "nodes", len(g.Nodes), "edges", len(g.Edges))
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Printf("Failed to create %s: %s\n", *output, err.Error())
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "dot" {
+		rbac_graph.WriteDot(w, g)
+	} else {
+		rbac_graph.WriteMermaid(w, g)
+	}
+	return 0
+}
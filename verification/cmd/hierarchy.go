@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/hierarchy"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runHierarchy(args []string) int {
+	fs := newFlagSet("hierarchy", `Verify each role file is a subset of the one before it, exits with 1 on violations.
+	i.e. hierarchy -api_resources ./bin/prod-api-resources.txt cluster-admin.yaml cluster-operator.yaml cluster-viewer.yaml`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	proc_rules.ParseAllApiresources(*api_resources)
+	var roles []proc_rules.ExpandedRole
+	for _, path := range fs.Args() {
+		role, diags := proc_rules.ExpandRbacYaml(logger, path)
+		if n := diagnostics.Count(diags, diagnostics.SeverityError); n > 0 {
+			fmt.Printf("Warning: %s has %d lint error(s), run the lint subcommand for details\n", path, n)
+		}
+		roles = append(roles, role)
+	}
+
+	violations := hierarchy.Check(roles)
+	switch *format {
+	case "text":
+		hierarchy.WriteText(os.Stdout, roles, violations)
+	case "json":
+		if err := hierarchy.WriteJson(os.Stdout, violations); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	if len(violations) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/idp_groups"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runIdpGroups(args []string) int {
+	fs := newFlagSet("idp-groups", `Validate the groups named by the bindings against a Keycloak realm export or a group to members yaml, exits with 1 on errors.
+	i.e. idp-groups -idp ./bin/realm-export.json ../rbac/
+	or   idp-groups -idp ./groups.yaml -oidc_groups_prefix oidc: -cluster`)
+	idp := fs.String("idp", "", "absolute path to a Keycloak realm export json or a group to members yaml")
+	prefix := fs.String("oidc_groups_prefix", "oidc:", "the --oidc-groups-prefix of the apiserver, prefixing the groups of the identity provider")
+	full_path := fs.Bool("full_path", false, "(optional) Keycloak groups are claimed by their full path, i.e. \"/projects/mldev-namespace-admin\"")
+	cluster, kubeconfig, snapshot_file := addObjectsFlags(fs)
+	severity := fs.String("severity", "", "(optional) severity overrides, i.e. \"unbound-idp-group=off,empty-idp-group=error\"")
+	format := fs.String("format", "text", "output format, \"text\", \"json\" or \"sarif\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if *idp == "" || (!*cluster && fs.NArg() == 0) {
+		fs.Usage()
+		return 2
+	}
+	overrides, err := diagnostics.ParseSeverities(*severity)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	dir, err := idp_groups.Load(*idp, *full_path)
+	if err != nil {
+		fmt.Printf("Failed to load the identity provider groups: %s\n", err.Error())
+		return 2
+	}
+	logger.Info("Loaded identity provider groups", "source", dir.Source, "groups", len(dir.Groups), "members_known", dir.MembersKnown)
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	objects, err := loadObjects(*cluster, *kubeconfig, archive, fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	diags := diagnostics.ApplySeverities(idp_groups.Analyze(objects, dir, *prefix), overrides)
+	return writeDiagnostics(diags, *format, idp_groups.Rules)
+}
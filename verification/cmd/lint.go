@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/policy"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/sarif"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runLint(args []string) int {
+	fs := newFlagSet("lint", `Lint rbac yaml files or directories against the resource catalog, exits with 1 on errors.
+	i.e. lint -api_resources ./bin/prod-api-resources.txt ../rbac/
+	or   lint -snapshot ./bin/prod-snapshot.tar.gz ../rbac/`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	snapshot_file := addSnapshotFlag(fs)
+	severity := fs.String("severity", "", "(optional) severity overrides, i.e. \"unavailable-verb=error,unknown-resource=off\"")
+	policy_file := fs.String("policy", "", "(optional) absolute path to a policy yaml file of CEL expressions, evaluated against each expanded permission and binding")
+	format := fs.String("format", "text", "output format, \"text\", \"json\" or \"sarif\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	overrides, err := diagnostics.ParseSeverities(*severity)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	var policies *policy.PolicySet
+	if *policy_file != "" {
+		if policies, err = policy.Load(*policy_file); err != nil {
+			fmt.Printf("Failed to load policies: %s\n", err.Error())
+			return 2
+		}
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	if err := loadCatalog(*api_resources, archive); err != nil {
+		fmt.Printf("Failed to load the resource catalog: %s\n", err.Error())
+		return 2
+	}
+	diags, err := lint.Lint(logger, fs.Args(), policies, overrides)
+	if err != nil {
+		fmt.Printf("Failed to lint: %s\n", err.Error())
+		return 2
+	}
+	return writeDiagnostics(diags, *format, append(lint.Rules, policies.Rules()...))
+}
+
+// writeDiagnostics prints the diagnostics and returns the exit code, 1 when any of them is an error
+func writeDiagnostics(diags []diagnostics.Diagnostic, format string, rules []diagnostics.Rule) int {
+	switch format {
+	case "text":
+		diagnostics.WriteText(os.Stdout, diags)
+	case "json":
+		if err := diagnostics.WriteJson(os.Stdout, diags); err != nil {
+			fmt.Printf("Failed to write diagnostics: %s\n", err.Error())
+			return 2
+		}
+	case "sarif":
+		if err := sarif.Write(os.Stdout, "k8s-rbac-verification", rules, diags, locate); err != nil {
+			fmt.Printf("Failed to write diagnostics: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", format)
+		return 2
+	}
+	if diagnostics.Count(diags, diagnostics.SeverityError) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// locate finds the line and column of a diagnostic, as recorded by ParseK8sRbacYaml
+func locate(d diagnostics.Diagnostic) (int, int) {
+	p, _ := proc_rules.PositionOf(d.File, d.Document, d.RuleIndex)
+	return p.Line, p.Column
+}
@@ -17,40 +17,149 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
 
-	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/snapshot"
 	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
 	"k8s.io/client-go/util/homedir"
 )
 
-func main() {
+// subcommands, each parses its own flags and returns the exit code
+var commands map[string]func(args []string) int
 
-	var kubeconfig, api_resources, rbac_yaml, namespace, log_file *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-	}
-	api_resources = flag.String("api_resources", "", `absolute path to cluster api_resource file.
-	Use "kubectl api-resources -o wide > api_resources.txt" for resounce only, 
-	or "scripts/k8s/print-all-res.sh" for resource and subresources to generate.`)
-	rbac_yaml = flag.String("rbac_yaml", "", "absolute path to the rbac yaml file")
-	namespace = flag.String("namespace", "smoke-test", "list of namespaces to be verified, separately by \",\"")
-	log_file = flag.String("log_file", "./rbac_verify.log", "absolute path to the log file")
-	flag.Parse()
-
-	utils.Set_logging(*log_file)
-	namespaces, _ := utils.SplitString(*namespace, ",")
-	for _, ns := range namespaces {
-		sar_allowed, sar_forbidden := verify.CreateSubjectAccessReviewList(*api_resources, *rbac_yaml, ns)
-		if err := verify.DoBatchSelfSubjectAccessReviews(*kubeconfig, sar_allowed, true); err != nil {
-			fmt.Printf("Test Error %s", err.Error())
+func init() {
+	commands = map[string]func(args []string) int{
+		"verify":     runVerify,
+		"apply":      runApply,
+		"coverage":   runCoverage,
+		"defaults":   runDefaults,
+		"diff":       runDiff,
+		"drift":      runDrift,
+		"effective":  runEffective,
+		"escalation": runEscalation,
+		"export":     runExport,
+		"graph":      runGraph,
+		"hierarchy":  runHierarchy,
+		"idp-groups": runIdpGroups,
+		"lint":       runLint,
+		"minimize":   runMinimize,
+		"onboard":    runOnboard,
+		"orphans":    runOrphans,
+		"risk":       runRisk,
+		"role-diff":  runRoleDiff,
+		"snapshot":   runSnapshot,
+		"who-can":    runWhoCan,
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
 		}
-		if err := verify.DoBatchSelfSubjectAccessReviews(*kubeconfig, sar_forbidden, false); err != nil {
-			fmt.Printf("Test Error %s", err.Error())
+	}
+	// without a subcommand, verify is run for backward compatibility
+	os.Exit(runVerify(os.Args[1:]))
+}
+
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s:\n%s\n", os.Args[0], name, usage)
+		fs.PrintDefaults()
+		if name == "verify" {
+			var names []string
+			for k := range commands {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(fs.Output(), "\nSubcommands: %v, run \"%s <subcommand> -h\" for their flags\n", names, os.Args[0])
 		}
 	}
+	return fs
+}
+
+// addLogFlags registers the logging flags shared by the subcommands
+func addLogFlags(fs *flag.FlagSet) *utils.LogOptions {
+	var opts utils.LogOptions
+	fs.StringVar(&opts.File, "log_file", "./rbac_verify.log", "absolute path to the log file, appended to, \"\" to disable")
+	fs.StringVar(&opts.Level, "log_level", "info", "log level, one of debug, info, warn, error")
+	fs.StringVar(&opts.Format, "log_format", "text", "log format, \"text\" or \"json\"")
+	fs.BoolVar(&opts.Stderr, "log_stderr", false, "also write the log to stderr")
+	return &opts
+}
+
+// addObjectsFlags registers the flags to load the rbac objects from the live cluster, or from a snapshot archive in place of it
+// -snapshot implies -cluster
+func addObjectsFlags(fs *flag.FlagSet) (cluster *bool, kubeconfig *string, snapshot_file *string) {
+	cluster = fs.Bool("cluster", false, "load the roles and bindings from the live cluster of -kubeconfig, or from -snapshot")
+	snapshot_file = new(string)
+	fs.Func("snapshot", snapshotUsage+", implies -cluster", func(value string) error {
+		*snapshot_file, *cluster = value, value != ""
+		return nil
+	})
+	return cluster, addKubeconfigFlag(fs), snapshot_file
+}
+
+// addKubeconfigFlag registers -kubeconfig, defaulting to ~/.kube/config
+func addKubeconfigFlag(fs *flag.FlagSet) *string {
+	if home := homedir.HomeDir(); home != "" {
+		return fs.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+	}
+	return fs.String("kubeconfig", "", "absolute path to the kubeconfig file")
+}
+
+// addSnapshotFlag registers -snapshot, an archive of the snapshot subcommand read in place of the live cluster
+func addSnapshotFlag(fs *flag.FlagSet) *string {
+	return fs.String("snapshot", "", snapshotUsage)
+}
+
+const snapshotUsage = "(optional) absolute path to an archive of the snapshot subcommand, read in place of the live cluster, and of -api_resources when not set"
+
+// loadSnapshot loads the archive of the snapshot subcommand, nil when snapshot_file is not set, so it is read once for
+// both the objects and the catalog
+func loadSnapshot(snapshot_file string) (*snapshot.Snapshot, error) {
+	if snapshot_file == "" {
+		return nil, nil
+	}
+	return snapshot.Load(snapshot_file)
+}
+
+// loadObjects loads the rbac objects from the live cluster, or from the yaml files and directories in paths
+func loadObjects(cluster bool, kubeconfig string, archive *snapshot.Snapshot, paths []string) (*rbac_objects.Objects, error) {
+	if cluster {
+		return loadLive(kubeconfig, archive)
+	}
+	files, err := lint.CollectYamlFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	return rbac_objects.LoadFiles(files)
+}
+
+// loadLive returns the rbac objects of the snapshot archive when set, loads those of the live cluster of the kubeconfig
+// otherwise
+func loadLive(kubeconfig string, archive *snapshot.Snapshot) (*rbac_objects.Objects, error) {
+	if archive != nil {
+		return archive.Objects, nil
+	}
+	return rbac_objects.LoadCluster(context.Background(), kubeconfig)
+}
+
+// loadCatalog parses the resource catalog of api_resources, or of the snapshot archive when api_resources is not set
+func loadCatalog(api_resources string, archive *snapshot.Snapshot) error {
+	if api_resources == "" && archive != nil {
+		archive.ParseCatalog()
+		return nil
+	}
+	proc_rules.ParseAllApiresources(api_resources)
+	return nil
 }
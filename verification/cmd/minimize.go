@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/minimize"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runMinimize(args []string) int {
+	fs := newFlagSet("minimize", `Rewrite a role file into an equivalent minimal ClusterRole, verified by expanding it again.
+	i.e. minimize -api_resources ./bin/prod-api-resources.txt -output minimal.yaml namespace-admin-clusterrole.yaml`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	output := fs.String("output", "", "(optional) absolute path to write the minimized role, stdout by default")
+	name := fs.String("name", "", "(optional) name of the minimized role, the name of the input role by default")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	proc_rules.ParseAllApiresources(*api_resources)
+	role, restricted, diags, err := minimize.Expand(logger, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %s\n", fs.Arg(0), err.Error())
+		return 2
+	}
+	// stdout may carry the yaml, report on stderr
+	if n := diagnostics.Count(diags, diagnostics.SeverityError); n > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s has %d lint error(s), run the lint subcommand for details\n", role.File, n)
+	}
+	for _, d := range diags {
+		if d.RuleID == proc_rules.RuleNonResourceURL {
+			fmt.Fprintf(os.Stderr, "Warning: dropped %s\n", d.String())
+		}
+	}
+	if *name == "" {
+		*name = role.Name
+	}
+
+	// the resourceNames rules are carried through unchanged
+	rules := append(minimize.Minimize(role.Rules), restricted...)
+	data, err := minimize.Marshal(*name, rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to marshal the minimized role: %s\n", err.Error())
+		return 2
+	}
+	if err := minimize.Verify(logger, role, restricted, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to verify the minimized role: %s\n", err.Error())
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %s\n", *output, err.Error())
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "Minimized %s into %d rule(s), verified equivalent\n", role.File, len(rules))
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/defaults"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/onboard"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runOnboard(args []string) int {
+	fs := newFlagSet("onboard", `Write the admin and viewer RoleBindings of a new namespace into the rbac directory and add its admin group to the node-viewer ClusterRoleBinding, after verifying them offline, exits with 1 when the verification fails.
+	i.e. onboard -api_resources ./bin/prod-api-resources.txt -namespace team-x ../rbac/`)
+	namespace := fs.String("namespace", "", "name of the new namespace")
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	admin_group := fs.String("admin_group", onboard.DefaultConvention.AdminGroup, "(optional) admin group, \"{namespace}\" is replaced with the namespace")
+	viewer_group := fs.String("viewer_group", onboard.DefaultConvention.ViewerGroup, "(optional) viewer group, \"{namespace}\" is replaced with the namespace")
+	admin_role := fs.String("admin_role", onboard.DefaultConvention.AdminRole, "(optional) ClusterRole bound to the admin group")
+	viewer_role := fs.String("viewer_role", onboard.DefaultConvention.ViewerRole, "(optional) ClusterRole bound to the viewer group")
+	node_viewer := fs.String("node_viewer", onboard.DefaultConvention.NodeViewerBinding, "(optional) ClusterRoleBinding the admin group is added to")
+	k8s_version := fs.String("k8s_version", "", "(optional) kubernetes minor version of the builtin default roles, the latest embedded when empty")
+	dry_run := fs.Bool("dry_run", false, "(optional) print the files and verify them without writing")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if *namespace == "" || *api_resources == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	dir := fs.Arg(0)
+	files, err := lint.CollectYamlFiles([]string{dir})
+	if err != nil {
+		fmt.Printf("Failed to collect rbac yaml files: %s\n", err.Error())
+		return 2
+	}
+	objects, err := rbac_objects.LoadFiles(files)
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	convention := onboard.Convention{
+		AdminGroup:        *admin_group,
+		ViewerGroup:       *viewer_group,
+		AdminRole:         *admin_role,
+		ViewerRole:        *viewer_role,
+		NodeViewerBinding: *node_viewer,
+	}
+	plan, err := onboard.Generate(objects, dir, *namespace, convention)
+	if err != nil {
+		fmt.Printf("Failed to generate the bindings: %s\n", err.Error())
+		return 2
+	}
+
+	planned, err := plan.Objects(files)
+	if err != nil {
+		fmt.Printf("Failed to load the generated bindings: %s\n", err.Error())
+		return 2
+	}
+	if *k8s_version == "" {
+		versions := defaults.Versions()
+		*k8s_version = versions[len(versions)-1]
+	}
+	if err := defaults.AddTo(planned, *k8s_version); err != nil {
+		fmt.Printf("Failed to load the default roles: %s\n", err.Error())
+		return 2
+	}
+	proc_rules.ParseAllApiresources(*api_resources)
+	checks, err := onboard.Verify(logger, planned, plan)
+	if err != nil {
+		fmt.Printf("Failed to verify the bindings: %s\n", err.Error())
+		return 2
+	}
+
+	if *format == "text" {
+		onboard.WriteText(os.Stdout, plan, checks)
+		if *dry_run {
+			for _, f := range plan.Files {
+				fmt.Printf("--- %s\n%s", f.Path, f.Content)
+			}
+		}
+	} else if err := onboard.WriteJson(os.Stdout, plan, checks); err != nil {
+		fmt.Printf("Failed to write report: %s\n", err.Error())
+		return 2
+	}
+	if !onboard.Passed(checks) {
+		logger.Warn("Verification failed, nothing written", "namespace", *namespace)
+		return 1
+	}
+	if !*dry_run {
+		if err := plan.Write(); err != nil {
+			fmt.Printf("Failed to write the bindings: %s\n", err.Error())
+			return 2
+		}
+		logger.Info("Wrote the bindings", "namespace", *namespace, "files", len(plan.Files))
+	}
+	return 0
+}
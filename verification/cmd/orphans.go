@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/defaults"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/orphans"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runOrphans(args []string) int {
+	fs := newFlagSet("orphans", `Report dangling roleRefs, bindings in missing namespaces, unreferenced roles and missing service accounts, exits with 1 on errors.
+	i.e. orphans ../rbac/
+	or   orphans -cluster -kubeconfig ~/.kube/config ../rbac/, adding the live objects missing from the files`)
+	cluster, kubeconfig, snapshot_file := addObjectsFlags(fs)
+	k8s_version := fs.String("k8s_version", "", "(optional) kubernetes minor version of the builtin default roles, the latest embedded when empty")
+	severity := fs.String("severity", "", "(optional) severity overrides, i.e. \"unreferenced-role=off,missing-serviceaccount=error\"")
+	format := fs.String("format", "text", "output format, \"text\", \"json\" or \"sarif\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if !*cluster && fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	overrides, err := diagnostics.ParseSeverities(*severity)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	files, err := lint.CollectYamlFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to collect rbac yaml files: %s\n", err.Error())
+		return 2
+	}
+	objects, err := rbac_objects.LoadFiles(files)
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	if *cluster {
+		live, err := loadLive(*kubeconfig, archive)
+		if err != nil {
+			fmt.Printf("Failed to load the live roles and bindings: %s\n", err.Error())
+			return 2
+		}
+		objects.Merge(live)
+	}
+	logger.Info("Loaded rbac objects", "roles", len(objects.Roles), "clusterroles", len(objects.ClusterRoles),
+		"rolebindings", len(objects.RoleBindings), "clusterrolebindings", len(objects.ClusterRoleBindings),
+		"namespaces", len(objects.Namespaces), "serviceaccounts", len(objects.ServiceAccounts))
+
+	if *k8s_version == "" {
+		versions := defaults.Versions()
+		*k8s_version = versions[len(versions)-1]
+	}
+	diags := diagnostics.ApplySeverities(orphans.Analyze(objects, defaults.Names(*k8s_version)), overrides)
+	return writeDiagnostics(diags, *format, orphans.Rules)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/lint"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/risk"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runRisk(args []string) int {
+	fs := newFlagSet("risk", `Flag the escalation-capable permissions granted by rbac yaml files or directories, exits with 1 on errors.
+	i.e. risk -api_resources ./bin/prod-api-resources.txt ../rbac/`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	severity := fs.String("severity", "", "(optional) severity overrides, i.e. \"risk-secrets-read=error,risk-wildcard=off\"")
+	format := fs.String("format", "text", "output format, \"text\", \"json\" or \"sarif\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	overrides, err := diagnostics.ParseSeverities(*severity)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	files, err := lint.CollectYamlFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to collect rbac yaml files: %s\n", err.Error())
+		return 2
+	}
+	proc_rules.ParseAllApiresources(*api_resources)
+	var diags []diagnostics.Diagnostic
+	for _, file := range files {
+		proc_rules.ParseK8sRbacYaml(logger, file)
+		diags = append(diags, risk.Analyze(proc_rules.RbacRulesMap, proc_rules.RbacRulesOrigin)...)
+	}
+	return writeDiagnostics(diagnostics.ApplySeverities(diags, overrides), *format, risk.Rules())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/role_diff"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runRoleDiff(args []string) int {
+	fs := newFlagSet("role-diff", `Compare the expanded permissions of two versions of a role, exits with 1 when they differ.
+	i.e. role-diff -api_resources ./bin/prod-api-resources.txt old/cluster-operator-clusterrole.yaml cluster-operator-clusterrole.yaml`)
+	api_resources := fs.String("api_resources", "", "absolute path to cluster api_resource file")
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	proc_rules.ParseAllApiresources(*api_resources)
+	var roles []proc_rules.ExpandedRole
+	for _, path := range fs.Args() {
+		role, diags := proc_rules.ExpandRbacYaml(logger, path)
+		if n := diagnostics.Count(diags, diagnostics.SeverityError); n > 0 {
+			fmt.Printf("Warning: %s has %d lint error(s), run the lint subcommand for details\n", path, n)
+		}
+		roles = append(roles, role)
+	}
+
+	report := role_diff.Diff(roles[0], roles[1])
+	switch *format {
+	case "text":
+		report.WriteText(os.Stdout)
+	case "json":
+		if err := report.WriteJson(os.Stdout); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	if !report.Empty() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/snapshot"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runSnapshot(args []string) int {
+	fs := newFlagSet("snapshot", `Capture the discovery catalog, roles, bindings, namespaces, service accounts and server version of the cluster into an archive, read by -snapshot in place of the cluster.
+	i.e. snapshot -output ./bin/prod-snapshot.tar.gz
+	or   snapshot -show ./bin/prod-snapshot.tar.gz, to print what an archive holds`)
+	kubeconfig := addKubeconfigFlag(fs)
+	output := fs.String("output", "", "absolute path of the archive to write, i.e. \"prod-snapshot.tar.gz\"")
+	show := fs.String("show", "", "(optional) absolute path of an archive to print the summary of, instead of capturing")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if *output == "" && *show == "" {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	if *show != "" {
+		s, err := snapshot.Load(*show)
+		if err != nil {
+			fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+			return 2
+		}
+		snapshot.WriteText(os.Stdout, s)
+		return 0
+	}
+
+	clientset, err := rbac_objects.NewClientset(*kubeconfig)
+	if err != nil {
+		fmt.Printf("Failed to connect to the cluster: %s\n", err.Error())
+		return 2
+	}
+	s, err := snapshot.Capture(context.Background(), logger, clientset)
+	if err != nil {
+		fmt.Printf("Failed to capture the snapshot: %s\n", err.Error())
+		return 2
+	}
+	if err := s.Write(*output); err != nil {
+		fmt.Printf("Failed to write the snapshot: %s\n", err.Error())
+		return 2
+	}
+	snapshot.WriteText(os.Stdout, s)
+	logger.Info("Wrote snapshot", "output", *output, "server", s.ServerVersion.GitVersion)
+	return 0
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/baseline"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/expectations"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/metrics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	"golang.org/x/exp/slog"
+)
+
+func runVerify(args []string) int {
+	fs := newFlagSet("verify", "Verify the current user's RBAC in a k8s cluster against a rbac yaml file.")
+
+	var api_resources, rbac_yaml, namespace, baseline_file, output_json *string
+	kubeconfig := addKubeconfigFlag(fs)
+	api_resources = fs.String("api_resources", "", `absolute path to cluster api_resource file.
+	Use "kubectl api-resources -o wide > api_resources.txt" for resounce only, 
+	or "scripts/k8s/print-all-res.sh" for resource and subresources to generate.`)
+	rbac_yaml = fs.String("rbac_yaml", "", "absolute path to the rbac yaml file")
+	namespace = fs.String("namespace", "smoke-test", "list of namespaces to be verified, separately by \",\"")
+	baseline_file = fs.String("baseline", "", "(optional) absolute path to the baseline yaml file of accepted deviations")
+	output_json = fs.String("output_json", "", "(optional) absolute path to save the review results as json, for the diff subcommand")
+	output_csv := fs.String("output_csv", "", "(optional) absolute path to export the allowed and forbidden sets with the review results as csv")
+	metrics_file := fs.String("metrics_file", "", "(optional) absolute path to write prometheus text format metrics, i.e. for node-exporter's textfile collector")
+	metrics_listen := fs.String("metrics_listen", "", "(optional) address to serve prometheus metrics on /metrics, i.e. \"localhost:9153\", keeps running")
+	expectations_file := fs.String("expectations", "", "(optional) absolute path to an expectation spec yaml file of persona can/cannot assertions")
+	sweep := fs.Bool("sweep", true, "review the whole resource catalog against -rbac_yaml, set -sweep=false to only review -expectations")
+	interval := fs.Duration("interval", 0, "(optional) with -metrics_listen, rerun the verification at this interval, i.e. \"1h\"")
+	snapshot_file := addSnapshotFlag(fs)
+	user := fs.String("user", "", "with -snapshot, the user reviewed as the current user")
+	groups := fs.String("groups", "", "with -snapshot, the groups of -user, separately by \",\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	var waivers *baseline.Baseline
+	if *baseline_file != "" {
+		if waivers, err = baseline.Load(*baseline_file); err != nil {
+			fmt.Printf("Failed to load baseline: %s\n", err.Error())
+			return 2
+		}
+	}
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+
+	namespaces, _ := utils.SplitString(*namespace, ",")
+	var assertions []expectations.Assertion
+	if *expectations_file != "" {
+		spec, err := expectations.Load(*expectations_file)
+		if err != nil {
+			fmt.Printf("Failed to load expectations: %s\n", err.Error())
+			return 2
+		}
+		if err := loadCatalog(*api_resources, archive); err != nil {
+			fmt.Printf("Failed to load the resource catalog: %s\n", err.Error())
+			return 2
+		}
+		if assertions, err = expectations.Resolve(spec, proc_rules.AllResourcesMap, namespaces); err != nil {
+			fmt.Printf("Failed to resolve expectations: %s\n", err.Error())
+			return 2
+		}
+	} else if !*sweep {
+		fmt.Println("Nothing to verify: -sweep=false requires -expectations")
+		return 2
+	}
+
+	var authorizer verify.Authorizer
+	if *snapshot_file != "" {
+		if *user == "" && *groups == "" {
+			fmt.Println("Nobody to verify: -snapshot requires -user or -groups")
+			return 2
+		}
+		objects, err := loadLive(*kubeconfig, archive)
+		if err != nil {
+			fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+			return 2
+		}
+		identity := effective.Identity{User: *user, Groups: []string{}}
+		if *groups != "" {
+			identity.Groups, _ = utils.SplitString(*groups, ",")
+		}
+		authorizer = verify.NewOfflineAuthorizer(objects, identity)
+		// the sweep parses -api_resources itself
+		if err := loadCatalog(*api_resources, archive); err != nil {
+			fmt.Printf("Failed to load the resource catalog: %s\n", err.Error())
+			return 2
+		}
+	} else if authorizer, err = verify.NewClusterAuthorizer(logger, *kubeconfig); err != nil {
+		fmt.Printf("Failed to connect to the cluster: %s\n", err.Error())
+		return 2
+	}
+
+	verifyOnce := func() metrics.RunMetrics {
+		m := metrics.RunMetrics{Run: verify.RunResult{
+			RbacYaml:   *rbac_yaml,
+			Namespaces: namespaces,
+			StartTime:  time.Now().UTC(),
+		}}
+		for _, ns := range namespaces {
+			if !*sweep {
+				break
+			}
+			sar_allowed, sar_forbidden := verify.CreateSubjectAccessReviewList(logger, *api_resources, *rbac_yaml, ns)
+			allowed, errs := verify.DoBatchSelfSubjectAccessReviews(logger, authorizer, sar_allowed, true, waivers)
+			m.ApiErrors += printErrors(errs)
+			forbidden, errs := verify.DoBatchSelfSubjectAccessReviews(logger, authorizer, sar_forbidden, false, waivers)
+			m.ApiErrors += printErrors(errs)
+			m.Run.Results = append(m.Run.Results, allowed...)
+			m.Run.Results = append(m.Run.Results, forbidden...)
+		}
+		if len(assertions) > 0 {
+			results, errs := verify.DoExpectationReviews(logger, authorizer, assertions, waivers)
+			m.ApiErrors += printErrors(errs)
+			m.Run.Results = append(m.Run.Results, results...)
+		}
+		m.Run.Role = proc_rules.RbacRoleName
+		m.Duration = time.Since(m.Run.StartTime)
+		return m
+	}
+
+	if *metrics_listen != "" {
+		return serveMetrics(logger, *metrics_listen, *interval, verifyOnce)
+	}
+
+	m := verifyOnce()
+	if *output_json != "" {
+		if err := verify.SaveRunResult(*output_json, m.Run); err != nil {
+			fmt.Printf("Failed to save results: %s\n", err.Error())
+			return 2
+		}
+	}
+	if *output_csv != "" {
+		if err := writeCsv(*output_csv, namespaces, m.Run.Results); err != nil {
+			fmt.Printf("Failed to export csv: %s\n", err.Error())
+			return 2
+		}
+	}
+	successful := m.Successful()
+	if *metrics_file != "" {
+		m.LastSuccess = metrics.ReadLastSuccess(*metrics_file)
+		if successful {
+			m.LastSuccess = time.Now()
+		}
+		// the api errors are a counter, accumulated across the runs
+		written := m
+		written.ApiErrors += metrics.ReadApiErrors(*metrics_file)
+		if err := metrics.WriteFile(*metrics_file, written); err != nil {
+			fmt.Printf("Failed to write metrics: %s\n", err.Error())
+			return 2
+		}
+	}
+
+	summary := verify.Summarize(m.Run.Results)
+	fmt.Printf("Reviews passed: %d, failed: %d, waived: %d\n", summary[verify.OutcomePassed], summary[verify.OutcomeFailed], summary[verify.OutcomeWaived])
+	if !successful {
+		return 1
+	}
+	return 0
+}
+
+// printErrors prints the api errors of the reviews and counts them
+func printErrors(errs []error) int {
+	for _, err := range errs {
+		fmt.Printf("Test Error %s\n", err.Error())
+	}
+	return len(errs)
+}
+
+// serveMetrics verifies every interval, or only once when interval is 0, and serves the metrics of the last run on /metrics
+func serveMetrics(logger *slog.Logger, addr string, interval time.Duration, verifyOnce func() metrics.RunMetrics) int {
+	handler := &metrics.Handler{}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{Addr: addr, Handler: mux}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	logger.Info("Serving metrics", "address", addr, "interval", interval)
+
+	var api_errors int
+	var last_success time.Time
+	for {
+		m := verifyOnce()
+		api_errors += m.ApiErrors
+		if m.Successful() {
+			last_success = time.Now()
+		}
+		m.ApiErrors = api_errors
+		m.LastSuccess = last_success
+		handler.Update(m)
+
+		if interval <= 0 {
+			break
+		}
+		select {
+		case err := <-errs:
+			logger.Error("Metrics server stopped", err, "address", addr)
+			return 2
+		case <-time.After(interval):
+		}
+	}
+	err := <-errs
+	logger.Error("Metrics server stopped", err, "address", addr)
+	return 2
+}
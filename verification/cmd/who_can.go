@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/who_can"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+func runWhoCan(args []string) int {
+	fs := newFlagSet("who-can", `List the users, groups and service accounts granted a verb on a resource, with the granting binding and role.
+	i.e. who-can -verb create -resource pods/exec -namespace mldev ../rbac/
+	or   who-can -cluster -verb delete -group apps -resource statefulsets -namespace smoke-test`)
+	verb := fs.String("verb", "", "verb of the request, i.e. \"get\"")
+	group := fs.String("group", "", "apigroup of the resource, \"\" for the core apigroup")
+	resource := fs.String("resource", "", "resource of the request, i.e. \"pods\" or \"pods/exec\" for a subresource")
+	namespace := fs.String("namespace", "", "namespace of the request, \"\" for cluster-wide")
+	cluster, kubeconfig, snapshot_file := addObjectsFlags(fs)
+	format := fs.String("format", "text", "output format, \"text\" or \"json\"")
+	log_opts := addLogFlags(fs)
+	fs.Parse(args)
+
+	if *verb == "" || *resource == "" || (!*cluster && fs.NArg() == 0) {
+		fs.Usage()
+		return 2
+	}
+	logger, closer, err := utils.NewLogger(*log_opts)
+	if err != nil {
+		fmt.Printf("Failed to set up logging: %s\n", err.Error())
+		return 2
+	}
+	defer closer.Close()
+
+	archive, err := loadSnapshot(*snapshot_file)
+	if err != nil {
+		fmt.Printf("Failed to load the snapshot: %s\n", err.Error())
+		return 2
+	}
+	objects, err := loadObjects(*cluster, *kubeconfig, archive, fs.Args())
+	if err != nil {
+		fmt.Printf("Failed to load roles and bindings: %s\n", err.Error())
+		return 2
+	}
+	logger.Info("Loaded rbac objects", "roles", len(objects.Roles), "clusterroles", len(objects.ClusterRoles),
+		"rolebindings", len(objects.RoleBindings), "clusterrolebindings", len(objects.ClusterRoleBindings))
+
+	req := who_can.Request{Verb: *verb, Group: *group, Resource: *resource, Namespace: *namespace}
+	if split_names, splited := utils.SplitString(*resource, "/"); splited {
+		req.Resource, req.Subresource = split_names[0], strings.Join(split_names[1:], "/")
+	}
+	grants := who_can.Query(objects, req)
+	switch *format {
+	case "text":
+		who_can.WriteText(os.Stdout, req, grants)
+	case "json":
+		if err := who_can.WriteJson(os.Stdout, grants); err != nil {
+			fmt.Printf("Failed to write report: %s\n", err.Error())
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown format %q\n", *format)
+		return 2
+	}
+	return 0
+}
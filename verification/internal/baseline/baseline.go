@@ -0,0 +1,109 @@
+package baseline
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+/*
+A baseline file lists the known and accepted deviations of a verification run, i.e. the "Name: vv.SubResource" quirk or
+resources gated by an admission webhook, so they do not drown real regressions. A failed review matching a waiver is
+reported as "waived" instead of "failed", unless the waiver has expired, in which case it fails the run again.
+
+	waivers:
+	- group: ""
+	  resource: pods/exec
+	  verb: list
+	  namespace: "*"
+	  owner: vincent1.du@intel.com
+	  justification: pods/exec only supports create and get, k8s auth check still answers yes
+	  expires: "2023-06-30"
+
+"*" matches any value of group, resource, verb or namespace, an empty namespace only matches cluster-scoped reviews.
+The expiry date is optional, a waiver without it never expires.
+*/
+type Waiver struct {
+	Group         string `json:"group"`
+	Resource      string `json:"resource"`
+	Verb          string `json:"verb"`
+	Namespace     string `json:"namespace"`
+	Owner         string `json:"owner"`
+	Justification string `json:"justification"`
+	Expires       string `json:"expires,omitempty"`
+
+	expiry time.Time
+}
+
+type Baseline struct {
+	Waivers []Waiver `json:"waivers"`
+}
+
+const dateLayout = "2006-01-02"
+
+func Load(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Baseline
+	if err := yaml.UnmarshalStrict(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline file %s: %w", path, err)
+	}
+	for i := range b.Waivers {
+		w := &b.Waivers[i]
+		if w.Group == "" && w.Resource == "" && w.Verb == "" {
+			return nil, fmt.Errorf("waiver #%d in %s does not name a group, resource or verb", i, path)
+		}
+		if w.Owner == "" || w.Justification == "" {
+			return nil, fmt.Errorf("waiver #%d in %s (%s) requires an owner and a justification", i, path, w)
+		}
+		if w.Expires != "" {
+			if w.expiry, err = time.Parse(dateLayout, w.Expires); err != nil {
+				return nil, fmt.Errorf("waiver #%d in %s has an invalid expiry date %q, expecting YYYY-MM-DD", i, path, w.Expires)
+			}
+		}
+	}
+	return &b, nil
+}
+
+// Match returns the first unexpired waiver at now covering the review attributes, or nil. An expired waiver is only
+// returned when no unexpired one covers them, the caller decides with Expired() whether the waiver holds.
+func (b *Baseline) Match(group, resource, verb, namespace string, now time.Time) *Waiver {
+	if b == nil {
+		return nil
+	}
+	var expired *Waiver
+	for i := range b.Waivers {
+		w := &b.Waivers[i]
+		if matchField(w.Group, group) && matchField(w.Resource, resource) &&
+			matchField(w.Verb, verb) && matchField(w.Namespace, namespace) {
+			if !w.Expired(now) {
+				return w
+			}
+			if expired == nil {
+				expired = w
+			}
+		}
+	}
+	return expired
+}
+
+// Expired reports whether the waiver is past its expiry date, the expiry day itself is still covered.
+func (w *Waiver) Expired(now time.Time) bool {
+	if w.expiry.IsZero() {
+		return false
+	}
+	return now.After(w.expiry.AddDate(0, 0, 1))
+}
+
+func (w Waiver) String() string {
+	return fmt.Sprintf("{apigroup: %s, resource: %s, verb: %s, namespace: %s}", w.Group, w.Resource, w.Verb, w.Namespace)
+}
+
+func matchField(pattern string, value string) bool {
+	return pattern == "*" || pattern == value
+}
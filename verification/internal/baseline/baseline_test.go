@@ -0,0 +1,118 @@
+package baseline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var baseline_yaml_text = `waivers:
+- group: ""
+  resource: pods/exec
+  verb: list
+  namespace: "*"
+  owner: vdu
+  justification: pods/exec only supports create and get
+- group: admissionregistration.k8s.io
+  resource: "*"
+  verb: create
+  namespace: ""
+  owner: vdu
+  justification: gated by webhook
+  expires: "2023-01-31"
+- group: admissionregistration.k8s.io
+  resource: validatingwebhookconfigurations
+  verb: create
+  namespace: ""
+  owner: vdu
+  justification: gated by webhook, renewed
+  expires: "2023-03-31"
+`
+
+func writeBaseline(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	b, err := Load(writeBaseline(t, baseline_yaml_text))
+	if err != nil {
+		t.Fatalf("Failed to load baseline: %s", err.Error())
+	}
+	if len(b.Waivers) != 3 {
+		t.Errorf("expected 3 waivers, got %d", len(b.Waivers))
+	}
+
+	invalid := []string{
+		"waivers:\n- group: \"\"\n  resource: pods\n  verb: get\n  owner: vdu\n",
+		"waivers:\n- resource: pods\n  verb: get\n  owner: vdu\n  justification: j\n  expires: 31/01/2023\n",
+		"waivers:\n- resource: pods\n  verbs: get\n  owner: vdu\n  justification: j\n",
+		"waivers:\n- owner: vdu\n  justification: j\n",
+	}
+	for _, text := range invalid {
+		if _, err := Load(writeBaseline(t, text)); err == nil {
+			t.Errorf("expected an error loading baseline:\n%s", text)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	b, err := Load(writeBaseline(t, baseline_yaml_text))
+	if err != nil {
+		t.Fatalf("Failed to load baseline: %s", err.Error())
+	}
+
+	inputs := [][4]string{
+		{"", "pods/exec", "list", "smoke-test"},
+		{"", "pods/exec", "list", ""},
+		{"", "pods/exec", "watch", "smoke-test"},
+		{"admissionregistration.k8s.io", "validatingwebhookconfigurations", "create", ""},
+		{"admissionregistration.k8s.io", "validatingwebhookconfigurations", "create", "smoke-test"},
+	}
+	expect := []int{0, 0, -1, 1, -1}
+	now := time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	for i, in := range inputs {
+		w := b.Match(in[0], in[1], in[2], in[3], now)
+		if expect[i] < 0 && w != nil {
+			t.Errorf("expected no waiver for %q, got %s", in, w)
+		} else if expect[i] >= 0 && w != &b.Waivers[expect[i]] {
+			t.Errorf("expected waiver #%d for %q, got %v", expect[i], in, w)
+		}
+	}
+
+	// the expired waiver is passed over for the renewed one, and returned when no other covers the review
+	later := time.Date(2023, 2, 15, 0, 0, 0, 0, time.UTC)
+	if w := b.Match("admissionregistration.k8s.io", "validatingwebhookconfigurations", "create", "", later); w != &b.Waivers[2] {
+		t.Errorf("expected the unexpired waiver #2, got %v", w)
+	}
+	if w := b.Match("admissionregistration.k8s.io", "mutatingwebhookconfigurations", "create", "", later); w != &b.Waivers[1] {
+		t.Errorf("expected the expired waiver #1, got %v", w)
+	}
+
+	var nil_baseline *Baseline
+	if w := nil_baseline.Match("", "pods", "get", "", now); w != nil {
+		t.Errorf("expected no waiver from a nil baseline, got %s", w)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	b, err := Load(writeBaseline(t, baseline_yaml_text))
+	if err != nil {
+		t.Fatalf("Failed to load baseline: %s", err.Error())
+	}
+
+	if b.Waivers[0].Expired(time.Now()) {
+		t.Error("waiver without expiry date should never expire")
+	}
+	if b.Waivers[1].Expired(time.Date(2023, 1, 31, 23, 0, 0, 0, time.UTC)) {
+		t.Error("waiver should still hold on its expiry date")
+	}
+	if !b.Waivers[1].Expired(time.Date(2023, 2, 1, 0, 0, 1, 0, time.UTC)) {
+		t.Error("waiver should have expired the day after its expiry date")
+	}
+}
@@ -0,0 +1,131 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+)
+
+/*
+Report the api resources of the catalog the roles do not decide on explicitly:
+
+	*"ungranted": no role grants any verb on the resource
+	*"wildcard-only": every rule granting the resource, in any role, uses "*" apiGroups, resources or verbs
+	*"new": the resource is missing from the previous catalog, i.e. a new CRD, with its status in the current roles
+*/
+const (
+	StatusUngranted    = "ungranted"
+	StatusWildcardOnly = "wildcard-only"
+	StatusGranted      = "granted"
+)
+
+type Entry struct {
+	Group    string   `json:"group"`
+	Resource string   `json:"resource"`
+	Status   string   `json:"status"`
+	Roles    []string `json:"roles"` // the roles granting the resource
+}
+
+type Report struct {
+	Ungranted    []Entry `json:"ungranted"`
+	WildcardOnly []Entry `json:"wildcardOnly"`
+	New          []Entry `json:"new"`
+}
+
+// Analyze checks the catalog against the roles, previous is the catalog to compare with, nil to skip "new"
+func Analyze(all map[string]proc_rules.ApiGroupValueType, roles []proc_rules.ExpandedRole, previous map[string]proc_rules.ApiGroupValueType) Report {
+	var report Report
+	for k, v := range all {
+		for kk := range v.Resource {
+			e := entryOf(k, kk, roles)
+			switch e.Status {
+			case StatusUngranted:
+				report.Ungranted = append(report.Ungranted, e)
+			case StatusWildcardOnly:
+				report.WildcardOnly = append(report.WildcardOnly, e)
+			}
+			if previous != nil {
+				if _, ok := previous[k].Resource[kk]; !ok {
+					report.New = append(report.New, e)
+				}
+			}
+		}
+	}
+	sortEntries(report.Ungranted)
+	sortEntries(report.WildcardOnly)
+	sortEntries(report.New)
+	return report
+}
+
+func (r Report) WriteText(w io.Writer, with_new bool) {
+	fmt.Fprintf(w, "Granted by no role: %d\n", len(r.Ungranted))
+	for _, e := range r.Ungranted {
+		fmt.Fprintf(w, "  apigroup: %q, resource: %q\n", e.Group, e.Resource)
+	}
+	fmt.Fprintf(w, "Granted through wildcards only: %d\n", len(r.WildcardOnly))
+	for _, e := range r.WildcardOnly {
+		fmt.Fprintf(w, "  apigroup: %q, resource: %q, roles: %v\n", e.Group, e.Resource, e.Roles)
+	}
+	if !with_new {
+		return
+	}
+	fmt.Fprintf(w, "New in the catalog: %d\n", len(r.New))
+	for _, e := range r.New {
+		fmt.Fprintf(w, "  apigroup: %q, resource: %q, %s\n", e.Group, e.Resource, e.Status)
+	}
+}
+
+func (r Report) WriteJson(w io.Writer) error {
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func entryOf(group string, resource string, roles []proc_rules.ExpandedRole) Entry {
+	e := Entry{Group: group, Resource: resource, Status: StatusUngranted}
+	wildcard_only := true
+	for _, role := range roles {
+		res, ok := role.Rules[group].Resource[resource]
+		if !ok || len(res.Verbs) == 0 {
+			continue
+		}
+		e.Roles = append(e.Roles, nameOf(role))
+		for _, verb := range res.Verbs {
+			for _, ref := range role.Origin[proc_rules.PermissionKey{Group: group, Resource: resource, Verb: verb}] {
+				if !ref.Wildcard {
+					wildcard_only = false
+				}
+			}
+		}
+	}
+	if len(e.Roles) > 0 {
+		e.Status = StatusGranted
+		if wildcard_only {
+			e.Status = StatusWildcardOnly
+		}
+	}
+	return e
+}
+
+func nameOf(role proc_rules.ExpandedRole) string {
+	if role.Name != "" {
+		return role.Name
+	}
+	return role.File
+}
+
+func sortEntries(l []Entry) {
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Group != l[j].Group {
+			return l[i].Group < l[j].Group
+		}
+		return l[i].Resource < l[j].Resource
+	})
+}
@@ -0,0 +1,87 @@
+package coverage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var previous_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+bindings                                                v1                                     true         Binding                          [create]`
+
+var current_txt = previous_txt + `
+jobs                              vcjob,vj              batch.volcano.sh/v1alpha1              true         Job                              [delete deletecollection get list patch create update watch]
+mpijobs                                                 kubeflow.org/v1                        true         MPIJob                           [delete deletecollection get list patch create update watch]`
+
+var roles_text = map[string]string{
+	"viewer.yaml": `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: viewer
+rules:
+- apiGroups: [""]
+  resources: ["pods", "bindings"]
+  verbs: ["get"]
+`,
+	"operator.yaml": `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: operator
+rules:
+- apiGroups: ["batch.volcano.sh"]
+  resources: ["*"]
+  verbs: ["get"]
+`,
+}
+
+func TestAnalyze(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "previous.txt"), []byte(previous_txt), 0644)
+	os.WriteFile(filepath.Join(dir, "current.txt"), []byte(current_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "previous.txt"))
+	previous := proc_rules.AllResourcesMap
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "current.txt"))
+
+	var roles []proc_rules.ExpandedRole
+	for _, name := range []string{"viewer.yaml", "operator.yaml"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(roles_text[name]), 0644)
+		role, _ := proc_rules.ExpandRbacYaml(utils.DiscardLogger(), path)
+		roles = append(roles, role)
+	}
+
+	report := Analyze(proc_rules.AllResourcesMap, roles, previous)
+	expect := Report{
+		Ungranted: []Entry{
+			{"", "bindings", StatusUngranted, nil},
+			{"kubeflow.org", "mpijobs", StatusUngranted, nil},
+		},
+		WildcardOnly: []Entry{
+			{"batch.volcano.sh", "jobs", StatusWildcardOnly, []string{"operator"}},
+		},
+		New: []Entry{
+			{"batch.volcano.sh", "jobs", StatusWildcardOnly, []string{"operator"}},
+			{"kubeflow.org", "mpijobs", StatusUngranted, nil},
+		},
+	}
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, report)
+	}
+
+	var b bytes.Buffer
+	report.WriteText(&b, false)
+	if strings.Contains(b.String(), "New in the catalog") {
+		t.Errorf("expected no new resources section without a previous catalog:\n%s", b.String())
+	}
+
+	if report := Analyze(proc_rules.AllResourcesMap, roles, nil); report.New != nil {
+		t.Errorf("expected no new resources without a previous catalog, got %+v", report.New)
+	}
+}
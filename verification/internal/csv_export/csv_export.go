@@ -0,0 +1,107 @@
+package csv_export
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+/*
+Export the expanded allowed and forbidden sets, as computed by FilterRules, into a spreadsheet with one row per
+apigroup/version/resource/subresource/verb/namespace:
+
+	apigroup,version,resource,subresource,verb,namespace,kind,namespaced,expected,actual,outcome
+	,v1,pods,exec,create,smoke-test,PodExecOptions,true,false,true,failed
+
+Namespaced resources get one row per namespace, cluster-scoped resources a single row with an empty namespace. The
+resources of SpecialVerbs have no version, they get rows with an empty version. "expected" is true for the allowed set,
+false for the forbidden set. "actual" and "outcome" are filled in from the verification results when available, they
+are empty otherwise. Rows are sorted so diffs in git stay readable.
+*/
+var Header = []string{"apigroup", "version", "resource", "subresource", "verb", "namespace", "kind", "namespaced", "expected", "actual", "outcome"}
+
+type resultKey struct {
+	group, resource, verb, namespace string
+}
+
+func Write(w io.Writer, allowed map[string]proc_rules.ApiGroupValueType, forbidden map[string]proc_rules.ApiGroupValueType, namespaces []string, results []verify.ReviewResult) error {
+	reviewed := make(map[resultKey]verify.ReviewResult)
+	for _, r := range results {
+		if r.Persona != "" || r.Description != "" {
+			// expectation reviews are not part of the catalog sweep
+			continue
+		}
+		reviewed[resultKey{r.Group, r.Resource, r.Verb, r.Namespace}] = r
+	}
+
+	var rows [][]string
+	appendRows := func(rules map[string]proc_rules.ApiGroupValueType, expected bool) {
+		for k, v := range rules {
+			for kk, vv := range v.Resource {
+				resource := kk
+				if split_names, splited := utils.SplitString(kk, "/"); splited {
+					resource = split_names[0]
+				}
+				row_namespaces := []string{""}
+				if vv.Namespaced {
+					row_namespaces = namespaces
+				}
+				// the SpecialVerbs resources, i.e. users, have no version
+				versions := vv.Versions
+				if len(versions) == 0 {
+					versions = []string{""}
+				}
+				for _, version := range versions {
+					for _, verb := range vv.Verbs {
+						for _, ns := range row_namespaces {
+							actual, outcome := "", ""
+							if r, ok := reviewed[resultKey{k, kk, verb, ns}]; ok {
+								actual = strconv.FormatBool(r.Allowed)
+								outcome = r.Outcome
+							}
+							rows = append(rows, []string{
+								k,
+								version,
+								resource,
+								vv.SubResource,
+								verb,
+								ns,
+								vv.Kind,
+								strconv.FormatBool(vv.Namespaced),
+								strconv.FormatBool(expected),
+								actual,
+								outcome,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	appendRows(allowed, true)
+	appendRows(forbidden, false)
+
+	// sort by the key columns, apigroup to namespace
+	sort.Slice(rows, func(i, j int) bool {
+		for c := 0; c < 6; c++ {
+			if rows[i][c] != rows[j][c] {
+				return rows[i][c] < rows[j][c]
+			}
+		}
+		return rows[i][8] < rows[j][8]
+	})
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(Header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
@@ -0,0 +1,58 @@
+package csv_export
+
+import (
+	"bytes"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+)
+
+var allowed = map[string]proc_rules.ApiGroupValueType{
+	"": {Resource: map[string]proc_rules.ResourceValueType{
+		"pods/exec": {SubResource: "exec", Versions: []string{"v1"}, Kind: "PodExecOptions", Namespaced: true, Verbs: []string{"get"}},
+		"users":     {Verbs: []string{"impersonate"}},
+	}},
+	"discovery.k8s.io": {Resource: map[string]proc_rules.ResourceValueType{
+		"endpointslices": {Versions: []string{"v1", "v1beta1"}, Kind: "EndpointSlice", Namespaced: true, Verbs: []string{"list"}},
+	}},
+}
+
+var forbidden = map[string]proc_rules.ApiGroupValueType{
+	"": {Resource: map[string]proc_rules.ResourceValueType{
+		"pods/exec": {SubResource: "exec", Versions: []string{"v1"}, Kind: "PodExecOptions", Namespaced: true, Verbs: []string{"create"}},
+	}},
+	"crd.projectcalico.org": {Resource: map[string]proc_rules.ResourceValueType{
+		"ippools": {Versions: []string{"v1"}, Kind: "IPPool", Namespaced: false, Verbs: []string{"delete"}},
+	}},
+}
+
+var results = []verify.ReviewResult{
+	{Group: "", Resource: "pods/exec", Subresource: "exec", Name: "exec", Namespace: "smoke-test", Verb: "create", Expected: false, Allowed: true, Outcome: verify.OutcomeFailed},
+	{Group: "crd.projectcalico.org", Resource: "ippools", Namespace: "", Verb: "delete", Expected: false, Allowed: false, Outcome: verify.OutcomePassed},
+}
+
+var expected_csv = `apigroup,version,resource,subresource,verb,namespace,kind,namespaced,expected,actual,outcome
+,,users,,impersonate,,,false,true,,
+,v1,pods,exec,create,mldev,PodExecOptions,true,false,,
+,v1,pods,exec,create,smoke-test,PodExecOptions,true,false,true,failed
+,v1,pods,exec,get,mldev,PodExecOptions,true,true,,
+,v1,pods,exec,get,smoke-test,PodExecOptions,true,true,,
+crd.projectcalico.org,v1,ippools,,delete,,IPPool,false,false,false,passed
+discovery.k8s.io,v1,endpointslices,,list,mldev,EndpointSlice,true,true,,
+discovery.k8s.io,v1,endpointslices,,list,smoke-test,EndpointSlice,true,true,,
+discovery.k8s.io,v1beta1,endpointslices,,list,mldev,EndpointSlice,true,true,,
+discovery.k8s.io,v1beta1,endpointslices,,list,smoke-test,EndpointSlice,true,true,,
+`
+
+func TestWrite(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		var b bytes.Buffer
+		if err := Write(&b, allowed, forbidden, []string{"smoke-test", "mldev"}, results); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != expected_csv {
+			t.Fatalf("expected:\n%s\ngot\n%s", expected_csv, b.String())
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package defaults
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slog"
+)
+
+/*
+The upstream default ClusterRoles admin, edit, view and cluster-admin, as aggregated by a cluster without add-ons, one
+directory per supported Kubernetes minor version: roles/<version>/<name>.yaml, each file noting its upstream source.
+Add a version by dumping the roles of a plain cluster of that version, i.e. "kubectl get clusterrole edit -o yaml", and
+removing the rules aggregated from add-ons.
+
+Our roles are described against them as "default X plus these additions minus these removals", both expanded against
+the same resource catalog.
+*/
+//go:embed roles
+var roles embed.FS
+
+// Versions lists the embedded Kubernetes minor versions, sorted
+func Versions() []string {
+	entries, _ := roles.ReadDir("roles")
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() {
+			versions = append(versions, e.Name())
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return lessVersion(versions[i], versions[j]) })
+	return versions
+}
+
+// Names lists the default roles embedded for the version, sorted
+func Names(version string) []string {
+	entries, _ := roles.ReadDir(path.Join("roles", version))
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".yaml") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load expands the default role against the current AllResourcesMap, its origins are located in "default/<version>/<name>.yaml"
+func Load(logger *slog.Logger, version string, name string) (proc_rules.ExpandedRole, error) {
+	f, err := roles.Open(path.Join("roles", version, name+".yaml"))
+	if err != nil {
+		return proc_rules.ExpandedRole{}, fmt.Errorf("no default role %q for kubernetes %s, embedded versions: %v", name, version, Versions())
+	}
+	defer f.Close()
+	role, _ := proc_rules.ExpandRbacYamlReader(logger, path.Join("default", version, name+".yaml"), f)
+	return role, nil
+}
+
+// AddTo adds the default roles of the version missing from objects, i.e. a builtin "view" bound by the files, their
+// origins in "default/<version>/<name>.yaml"
+func AddTo(objects *rbac_objects.Objects, version string) error {
+	for _, name := range Names(version) {
+		if _, ok := objects.ClusterRole(name); ok {
+			continue
+		}
+		f, err := roles.Open(path.Join("roles", version, name+".yaml"))
+		if err != nil {
+			return err
+		}
+		err = objects.Decode(path.Join("default", version, name+".yaml"), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Delta struct {
+	Group    string   `json:"group"`
+	Resource string   `json:"resource"`
+	Verbs    []string `json:"verbs"`
+}
+
+type Comparison struct {
+	Role      string  `json:"role"`
+	File      string  `json:"file"`
+	Default   string  `json:"default"`
+	Version   string  `json:"version"`
+	Additions []Delta `json:"additions"`
+	Removals  []Delta `json:"removals"`
+}
+
+func Compare(role proc_rules.ExpandedRole, base proc_rules.ExpandedRole, version string) Comparison {
+	return Comparison{
+		Role:      role.Name,
+		File:      role.File,
+		Default:   base.Name,
+		Version:   version,
+		Additions: deltasOf(proc_rules.SubtractRules(role.Rules, base.Rules)),
+		Removals:  deltasOf(proc_rules.SubtractRules(base.Rules, role.Rules)),
+	}
+}
+
+// Closest compares the role with each of the bases and returns the comparison with the fewest granted or revoked verbs
+func Closest(role proc_rules.ExpandedRole, bases []proc_rules.ExpandedRole, version string) Comparison {
+	var best Comparison
+	best_count := -1
+	for _, base := range bases {
+		c := Compare(role, base, version)
+		if count := c.count(); best_count < 0 || count < best_count {
+			best, best_count = c, count
+		}
+	}
+	return best
+}
+
+func WriteText(w io.Writer, comparisons []Comparison) {
+	for _, c := range comparisons {
+		fmt.Fprintf(w, "%s = default %s (kubernetes %s) + %d addition(s) - %d removal(s)\n", c.Role, c.Default, c.Version, len(c.Additions), len(c.Removals))
+		for _, d := range c.Additions {
+			fmt.Fprintf(w, "  + apigroup: %q, resource: %q, verbs: %v\n", d.Group, d.Resource, d.Verbs)
+		}
+		for _, d := range c.Removals {
+			fmt.Fprintf(w, "  - apigroup: %q, resource: %q, verbs: %v\n", d.Group, d.Resource, d.Verbs)
+		}
+	}
+}
+
+func WriteJson(w io.Writer, comparisons []Comparison) error {
+	if comparisons == nil {
+		comparisons = []Comparison{}
+	}
+	j, err := json.MarshalIndent(comparisons, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func (c Comparison) count() int {
+	count := 0
+	for _, d := range c.Additions {
+		count += len(d.Verbs)
+	}
+	for _, d := range c.Removals {
+		count += len(d.Verbs)
+	}
+	return count
+}
+
+func deltasOf(rules map[string]proc_rules.ApiGroupValueType) []Delta {
+	var deltas []Delta
+	for k, v := range rules {
+		for kk, vv := range v.Resource {
+			if len(vv.Verbs) == 0 {
+				continue
+			}
+			verbs := append([]string{}, vv.Verbs...)
+			sort.Strings(verbs)
+			deltas = append(deltas, Delta{k, kk, verbs})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].Group != deltas[j].Group {
+			return deltas[i].Group < deltas[j].Group
+		}
+		return deltas[i].Resource < deltas[j].Resource
+	})
+	return deltas
+}
+
+// lessVersion orders "1.9" before "1.23"
+func lessVersion(a string, b string) bool {
+	var a_major, a_minor, b_major, b_minor int
+	fmt.Sscanf(a, "%d.%d", &a_major, &a_minor)
+	fmt.Sscanf(b, "%d.%d", &b_major, &b_minor)
+	if a_major != b_major {
+		return a_major < b_major
+	}
+	return a_minor < b_minor
+}
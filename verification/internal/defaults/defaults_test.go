@@ -0,0 +1,115 @@
+package defaults
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/log                                                v1                                     true         Pod                              [get]
+secrets                                                 v1                                     true         Secret                           [create delete deletecollection get list patch update watch]
+resourcequotas                    quota                 v1                                     true         ResourceQuota                    [create delete deletecollection get list patch update watch]
+mpijobs                                                 kubeflow.org/v1                        true         MPIJob                           [delete deletecollection get list patch create update watch]`
+
+// view, plus write access to resource quotas and mpijobs, minus pods/log
+var role_text = `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: namespace-viewer
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: [""]
+  resources: ["resourcequotas"]
+  verbs: ["get", "list", "watch", "update"]
+- apiGroups: ["kubeflow.org"]
+  resources: ["mpijobs"]
+  verbs: ["get"]
+`
+
+func TestVersionsAndNames(t *testing.T) {
+	versions := Versions()
+	if !reflect.DeepEqual(versions, []string{"1.23", "1.24"}) {
+		t.Errorf("unexpected versions %v", versions)
+	}
+	for _, v := range versions {
+		if names := Names(v); !reflect.DeepEqual(names, []string{"admin", "cluster-admin", "edit", "view"}) {
+			t.Errorf("unexpected default roles %v for %s", names, v)
+		}
+	}
+	if !lessVersion("1.9", "1.23") || lessVersion("1.23", "1.9") {
+		t.Error("expected versions to be compared numerically")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+
+	var bases []proc_rules.ExpandedRole
+	for _, name := range Names("1.24") {
+		base, err := Load(utils.DiscardLogger(), "1.24", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bases = append(bases, base)
+	}
+	if _, err := Load(utils.DiscardLogger(), "1.0", "view"); err == nil {
+		t.Error("expected an error for a version not embedded")
+	}
+
+	path := filepath.Join(dir, "role.yaml")
+	os.WriteFile(path, []byte(role_text), 0644)
+	role, _ := proc_rules.ExpandRbacYaml(utils.DiscardLogger(), path)
+
+	c := Closest(role, bases, "1.24")
+	expect := Comparison{
+		Role:    "namespace-viewer",
+		File:    path,
+		Default: "view",
+		Version: "1.24",
+		Additions: []Delta{
+			{"", "resourcequotas", []string{"update"}},
+			{"kubeflow.org", "mpijobs", []string{"get"}},
+		},
+		Removals: []Delta{
+			{"", "pods/log", []string{"get"}},
+		},
+	}
+	if !reflect.DeepEqual(c, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, c)
+	}
+
+	if c := Compare(role, bases[1], "1.24"); c.Default != "cluster-admin" || len(c.Additions) != 0 || len(c.Removals) != 5 {
+		t.Errorf("expected cluster-admin to hold every permission, got %+v", c)
+	}
+}
+
+func TestAddTo(t *testing.T) {
+	objects := rbac_objects.New()
+	if err := objects.Decode("rbac.yaml", strings.NewReader(role_text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTo(objects, "1.24"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects.ClusterRole("view"); !ok {
+		t.Errorf("expected the default view role")
+	}
+	if origin := objects.Source["ClusterRole/view"]; origin.File != "default/1.24/view.yaml" {
+		t.Errorf("unexpected origin %+v", origin)
+	}
+	if len(objects.ClusterRoles) != len(Names("1.24"))+1 {
+		t.Errorf("expected the default roles added once, got %d roles", len(objects.ClusterRoles))
+	}
+}
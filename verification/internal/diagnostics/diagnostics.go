@@ -0,0 +1,129 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+/*
+A Diagnostic is a finding on a rbac yaml file, located by file, document index (starting at 0, for "---" separated
+documents) and rule index within the "rules" of the document, -1 when the finding is not about a single rule.
+
+Each rule ID has a default severity, which can be overridden, i.e. "unavailable-verb=error,unknown-resource=off".
+*/
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+	SeverityOff     Severity = "off"
+)
+
+type Diagnostic struct {
+	RuleID    string   `json:"ruleId"`
+	Severity  Severity `json:"severity"`
+	Message   string   `json:"message"`
+	File      string   `json:"file"`
+	Document  int      `json:"document"`
+	RuleIndex int      `json:"ruleIndex"`
+}
+
+// Rule describes a rule ID, i.e. for the rules metadata of SARIF reports
+type Rule struct {
+	ID          string
+	Severity    Severity
+	Description string
+}
+
+func (d Diagnostic) String() string {
+	location := fmt.Sprintf("%s: document %d", d.File, d.Document)
+	if d.RuleIndex >= 0 {
+		location += fmt.Sprintf(", rule %d", d.RuleIndex)
+	}
+	return fmt.Sprintf("%s: %s: %s [%s]", location, d.Severity, d.Message, d.RuleID)
+}
+
+// ParseSeverities parses the "ruleID=severity,..." overrides
+func ParseSeverities(str string) (map[string]Severity, error) {
+	overrides := make(map[string]Severity)
+	if strings.TrimSpace(str) == "" {
+		return overrides, nil
+	}
+	entries, _ := utils.SplitString(str, ",")
+	for _, entry := range entries {
+		kv, splited := utils.SplitString(entry, "=")
+		if !splited || len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid severity override %q, expecting ruleID=severity", entry)
+		}
+		switch s := Severity(strings.ToLower(kv[1])); s {
+		case SeverityError, SeverityWarning, SeverityInfo, SeverityOff:
+			overrides[kv[0]] = s
+		default:
+			return nil, fmt.Errorf("invalid severity %q for %s, expecting error, warning, info or off", kv[1], kv[0])
+		}
+	}
+	return overrides, nil
+}
+
+// ApplySeverities overrides the default severities and drops the diagnostics turned off
+func ApplySeverities(diags []Diagnostic, overrides map[string]Severity) []Diagnostic {
+	var ret []Diagnostic
+	for _, d := range diags {
+		if s, ok := overrides[d.RuleID]; ok {
+			d.Severity = s
+		}
+		if d.Severity != SeverityOff {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func Count(diags []Diagnostic, severity Severity) int {
+	count := 0
+	for _, d := range diags {
+		if d.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// Sort orders the diagnostics by location
+func Sort(diags []Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Document != b.Document {
+			return a.Document < b.Document
+		}
+		return a.RuleIndex < b.RuleIndex
+	})
+}
+
+func WriteText(w io.Writer, diags []Diagnostic) {
+	for _, d := range diags {
+		fmt.Fprintln(w, d.String())
+	}
+	fmt.Fprintf(w, "%d error(s), %d warning(s), %d info(s)\n", Count(diags, SeverityError), Count(diags, SeverityWarning), Count(diags, SeverityInfo))
+}
+
+func WriteJson(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	j, err := json.MarshalIndent(diags, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
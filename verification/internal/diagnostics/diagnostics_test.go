@@ -0,0 +1,50 @@
+package diagnostics
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSeverities(t *testing.T) {
+	overrides, err := ParseSeverities("unavailable-verb=error, unknown-resource=OFF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]Severity{"unavailable-verb": SeverityError, "unknown-resource": SeverityOff}
+	if !reflect.DeepEqual(overrides, expect) {
+		t.Errorf("expected %v, got %v", expect, overrides)
+	}
+
+	if overrides, err := ParseSeverities(""); err != nil || len(overrides) != 0 {
+		t.Errorf("expected no overrides, got %v, %v", overrides, err)
+	}
+	for _, invalid := range []string{"unavailable-verb", "unavailable-verb=fatal", "=error"} {
+		if _, err := ParseSeverities(invalid); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestApplySeverities(t *testing.T) {
+	diags := []Diagnostic{
+		{RuleID: "unknown-resource", Severity: SeverityError, File: "b.yaml", RuleIndex: 1},
+		{RuleID: "unavailable-verb", Severity: SeverityWarning, File: "a.yaml", RuleIndex: 2},
+		{RuleID: "unknown-apigroup", Severity: SeverityError, File: "a.yaml", RuleIndex: 0},
+	}
+	ret := ApplySeverities(diags, map[string]Severity{"unknown-resource": SeverityOff, "unavailable-verb": SeverityError})
+	Sort(ret)
+	if len(ret) != 2 || ret[0].RuleID != "unknown-apigroup" || ret[1].Severity != SeverityError {
+		t.Errorf("unexpected diagnostics %v", ret)
+	}
+	if Count(ret, SeverityError) != 2 || Count(ret, SeverityWarning) != 0 {
+		t.Errorf("unexpected counts of %v", ret)
+	}
+
+	var b bytes.Buffer
+	WriteText(&b, ret)
+	if !strings.Contains(b.String(), `a.yaml: document 0, rule 2: error: `) || !strings.Contains(b.String(), "2 error(s), 0 warning(s), 0 info(s)") {
+		t.Errorf("unexpected text output:\n%s", b.String())
+	}
+}
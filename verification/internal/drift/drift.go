@@ -0,0 +1,288 @@
+package drift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/reconcile"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/role_diff"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Compare the Roles, ClusterRoles and bindings of the files with the live objects of the same name, semantically:
+
+	*roles: both versions are expanded against the resource catalog parsed by ParseAllApiresources and diffed with
+	 role_diff, i.e. "*" and the explicit list of resources compare equal, a change is granted or revoked in the cluster
+	*bindings: the roleRef, and the subjects added or removed in the cluster
+	*missing: the object of the files is not in the cluster
+
+The rules restricted to resourceNames, and the nonResourceURLs rules, are not expanded: they are split per apigroup,
+resource and name, or per url, like reconcile.PermissionsOf, and compared as written.
+The rules of a ClusterRole with an aggregationRule are filled by the aggregation controller, only its selectors are
+compared. Then, the live bindings not in the files naming a group with the OIDC prefix, i.e. "oidc:", are reported as
+undescribed: they grant our groups something the repo does not describe.
+*/
+type RoleDrift struct {
+	Kind               string           `json:"kind"`
+	Namespace          string           `json:"namespace,omitempty"`
+	Name               string           `json:"name"`
+	Source             string           `json:"source"`
+	Missing            bool             `json:"missing"`
+	AggregationChanged bool             `json:"aggregationChanged,omitempty"`
+	Diff               role_diff.Report `json:"diff"`                 // Old is the files, New the cluster
+	Restricted         []string         `json:"restricted,omitempty"` // i.e. "+ nonResourceURL \"/metrics\" verbs: [get]", granted in the cluster
+}
+
+type BindingDrift struct {
+	Kind            string   `json:"kind"`
+	Namespace       string   `json:"namespace,omitempty"`
+	Name            string   `json:"name"`
+	Source          string   `json:"source"`
+	Missing         bool     `json:"missing"`
+	RoleRef         string   `json:"roleRef,omitempty"` // i.e. "ClusterRole/view -> ClusterRole/admin", when changed
+	AddedSubjects   []string `json:"addedSubjects,omitempty"`
+	RemovedSubjects []string `json:"removedSubjects,omitempty"`
+}
+
+type Undescribed struct {
+	Binding string   `json:"binding"`
+	RoleRef string   `json:"roleRef"`
+	Groups  []string `json:"groups"`
+}
+
+type Report struct {
+	Roles       []RoleDrift    `json:"roles"`
+	Bindings    []BindingDrift `json:"bindings"`
+	Undescribed []Undescribed  `json:"undescribed"`
+}
+
+func Compare(logger *slog.Logger, files *rbac_objects.Objects, live *rbac_objects.Objects, prefix string) Report {
+	report := Report{Roles: []RoleDrift{}, Bindings: []BindingDrift{}, Undescribed: []Undescribed{}}
+
+	for _, f := range files.ClusterRoles {
+		l, found := live.ClusterRole(f.Name)
+		d := RoleDrift{Kind: "ClusterRole", Name: f.Name, Source: files.Source[rbac_objects.KeyOf("ClusterRole", "", f.Name)].String(), Missing: !found}
+		if found {
+			if f.AggregationRule != nil || l.AggregationRule != nil {
+				d.AggregationChanged = !reflect.DeepEqual(f.AggregationRule, l.AggregationRule)
+			}
+			if f.AggregationRule == nil {
+				d.Diff, d.Restricted = diffRules(logger, files, "ClusterRole", "", f.Name, f.Rules, l.Rules)
+			}
+		}
+		if d.Missing || d.AggregationChanged || !d.Diff.Empty() || len(d.Restricted) > 0 {
+			report.Roles = append(report.Roles, d)
+		}
+	}
+	for _, f := range files.Roles {
+		l, found := live.Role(f.Namespace, f.Name)
+		d := RoleDrift{Kind: "Role", Namespace: f.Namespace, Name: f.Name, Source: files.Source[rbac_objects.KeyOf("Role", f.Namespace, f.Name)].String(), Missing: !found}
+		if found {
+			d.Diff, d.Restricted = diffRules(logger, files, "Role", f.Namespace, f.Name, f.Rules, l.Rules)
+		}
+		if d.Missing || !d.Diff.Empty() || len(d.Restricted) > 0 {
+			report.Roles = append(report.Roles, d)
+		}
+	}
+
+	live_bindings := make(map[string]rbacv1.RoleBinding)
+	for _, l := range live.RoleBindings {
+		live_bindings[rbac_objects.KeyOf("RoleBinding", l.Namespace, l.Name)] = l
+	}
+	for _, l := range live.ClusterRoleBindings {
+		live_bindings[rbac_objects.KeyOf("ClusterRoleBinding", "", l.Name)] = rbacv1.RoleBinding{ObjectMeta: l.ObjectMeta, RoleRef: l.RoleRef, Subjects: l.Subjects}
+	}
+	compare := func(kind string, namespace string, name string, ref rbacv1.RoleRef, subjects []rbacv1.Subject) {
+		key := rbac_objects.KeyOf(kind, namespace, name)
+		l, found := live_bindings[key]
+		d := BindingDrift{Kind: kind, Namespace: namespace, Name: name, Source: files.Source[key].String(), Missing: !found}
+		if found {
+			if ref.Kind != l.RoleRef.Kind || ref.Name != l.RoleRef.Name {
+				d.RoleRef = fmt.Sprintf("%s/%s -> %s/%s", ref.Kind, ref.Name, l.RoleRef.Kind, l.RoleRef.Name)
+			}
+			file_subjects, live_subjects := reconcile.SubjectsOf(subjects), reconcile.SubjectsOf(l.Subjects)
+			d.AddedSubjects = subtract(live_subjects, file_subjects)
+			d.RemovedSubjects = subtract(file_subjects, live_subjects)
+		}
+		if d.Missing || d.RoleRef != "" || len(d.AddedSubjects) > 0 || len(d.RemovedSubjects) > 0 {
+			report.Bindings = append(report.Bindings, d)
+		}
+	}
+	for _, f := range files.ClusterRoleBindings {
+		compare("ClusterRoleBinding", "", f.Name, f.RoleRef, f.Subjects)
+	}
+	for _, f := range files.RoleBindings {
+		compare("RoleBinding", f.Namespace, f.Name, f.RoleRef, f.Subjects)
+	}
+
+	keys := make([]string, 0, len(live_bindings))
+	for key := range live_bindings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := files.Source[key]; ok {
+			continue
+		}
+		l := live_bindings[key]
+		var groups []string
+		for _, s := range l.Subjects {
+			if s.Kind == rbacv1.GroupKind && strings.HasPrefix(s.Name, prefix) {
+				groups = append(groups, s.Name)
+			}
+		}
+		if len(groups) > 0 {
+			ref_namespace := ""
+			if l.RoleRef.Kind == "Role" {
+				ref_namespace = l.Namespace
+			}
+			report.Undescribed = append(report.Undescribed, Undescribed{key, rbac_objects.KeyOf(l.RoleRef.Kind, ref_namespace, l.RoleRef.Name), groups})
+		}
+	}
+	return report
+}
+
+// Drifted reports whether the cluster differs from the files
+func (r Report) Drifted() bool {
+	return len(r.Roles) > 0 || len(r.Bindings) > 0 || len(r.Undescribed) > 0
+}
+
+func WriteText(w io.Writer, r Report) {
+	for _, d := range r.Roles {
+		fmt.Fprintf(w, "%s (%s)\n", rbac_objects.KeyOf(d.Kind, d.Namespace, d.Name), d.Source)
+		if d.Missing {
+			fmt.Fprintf(w, "  missing from the cluster\n")
+			continue
+		}
+		if d.AggregationChanged {
+			fmt.Fprintf(w, "  ~ aggregationRule\n")
+		}
+		for _, c := range d.Diff.Granted {
+			fmt.Fprintf(w, "  + apigroup: %q, resource: %q, verb: %q, granted in the cluster\n", c.Group, c.Resource, c.Verb)
+		}
+		for _, c := range d.Diff.Revoked {
+			fmt.Fprintf(w, "  - apigroup: %q, resource: %q, verb: %q, revoked in the cluster\n", c.Group, c.Resource, c.Verb)
+		}
+		for _, c := range d.Restricted {
+			if strings.HasPrefix(c, "+") {
+				fmt.Fprintf(w, "  %s, granted in the cluster\n", c)
+			} else {
+				fmt.Fprintf(w, "  %s, revoked in the cluster\n", c)
+			}
+		}
+	}
+	for _, d := range r.Bindings {
+		fmt.Fprintf(w, "%s (%s)\n", rbac_objects.KeyOf(d.Kind, d.Namespace, d.Name), d.Source)
+		if d.Missing {
+			fmt.Fprintf(w, "  missing from the cluster\n")
+			continue
+		}
+		if d.RoleRef != "" {
+			fmt.Fprintf(w, "  ~ roleRef %s\n", d.RoleRef)
+		}
+		for _, s := range d.AddedSubjects {
+			fmt.Fprintf(w, "  + subject %s, added in the cluster\n", s)
+		}
+		for _, s := range d.RemovedSubjects {
+			fmt.Fprintf(w, "  - subject %s, removed in the cluster\n", s)
+		}
+	}
+	for _, u := range r.Undescribed {
+		fmt.Fprintf(w, "%s -> %s (cluster)\n  not in the files, grants %s\n", u.Binding, u.RoleRef, strings.Join(u.Groups, ", "))
+	}
+	fmt.Fprintf(w, "%d role(s) and %d binding(s) drifted, %d undescribed binding(s)\n", len(r.Roles), len(r.Bindings), len(r.Undescribed))
+}
+
+func WriteJson(w io.Writer, r Report) error {
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+
+/*
+diffRules expands both versions of the rules like role files, the origins of the files version point to its file. The
+restricted rules are compared as written, "+" when granted in the cluster, "-" when revoked.
+*/
+func diffRules(logger *slog.Logger, files *rbac_objects.Objects, kind string, namespace string, name string,
+	file_rules []rbacv1.PolicyRule, live_rules []rbacv1.PolicyRule) (role_diff.Report, []string) {
+	key := rbac_objects.KeyOf(kind, namespace, name)
+	origin := files.Source[key]
+	file_expandable, file_restricted := splitRestricted(file_rules)
+	live_expandable, live_restricted := splitRestricted(live_rules)
+	file_role := expand(logger, origin.String(), kind, name, file_expandable)
+	for k, refs := range file_role.Origin {
+		for i := range refs {
+			refs[i].File, refs[i].Document = origin.File, origin.Document
+		}
+		file_role.Origin[k] = refs
+	}
+	live_role := expand(logger, rbac_objects.OriginCluster.String(), kind, name, live_expandable)
+
+	file_permissions, live_permissions := reconcile.PermissionsOf(file_restricted), reconcile.PermissionsOf(live_restricted)
+	restricted := reconcile.DiffPermissions(live_permissions, file_permissions, "+")
+	restricted = append(restricted, reconcile.DiffPermissions(file_permissions, live_permissions, "-")...)
+	return role_diff.Diff(file_role, live_role), restricted
+}
+
+// splitRestricted separates the rules restricted to resourceNames or granting nonResourceURLs, a rule granting both
+// resources and nonResourceURLs is invalid and kept whole as restricted
+func splitRestricted(rules []rbacv1.PolicyRule) ([]rbacv1.PolicyRule, []rbacv1.PolicyRule) {
+	var expandable, restricted []rbacv1.PolicyRule
+	for _, r := range rules {
+		if len(r.ResourceNames) > 0 || len(r.NonResourceURLs) > 0 {
+			restricted = append(restricted, r)
+		} else {
+			expandable = append(expandable, r)
+		}
+	}
+	return expandable, restricted
+}
+
+func expand(logger *slog.Logger, label string, kind string, name string, rules []rbacv1.PolicyRule) proc_rules.ExpandedRole {
+	role := rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      rules,
+	}
+	if role.Rules == nil {
+		role.Rules = []rbacv1.PolicyRule{}
+	}
+	data, err := yaml.Marshal(role)
+	if err != nil {
+		logger.Error("Failed to marshal rules", err, "kind", kind, "name", name, "origin", label)
+	}
+	expanded, diags := proc_rules.ExpandRbacYamlReader(logger, label, bytes.NewReader(data))
+	// i.e. a rule naming a resource missing from the catalog, its permissions are not compared
+	for _, d := range diags {
+		logger.Warn("Found issue expanding rules", "kind", kind, "name", name, "diagnostic", d.String())
+	}
+	return expanded
+}
+
+// subtract lists the values of a missing from b
+func subtract(a []string, b []string) []string {
+	var ret []string
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,212 @@
+package drift
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+nodes                             no                    v1                                     false        Node                             [create delete deletecollection get list patch update watch]
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+services                          svc                   v1                                     true         Service                          [create delete get list patch update watch]`
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: [""]
+  resources: ["*"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: node-viewer
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: monitoring
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.example.com/aggregate-to-monitoring: "true"
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-namespace-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: node-viewer
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:cluster-operator
+`
+
+// the live objects: namespace-admin with the namespaced resources listed, node-viewer granted watch, monitoring aggregated with
+// its rules filled, the mldev binding with an extra user, node-viewer missing, and two bindings not in the files
+func live(t *testing.T) *rbac_objects.Objects {
+	ref := func(name string) rbacv1.RoleRef {
+		return rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+	}
+	clientset := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "namespace-admin"}, Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"services", "pods"}, Verbs: []string{"list", "get"}},
+		}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "node-viewer"}, Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch"}},
+		}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+			AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"rbac.example.com/aggregate-to-monitoring": "true"}},
+			}},
+			Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "mldev-namespace-admin", Namespace: "mldev"}, RoleRef: ref("namespace-admin"),
+			Subjects: []rbacv1.Subject{{Kind: "Group", Name: "oidc:mldev-namespace-admin"}, {Kind: "User", Name: "alice"}}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "mldev"}, RoleRef: ref("edit"),
+			Subjects: []rbacv1.Subject{{Kind: "Group", Name: "oidc:mldev-namespace-viewer"}}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "system:basic-user"}, RoleRef: ref("system:basic-user"),
+			Subjects: []rbacv1.Subject{{Kind: "Group", Name: "system:authenticated"}}},
+	)
+	objects, err := rbac_objects.LoadClientset(context.Background(), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+
+	files := rbac_objects.New()
+	if err := files.Decode("rbac.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	report := Compare(utils.DiscardLogger(), files, live(t), "oidc:")
+
+	// namespace-admin "*" expands to the listed resources, only nodes are revoked
+	if len(report.Roles) != 2 {
+		t.Fatalf("expected 2 drifted roles, got %+v", report.Roles)
+	}
+	admin := report.Roles[0]
+	if admin.Name != "namespace-admin" || len(admin.Diff.Revoked) != 2 || admin.Diff.Revoked[0].Resource != "nodes" || len(admin.Diff.Granted) != 0 {
+		t.Errorf("unexpected namespace-admin drift %+v", admin)
+	}
+	if admin.Source != "rbac.yaml: document 0" || admin.Diff.Revoked[0].Rules[0].File != "rbac.yaml" {
+		t.Errorf("unexpected source %q, %+v", admin.Source, admin.Diff.Revoked[0].Rules)
+	}
+	viewer := report.Roles[1]
+	if viewer.Name != "node-viewer" || len(viewer.Diff.Granted) != 1 || viewer.Diff.Granted[0].Verb != "watch" {
+		t.Errorf("unexpected node-viewer drift %+v", viewer)
+	}
+
+	expect_bindings := []BindingDrift{
+		{Kind: "ClusterRoleBinding", Name: "node-viewer", Source: "rbac.yaml: document 4", Missing: true},
+		{Kind: "RoleBinding", Namespace: "mldev", Name: "mldev-namespace-admin", Source: "rbac.yaml: document 3", AddedSubjects: []string{"User alice"}},
+	}
+	if !reflect.DeepEqual(report.Bindings, expect_bindings) {
+		t.Errorf("expected bindings %+v, got %+v", expect_bindings, report.Bindings)
+	}
+	expect_undescribed := []Undescribed{{"RoleBinding/mldev/debug", "ClusterRole/edit", []string{"oidc:mldev-namespace-viewer"}}}
+	if !reflect.DeepEqual(report.Undescribed, expect_undescribed) {
+		t.Errorf("expected undescribed %+v, got %+v", expect_undescribed, report.Undescribed)
+	}
+	if !report.Drifted() {
+		t.Errorf("expected a drift")
+	}
+
+	var out bytes.Buffer
+	WriteText(&out, report)
+	if !strings.Contains(out.String(), "RoleBinding/mldev/debug -> ClusterRole/edit (cluster)\n  not in the files, grants oidc:mldev-namespace-viewer") {
+		t.Errorf("unexpected text\n%s", out.String())
+	}
+}
+
+func TestCompareRestrictedRules(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+
+	var restricted_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: metrics-reader
+rules:
+- apiGroups: [""]
+  resources: ["services"]
+  resourceNames: ["prometheus"]
+  verbs: ["get"]
+- nonResourceURLs: ["/metrics"]
+  verbs: ["get"]
+`
+	files := rbac_objects.New()
+	if err := files.Decode("rbac.yaml", strings.NewReader(restricted_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	// the same rules as the files but for the resourceNames and the url
+	clientset := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "metrics-reader"}, Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"services"}, ResourceNames: []string{"grafana"}, Verbs: []string{"get"}},
+			{NonResourceURLs: []string{"/metrics", "/healthz"}, Verbs: []string{"get"}},
+		}},
+	)
+	live, err := rbac_objects.LoadClientset(context.Background(), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Compare(utils.DiscardLogger(), files, live, "oidc:")
+
+	if len(report.Roles) != 1 || !report.Roles[0].Diff.Empty() {
+		t.Fatalf("expected the restricted rules only, got %+v", report.Roles)
+	}
+	expect := []string{
+		`+ apigroup "" resource "services" name "grafana" verbs: [get]`,
+		`+ nonResourceURL "/healthz" verbs: [get]`,
+		`- apigroup "" resource "services" name "prometheus" verbs: [get]`,
+	}
+	if !reflect.DeepEqual(report.Roles[0].Restricted, expect) {
+		t.Errorf("expected %v, got %v", expect, report.Roles[0].Restricted)
+	}
+
+	var out bytes.Buffer
+	WriteText(&out, report)
+	if !strings.Contains(out.String(), "  + nonResourceURL \"/healthz\" verbs: [get], granted in the cluster\n") {
+		t.Errorf("unexpected text\n%s", out.String())
+	}
+}
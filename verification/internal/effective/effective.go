@@ -0,0 +1,328 @@
+package effective
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Compute the effective permissions of a user and its groups, the union of every binding naming them, expanded against
+the resource catalog parsed by ParseAllApiresources like a role file:
+
+	*Cluster: granted by the ClusterRoleBindings, in every namespace and on cluster-scoped resources
+	*Namespaces: for each namespace with a matching RoleBinding, Cluster plus the namespaced resources granted there
+
+A binding subject matches a User of the same name, a Group in the groups, or a ServiceAccount when the user is
+"system:serviceaccount:<namespace>:<name>". Like the apiserver, every user is also in "system:authenticated".
+
+Each scope is an ExpandedRole, its Rules in the shape of RbacRulesMap, so it can be verified, diffed or reported like a
+role file. Origin points to the rules of the bound roles, roleRefs to missing roles are skipped. The rules restricted to
+resourceNames would grant every object of their resources once expanded, they are listed in Restricted instead.
+*/
+type Identity struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups"`
+}
+
+type Permissions struct {
+	Identity   Identity                           `json:"identity"`
+	Bindings   []string                           `json:"bindings"`
+	Cluster    proc_rules.ExpandedRole            `json:"-"`
+	Namespaces map[string]proc_rules.ExpandedRole `json:"-"`
+	Restricted []RestrictedRule                   `json:"-"`
+}
+
+// RestrictedRule is a bound rule restricted to resourceNames, granted in Namespace, "" for cluster-wide
+type RestrictedRule struct {
+	Namespace string             `json:"namespace,omitempty"`
+	Rule      rbacv1.PolicyRule  `json:"rule"`
+	Origin    proc_rules.RuleRef `json:"origin"`
+}
+
+const AuthenticatedGroup = "system:authenticated"
+
+func Compute(logger *slog.Logger, objects *rbac_objects.Objects, identity Identity) (Permissions, error) {
+	p := Permissions{Identity: identity, Namespaces: make(map[string]proc_rules.ExpandedRole)}
+
+	var cluster_rules []boundRule
+	namespace_rules := make(map[string][]boundRule)
+	for _, b := range objects.ClusterRoleBindings {
+		if !identity.Matches(b.Subjects) {
+			continue
+		}
+		p.Bindings = append(p.Bindings, rbac_objects.KeyOf("ClusterRoleBinding", "", b.Name))
+		cluster_rules = append(cluster_rules, rulesOf(logger, objects, b.RoleRef, "")...)
+	}
+	for _, b := range objects.RoleBindings {
+		if !identity.Matches(b.Subjects) {
+			continue
+		}
+		p.Bindings = append(p.Bindings, rbac_objects.KeyOf("RoleBinding", b.Namespace, b.Name))
+		namespace_rules[b.Namespace] = append(namespace_rules[b.Namespace], rulesOf(logger, objects, b.RoleRef, b.Namespace)...)
+	}
+	sort.Strings(p.Bindings)
+
+	var err error
+	if p.Cluster, err = expand(logger, identity.User+"-cluster", p.restrict("", cluster_rules)); err != nil {
+		return p, err
+	}
+	for _, ns := range sortedNamespaces(namespace_rules) {
+		granted, err := expand(logger, identity.User+"-"+ns, p.restrict(ns, namespace_rules[ns]))
+		if err != nil {
+			return p, err
+		}
+		role := copyRole(p.Cluster, granted.Name)
+		for k, v := range granted.Rules {
+			for kk, vv := range v.Resource {
+				// a RoleBinding grants no cluster-scoped resource
+				if vv.Namespaced {
+					mergeResource(role, k, kk, vv, granted.Origin)
+				}
+			}
+		}
+		p.Namespaces[ns] = role
+	}
+	return p, nil
+}
+
+// Scopes lists "" for the cluster-wide permissions, then the namespaces, sorted
+func (p Permissions) Scopes() []string {
+	scopes := []string{""}
+	for ns := range p.Namespaces {
+		scopes = append(scopes, ns)
+	}
+	sort.Strings(scopes[1:])
+	return scopes
+}
+
+// Role returns the permissions of a scope, "" for cluster-wide
+func (p Permissions) Role(scope string) proc_rules.ExpandedRole {
+	if scope == "" {
+		return p.Cluster
+	}
+	return p.Namespaces[scope]
+}
+
+func (p Permissions) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Effective permissions of user %q, groups %v, through %d binding(s)\n", p.Identity.User, p.Identity.Groups, len(p.Bindings))
+	for _, b := range p.Bindings {
+		fmt.Fprintf(w, "  %s\n", b)
+	}
+	for _, scope := range p.Scopes() {
+		role := p.Role(scope)
+		if scope == "" {
+			fmt.Fprintf(w, "cluster-wide:\n")
+		} else {
+			fmt.Fprintf(w, "namespace %q:\n", scope)
+		}
+		for _, k := range sortedKeys(role.Rules) {
+			resources := role.Rules[k].Resource
+			names := make([]string, 0, len(resources))
+			for kk := range resources {
+				names = append(names, kk)
+			}
+			sort.Strings(names)
+			for _, kk := range names {
+				if verbs := resources[kk].Verbs; len(verbs) > 0 {
+					fmt.Fprintf(w, "  apigroup: %q, resource: %q, verbs: %v\n", k, kk, verbs)
+				}
+			}
+		}
+		for _, r := range p.Restricted {
+			if r.Namespace == scope {
+				fmt.Fprintf(w, "  apigroups: %q, resources: %q, resourceNames: %q, verbs: %v, restricted to the names\n",
+					r.Rule.APIGroups, r.Rule.Resources, r.Rule.ResourceNames, r.Rule.Verbs)
+			}
+		}
+	}
+}
+
+// WriteJson writes the permissions of each scope in the shape of RbacRulesMap
+func (p Permissions) WriteJson(w io.Writer) error {
+	namespaces := make(map[string]map[string]proc_rules.ApiGroupValueType)
+	for ns, role := range p.Namespaces {
+		namespaces[ns] = role.Rules
+	}
+	bindings := p.Bindings
+	if bindings == nil {
+		bindings = []string{}
+	}
+	restricted := p.Restricted
+	if restricted == nil {
+		restricted = []RestrictedRule{}
+	}
+	j, err := json.MarshalIndent(struct {
+		Identity   Identity                                           `json:"identity"`
+		Bindings   []string                                           `json:"bindings"`
+		Cluster    map[string]proc_rules.ApiGroupValueType            `json:"cluster"`
+		Namespaces map[string]map[string]proc_rules.ApiGroupValueType `json:"namespaces"`
+		Restricted []RestrictedRule                                   `json:"restricted"`
+	}{p.Identity, bindings, p.Cluster.Rules, namespaces, restricted}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// Matches reports whether any of the binding subjects names the identity
+func (id Identity) Matches(subjects []rbacv1.Subject) bool {
+	for _, s := range subjects {
+		switch s.Kind {
+		case rbacv1.UserKind:
+			if s.Name == id.User {
+				return true
+			}
+		case rbacv1.GroupKind:
+			if s.Name == AuthenticatedGroup || slices.Contains(id.Groups, s.Name) {
+				return true
+			}
+		case rbacv1.ServiceAccountKind:
+			if id.User == fmt.Sprintf("system:serviceaccount:%s:%s", s.Namespace, s.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper functions
+
+// boundRule is a rule of a bound role, with where it was loaded from
+type boundRule struct {
+	rule rbacv1.PolicyRule
+	ref  proc_rules.RuleRef
+}
+
+func rulesOf(logger *slog.Logger, objects *rbac_objects.Objects, ref rbacv1.RoleRef, namespace string) []boundRule {
+	role_rules, ok := objects.RulesOf(ref, namespace)
+	if !ok {
+		logger.Warn("Found binding to a missing role, skipping", "kind", ref.Kind, "name", ref.Name, "namespace", namespace)
+		return nil
+	}
+	var rules []boundRule
+	for _, rr := range role_rules {
+		role_namespace := ""
+		if rr.Kind == "Role" {
+			role_namespace = namespace
+		}
+		origin := objects.Source[rbac_objects.KeyOf(rr.Kind, role_namespace, rr.Name)]
+		file := origin.File
+		if file == "" {
+			file = origin.String()
+		}
+		for i, rule := range rr.Rules {
+			rules = append(rules, boundRule{rule, proc_rules.RuleRef{File: file, Document: origin.Document, RuleIndex: i}})
+		}
+	}
+	return rules
+}
+
+// restrict moves the rules restricted to resourceNames to p.Restricted, and returns the others
+func (p *Permissions) restrict(namespace string, rules []boundRule) []boundRule {
+	var ret []boundRule
+	for _, r := range rules {
+		if len(r.rule.ResourceNames) > 0 {
+			p.Restricted = append(p.Restricted, RestrictedRule{namespace, r.rule, r.ref})
+		} else {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+/*
+expand writes the rules as one ClusterRole and expands it with ExpandRbacYamlReader, so the wildcards and verbs are
+handled as for a role file, then points the origins back to the bound roles. It overwrites RbacRulesMap.
+*/
+func expand(logger *slog.Logger, name string, rules []boundRule) (proc_rules.ExpandedRole, error) {
+	role := rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      []rbacv1.PolicyRule{},
+	}
+	for _, r := range rules {
+		role.Rules = append(role.Rules, r.rule)
+	}
+	data, err := yaml.Marshal(role)
+	if err != nil {
+		return proc_rules.ExpandedRole{}, err
+	}
+
+	expanded, _ := proc_rules.ExpandRbacYamlReader(logger, name, bytes.NewReader(data))
+	for key, refs := range expanded.Origin {
+		for i, ref := range refs {
+			wildcard := ref.Wildcard
+			refs[i] = rules[ref.RuleIndex].ref
+			refs[i].Wildcard = wildcard
+		}
+		expanded.Origin[key] = refs
+	}
+	expanded.File = ""
+	return expanded, nil
+}
+
+func copyRole(role proc_rules.ExpandedRole, name string) proc_rules.ExpandedRole {
+	ret := proc_rules.ExpandedRole{
+		Name:   name,
+		Kind:   role.Kind,
+		Rules:  make(map[string]proc_rules.ApiGroupValueType),
+		Origin: make(map[proc_rules.PermissionKey][]proc_rules.RuleRef),
+	}
+	for k, v := range role.Rules {
+		for kk, vv := range v.Resource {
+			mergeResource(ret, k, kk, vv, role.Origin)
+		}
+	}
+	return ret
+}
+
+func mergeResource(role proc_rules.ExpandedRole, group string, resource string, value proc_rules.ResourceValueType, origin map[proc_rules.PermissionKey][]proc_rules.RuleRef) {
+	entry, ok := role.Rules[group]
+	if !ok {
+		entry = proc_rules.ApiGroupValueType{Resource: make(map[string]proc_rules.ResourceValueType)}
+	}
+	merged, ok := entry.Resource[resource]
+	if !ok {
+		merged = value
+		merged.Verbs = nil
+	}
+	for _, verb := range value.Verbs {
+		if !slices.Contains(merged.Verbs, verb) {
+			merged.Verbs = append(merged.Verbs, verb)
+		}
+		key := proc_rules.PermissionKey{Group: group, Resource: resource, Verb: verb}
+		role.Origin[key] = append(role.Origin[key], origin[key]...)
+	}
+	entry.Resource[resource] = merged
+	role.Rules[group] = entry
+}
+
+func sortedNamespaces(rules map[string][]boundRule) []string {
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(rules map[string]proc_rules.ApiGroupValueType) []string {
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
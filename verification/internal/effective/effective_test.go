@@ -0,0 +1,219 @@
+package effective
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+nodes                             no                    v1                                     false        Node                             [create delete deletecollection get list patch update watch]
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/exec                                               v1                                     true         PodExecOptions                   [create get]
+statefulsets                      sts                   apps/v1                                true         StatefulSet                      [create delete deletecollection get list patch update watch]`
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: node-viewer
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: [""]
+  resources: ["pods", "pods/exec"]
+  verbs: ["*"]
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["delete"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: node-viewer
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: system:authenticated
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-namespace-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+- kind: ServiceAccount
+  name: deployer
+  namespace: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-dangling
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: decommissioned
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+`
+
+func setup(t *testing.T) *rbac_objects.Objects {
+	path := filepath.Join(t.TempDir(), "api_resources.txt")
+	os.WriteFile(path, []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(path)
+
+	objects := rbac_objects.New()
+	if err := objects.Decode("objects.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestCompute(t *testing.T) {
+	objects := setup(t)
+	p, err := Compute(utils.DiscardLogger(), objects, Identity{User: "alice", Groups: []string{"oidc:mldev-namespace-admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect_bindings := []string{"ClusterRoleBinding/node-viewer", "RoleBinding/mldev/mldev-namespace-admin", "RoleBinding/smoke-test/smoke-test-dangling"}
+	if !reflect.DeepEqual(p.Bindings, expect_bindings) {
+		t.Errorf("expected bindings %v, got %v", expect_bindings, p.Bindings)
+	}
+	if !reflect.DeepEqual(p.Scopes(), []string{"", "mldev", "smoke-test"}) {
+		t.Errorf("unexpected scopes %v", p.Scopes())
+	}
+	if verbs := p.Cluster.Rules[""].Resource["nodes"].Verbs; !reflect.DeepEqual(verbs, []string{"get", "list"}) {
+		t.Errorf("expected cluster-wide nodes get and list, got %v", verbs)
+	}
+	if _, ok := p.Cluster.Rules[""].Resource["pods"]; ok {
+		t.Errorf("expected no cluster-wide pods")
+	}
+
+	mldev := p.Role("mldev")
+	if verbs := mldev.Rules[""].Resource["pods/exec"].Verbs; !reflect.DeepEqual(verbs, []string{"create", "get"}) {
+		t.Errorf("expected pods/exec create and get in mldev, got %v", verbs)
+	}
+	// a RoleBinding grants no cluster-scoped resource, only the cluster-wide grants apply
+	if verbs := mldev.Rules[""].Resource["nodes"].Verbs; !reflect.DeepEqual(verbs, []string{"get", "list"}) {
+		t.Errorf("expected nodes get and list in mldev, got %v", verbs)
+	}
+	expect_ref := []proc_rules.RuleRef{{File: "objects.yaml", Document: 1, RuleIndex: 0, Wildcard: true}}
+	if refs := mldev.Origin[proc_rules.PermissionKey{Group: "", Resource: "pods", Verb: "delete"}]; !reflect.DeepEqual(refs, expect_ref) {
+		t.Errorf("expected origin %+v, got %+v", expect_ref, refs)
+	}
+	if rules := p.Role("smoke-test").Rules; len(rules[""].Resource) != 1 {
+		t.Errorf("expected only the cluster-wide nodes in smoke-test, got %+v", rules)
+	}
+
+	var b bytes.Buffer
+	if err := p.WriteJson(&b); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Namespaces map[string]map[string]proc_rules.ApiGroupValueType `json:"namespaces"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Namespaces["mldev"][""].Resource["pods/exec"].Verbs, []string{"create", "get"}) {
+		t.Errorf("unexpected json %s", b.String())
+	}
+}
+
+func TestComputeServiceAccount(t *testing.T) {
+	objects := setup(t)
+	p, err := Compute(utils.DiscardLogger(), objects, Identity{User: "system:serviceaccount:mldev:deployer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"ClusterRoleBinding/node-viewer", "RoleBinding/mldev/mldev-namespace-admin"}
+	if !reflect.DeepEqual(p.Bindings, expect) {
+		t.Errorf("expected bindings %v, got %v", expect, p.Bindings)
+	}
+}
+
+func TestComputeResourceNames(t *testing.T) {
+	objects := setup(t)
+	var restricted_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: database-restarter
+  namespace: mldev
+rules:
+- apiGroups: ["apps"]
+  resources: ["statefulsets"]
+  resourceNames: ["postgres"]
+  verbs: ["get", "patch"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: database-restarter
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: database-restarter
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+`
+	if err := objects.Decode("restricted.yaml", strings.NewReader(restricted_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compute(utils.DiscardLogger(), objects, Identity{User: "alice", Groups: []string{"oidc:mldev-namespace-admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// patching the postgres statefulset does not grant patching every statefulset
+	if _, ok := p.Role("mldev").Rules["apps"].Resource["statefulsets"]; ok {
+		t.Errorf("expected no statefulsets expanded in mldev, got %+v", p.Role("mldev").Rules["apps"])
+	}
+	if len(p.Restricted) != 1 || p.Restricted[0].Namespace != "mldev" || p.Restricted[0].Origin.File != "restricted.yaml" ||
+		!reflect.DeepEqual(p.Restricted[0].Rule.ResourceNames, []string{"postgres"}) {
+		t.Errorf("expected the postgres rule restricted in mldev, got %+v", p.Restricted)
+	}
+	// the rule indexes of the origins still point to the bound roles
+	expect_ref := []proc_rules.RuleRef{{File: "objects.yaml", Document: 1, RuleIndex: 0, Wildcard: true}}
+	if refs := p.Role("mldev").Origin[proc_rules.PermissionKey{Group: "", Resource: "pods", Verb: "delete"}]; !reflect.DeepEqual(refs, expect_ref) {
+		t.Errorf("expected origin %+v, got %+v", expect_ref, refs)
+	}
+
+	var b bytes.Buffer
+	p.WriteText(&b)
+	if !strings.Contains(b.String(), `resourceNames: ["postgres"], verbs: [get patch], restricted to the names`) {
+		t.Errorf("unexpected text\n%s", b.String())
+	}
+}
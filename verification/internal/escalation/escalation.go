@@ -0,0 +1,400 @@
+package escalation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+/*
+Follow the transitive reach of a persona through the subject -> capability -> subject edges of the loaded roles and
+bindings, breadth first, so each reachable identity is reported with its shortest path:
+
+	*create-pods: creating pods, or workloads creating pods, in a namespace can mount any service account there
+	*create-token: creating serviceaccounts/token issues a token of any service account there
+	*impersonate: impersonating users, groups or serviceaccounts acts as them, resourceNames restrict the targets
+
+The service accounts of a namespace are the loaded ServiceAccounts plus the ServiceAccount subjects of the bindings.
+Impersonating any user or group, without resourceNames, reaches "*", i.e. system:masters, which is not followed.
+
+For each reachable identity, the permissions it holds beyond the persona are computed with Compute, against the
+resource catalog parsed by ParseAllApiresources.
+*/
+const (
+	CapabilityCreatePods  = "create-pods"
+	CapabilityCreateToken = "create-token"
+	CapabilityImpersonate = "impersonate"
+	AnyIdentity           = "*"
+)
+
+const (
+	serviceAccountPrefix   = "system:serviceaccount:"
+	serviceAccountsGroup   = "system:serviceaccounts"
+	impersonatedGroupLabel = "group:"
+)
+
+// pod creating resources, mounting any service account of the namespace
+var podCreators = map[string][]string{
+	"":      {"pods", "replicationcontrollers"},
+	"apps":  {"deployments", "replicasets", "statefulsets", "daemonsets"},
+	"batch": {"jobs", "cronjobs"},
+}
+
+type Edge struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Capability string `json:"capability"`
+	Namespace  string `json:"namespace,omitempty"` // "" for cluster-wide
+	Binding    string `json:"binding"`
+	RoleRef    string `json:"roleRef"`
+}
+
+type Gain struct {
+	Scope         string   `json:"scope"` // "" for cluster-wide
+	Group         string   `json:"group"`
+	Resource      string   `json:"resource"`
+	Verbs         []string `json:"verbs"`
+	ResourceNames []string `json:"resourceNames,omitempty"` // set when the gain is restricted to the names
+}
+
+type Reach struct {
+	Identity string `json:"identity"`
+	Path     []Edge `json:"path"`
+	Gained   []Gain `json:"gained"`
+}
+
+type Report struct {
+	Persona   string  `json:"persona"`
+	Reachable []Reach `json:"reachable"`
+}
+
+// Escalates reports whether the persona gains permissions through another identity, or reaches any identity
+func (r Report) Escalates() bool {
+	for _, reach := range r.Reachable {
+		if len(reach.Gained) > 0 || reach.Identity == AnyIdentity {
+			return true
+		}
+	}
+	return false
+}
+
+func Analyze(logger *slog.Logger, objects *rbac_objects.Objects, persona string, identity effective.Identity) (Report, error) {
+	report := Report{Persona: persona}
+	own, err := effective.Compute(logger, objects, identity)
+	if err != nil {
+		return report, err
+	}
+	accounts := serviceAccountsOf(objects)
+
+	start := labelOf(identity)
+	paths := map[string][]Edge{start: nil}
+	identities := map[string]effective.Identity{start: identity}
+	queue := []string{start}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+		if from == AnyIdentity {
+			continue
+		}
+		for _, e := range edgesOf(objects, identities[from], accounts) {
+			e.From = from
+			if _, visited := paths[e.To]; visited {
+				continue
+			}
+			paths[e.To] = append(append([]Edge{}, paths[from]...), e)
+			identities[e.To] = identityOf(e.To)
+			queue = append(queue, e.To)
+		}
+	}
+
+	for label, path := range paths {
+		if label == start {
+			continue
+		}
+		reach := Reach{Identity: label, Path: path, Gained: []Gain{}}
+		if label != AnyIdentity {
+			p, err := effective.Compute(logger, objects, identities[label])
+			if err != nil {
+				return report, err
+			}
+			reach.Gained = gainsOf(p, own)
+		}
+		report.Reachable = append(report.Reachable, reach)
+	}
+	sort.Slice(report.Reachable, func(i, j int) bool {
+		a, b := report.Reachable[i], report.Reachable[j]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) < len(b.Path)
+		}
+		return a.Identity < b.Identity
+	})
+	return report, nil
+}
+
+func WriteText(w io.Writer, reports []Report) {
+	for _, r := range reports {
+		fmt.Fprintf(w, "Persona %s: %d reachable identit(ies)\n", r.Persona, len(r.Reachable))
+		for _, reach := range r.Reachable {
+			fmt.Fprintf(w, "  %s\n", reach.Identity)
+			for _, e := range reach.Path {
+				scope := "cluster-wide"
+				if e.Namespace != "" {
+					scope = fmt.Sprintf("in namespace %q", e.Namespace)
+				}
+				fmt.Fprintf(w, "    %s -[%s %s, %s -> %s]-> %s\n", e.From, e.Capability, scope, e.Binding, e.RoleRef, e.To)
+			}
+			for _, g := range reach.Gained {
+				scope := "cluster-wide"
+				if g.Scope != "" {
+					scope = fmt.Sprintf("namespace %q", g.Scope)
+				}
+				if len(g.ResourceNames) > 0 {
+					fmt.Fprintf(w, "    gains %s: apigroup: %q, resource: %q, resourceNames: %q, verbs: %v\n", scope, g.Group, g.Resource, g.ResourceNames, g.Verbs)
+				} else {
+					fmt.Fprintf(w, "    gains %s: apigroup: %q, resource: %q, verbs: %v\n", scope, g.Group, g.Resource, g.Verbs)
+				}
+			}
+		}
+	}
+}
+
+func WriteJson(w io.Writer, reports []Report) error {
+	if reports == nil {
+		reports = []Report{}
+	}
+	j, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func labelOf(identity effective.Identity) string {
+	if identity.User == "" && len(identity.Groups) == 1 {
+		return impersonatedGroupLabel + identity.Groups[0]
+	}
+	return identity.User
+}
+
+// identityOf is the identity of an edge target: a service account, an impersonated user or an impersonated group
+func identityOf(label string) effective.Identity {
+	if strings.HasPrefix(label, impersonatedGroupLabel) {
+		return effective.Identity{Groups: []string{strings.TrimPrefix(label, impersonatedGroupLabel)}}
+	}
+	if strings.HasPrefix(label, serviceAccountPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(label, serviceAccountPrefix), ":", 2)
+		return effective.Identity{User: label, Groups: []string{serviceAccountsGroup, serviceAccountsGroup + ":" + parts[0]}}
+	}
+	return effective.Identity{User: label, Groups: []string{}}
+}
+
+func serviceAccountLabel(namespace string, name string) string {
+	return serviceAccountPrefix + namespace + ":" + name
+}
+
+// serviceAccountsOf lists the service accounts of each namespace, loaded or named by bindings
+func serviceAccountsOf(objects *rbac_objects.Objects) map[string][]string {
+	accounts := make(map[string][]string)
+	add := func(namespace string, name string) {
+		if !slices.Contains(accounts[namespace], name) {
+			accounts[namespace] = append(accounts[namespace], name)
+		}
+	}
+	for _, sa := range objects.ServiceAccounts {
+		add(sa.Namespace, sa.Name)
+	}
+	addSubjects := func(subjects []rbacv1.Subject) {
+		for _, s := range subjects {
+			if s.Kind == rbacv1.ServiceAccountKind {
+				add(s.Namespace, s.Name)
+			}
+		}
+	}
+	for _, b := range objects.ClusterRoleBindings {
+		addSubjects(b.Subjects)
+	}
+	for _, b := range objects.RoleBindings {
+		addSubjects(b.Subjects)
+	}
+	for _, names := range accounts {
+		sort.Strings(names)
+	}
+	return accounts
+}
+
+func edgesOf(objects *rbac_objects.Objects, identity effective.Identity, accounts map[string][]string) []Edge {
+	var namespaces []string
+	for ns := range accounts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var edges []Edge
+	collect := func(binding string, namespace string, ref rbacv1.RoleRef) {
+		role_rules, ok := objects.RulesOf(ref, namespace)
+		if !ok {
+			return
+		}
+		ref_namespace := ""
+		if ref.Kind == "Role" {
+			ref_namespace = namespace
+		}
+		add := func(capability string, to string) {
+			edges = append(edges, Edge{To: to, Capability: capability, Namespace: namespace, Binding: binding,
+				RoleRef: rbac_objects.KeyOf(ref.Kind, ref_namespace, ref.Name)})
+		}
+		// the namespaces the rule applies to, all of them for a ClusterRoleBinding
+		scope := []string{namespace}
+		if namespace == "" {
+			scope = namespaces
+		}
+
+		for _, rr := range role_rules {
+			for _, rule := range rr.Rules {
+				if createsPods(rule) {
+					for _, ns := range scope {
+						for _, sa := range accounts[ns] {
+							add(CapabilityCreatePods, serviceAccountLabel(ns, sa))
+						}
+					}
+				}
+				if all, names := allows(rule, "create", "", "serviceaccounts/token"); all || len(names) > 0 {
+					for _, ns := range scope {
+						for _, sa := range accounts[ns] {
+							if all || slices.Contains(names, sa) {
+								add(CapabilityCreateToken, serviceAccountLabel(ns, sa))
+							}
+						}
+					}
+				}
+				if all, names := allows(rule, "impersonate", "", "serviceaccounts"); all || len(names) > 0 {
+					for _, ns := range scope {
+						for _, sa := range accounts[ns] {
+							if all || slices.Contains(names, sa) {
+								add(CapabilityImpersonate, serviceAccountLabel(ns, sa))
+							}
+						}
+					}
+				}
+				for _, resource := range []string{"users", "groups"} {
+					all, names := allows(rule, "impersonate", "", resource)
+					if all {
+						add(CapabilityImpersonate, AnyIdentity)
+					}
+					for _, name := range names {
+						if resource == "groups" {
+							add(CapabilityImpersonate, impersonatedGroupLabel+name)
+						} else {
+							add(CapabilityImpersonate, name)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, b := range objects.ClusterRoleBindings {
+		if identity.Matches(b.Subjects) {
+			collect(rbac_objects.KeyOf("ClusterRoleBinding", "", b.Name), "", b.RoleRef)
+		}
+	}
+	for _, b := range objects.RoleBindings {
+		if identity.Matches(b.Subjects) {
+			collect(rbac_objects.KeyOf("RoleBinding", b.Namespace, b.Name), b.Namespace, b.RoleRef)
+		}
+	}
+	return edges
+}
+
+func createsPods(rule rbacv1.PolicyRule) bool {
+	for group, resources := range podCreators {
+		for _, resource := range resources {
+			if all, _ := allows(rule, "create", group, resource); all {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allows matches the rule ignoring its resourceNames, all is false and names lists them when it restricts the targets
+func allows(rule rbacv1.PolicyRule, verb string, group string, resource string) (bool, []string) {
+	names := rule.ResourceNames
+	rule.ResourceNames = nil
+	resource, subresource, _ := strings.Cut(resource, "/")
+	if !rbac_objects.RuleAllows(rule, verb, group, resource, subresource) {
+		return false, nil
+	}
+	return len(names) == 0, names
+}
+
+// gainsOf lists the permissions of p missing from own, per scope, a namespace missing from own holds its cluster-wide permissions
+func gainsOf(p effective.Permissions, own effective.Permissions) []Gain {
+	gains := []Gain{}
+	for _, scope := range p.Scopes() {
+		held := own.Cluster.Rules
+		if role, ok := own.Namespaces[scope]; ok {
+			held = role.Rules
+		}
+		missing := proc_rules.SubtractRules(p.Role(scope).Rules, held)
+		for k, v := range missing {
+			for kk, vv := range v.Resource {
+				if len(vv.Verbs) == 0 {
+					continue
+				}
+				verbs := append([]string{}, vv.Verbs...)
+				sort.Strings(verbs)
+				gains = append(gains, Gain{scope, k, kk, verbs, nil})
+			}
+		}
+	}
+	// the rules restricted to resourceNames, unless the persona holds the same rule or the verbs on every name
+	for _, r := range p.Restricted {
+		if slices.ContainsFunc(own.Restricted, func(o effective.RestrictedRule) bool {
+			return o.Namespace == r.Namespace && reflect.DeepEqual(o.Rule, r.Rule)
+		}) {
+			continue
+		}
+		held := own.Role(r.Namespace).Rules
+		if r.Namespace != "" && held == nil {
+			held = own.Cluster.Rules
+		}
+		for _, group := range r.Rule.APIGroups {
+			for _, resource := range r.Rule.Resources {
+				var verbs []string
+				for _, verb := range r.Rule.Verbs {
+					if !slices.Contains(held[group].Resource[resource].Verbs, verb) {
+						verbs = append(verbs, verb)
+					}
+				}
+				if len(verbs) > 0 {
+					sort.Strings(verbs)
+					gains = append(gains, Gain{r.Namespace, group, resource, verbs, r.Rule.ResourceNames})
+				}
+			}
+		}
+	}
+	sort.Slice(gains, func(i, j int) bool {
+		a, b := gains[i], gains[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Resource < b.Resource
+	})
+	return gains
+}
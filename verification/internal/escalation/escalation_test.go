@@ -0,0 +1,230 @@
+package escalation
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+nodes                             no                    v1                                     false        Node                             [create delete deletecollection get list patch update watch]
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+secrets                                                 v1                                     true         Secret                           [create delete deletecollection get list patch update watch]
+serviceaccounts                   sa                    v1                                     true         ServiceAccount                   [create delete deletecollection get list patch update watch]
+serviceaccounts/token                                   v1                                     true         TokenRequest                     [create]
+users                                                   v1                                     false        User                             [impersonate]
+groups                                                  v1                                     false        Group                            [impersonate]
+deployments                       deploy                apps/v1                                true         Deployment                       [create delete deletecollection get list patch update watch]`
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["create", "get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: deployer
+rules:
+- apiGroups: [""]
+  resources: ["secrets"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["serviceaccounts/token"]
+  resourceNames: ["builder"]
+  verbs: ["create"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: impersonator
+rules:
+- apiGroups: [""]
+  resources: ["users"]
+  verbs: ["impersonate"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: group-impersonator
+rules:
+- apiGroups: [""]
+  resources: ["groups"]
+  resourceNames: ["oidc:cluster-operator"]
+  verbs: ["impersonate"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: node-viewer
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-namespace-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: deployer
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: deployer
+subjects:
+- kind: ServiceAccount
+  name: deployer
+  namespace: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: builder
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: group-impersonator
+subjects:
+- kind: ServiceAccount
+  name: builder
+  namespace: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cluster-operator
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:cluster-operator
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: impersonator
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: impersonator
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: User
+  name: mallory
+`
+
+func setup(t *testing.T) *rbac_objects.Objects {
+	path := filepath.Join(t.TempDir(), "api_resources.txt")
+	os.WriteFile(path, []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(path)
+
+	objects := rbac_objects.New()
+	if err := objects.Decode("objects.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestAnalyze(t *testing.T) {
+	objects := setup(t)
+	report, err := Analyze(utils.DiscardLogger(), objects, "mldev-admin", effective.Identity{Groups: []string{"oidc:mldev-namespace-admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Escalates() {
+		t.Errorf("expected the persona to escalate")
+	}
+
+	var identities []string
+	for _, reach := range report.Reachable {
+		identities = append(identities, reach.Identity)
+	}
+	// deployments mount any account of mldev, the deployer token is restricted to builder
+	expect := []string{"system:serviceaccount:mldev:builder", "system:serviceaccount:mldev:deployer", "group:oidc:cluster-operator"}
+	if !reflect.DeepEqual(identities, expect) {
+		t.Fatalf("expected reachable %v, got %v", expect, identities)
+	}
+
+	deployer := report.Reachable[1]
+	expect_path := []Edge{{From: "group:oidc:mldev-namespace-admin", To: "system:serviceaccount:mldev:deployer", Capability: CapabilityCreatePods,
+		Namespace: "mldev", Binding: "RoleBinding/mldev/mldev-namespace-admin", RoleRef: "ClusterRole/namespace-admin"}}
+	if !reflect.DeepEqual(deployer.Path, expect_path) {
+		t.Errorf("expected path %+v, got %+v", expect_path, deployer.Path)
+	}
+	expect_gains := []Gain{
+		{Scope: "mldev", Group: "", Resource: "secrets", Verbs: []string{"get", "list"}},
+		{Scope: "mldev", Group: "", Resource: "serviceaccounts/token", Verbs: []string{"create"}, ResourceNames: []string{"builder"}},
+	}
+	if !reflect.DeepEqual(deployer.Gained, expect_gains) {
+		t.Errorf("expected gains %+v, got %+v", expect_gains, deployer.Gained)
+	}
+
+	operator := report.Reachable[2]
+	if len(operator.Path) != 2 || operator.Path[1].Capability != CapabilityImpersonate || operator.Path[1].From != "system:serviceaccount:mldev:builder" {
+		t.Errorf("expected impersonation by builder, got %+v", operator.Path)
+	}
+	if len(operator.Gained) != 1 || operator.Gained[0].Resource != "nodes" {
+		t.Errorf("expected nodes gained, got %+v", operator.Gained)
+	}
+
+	var b bytes.Buffer
+	WriteText(&b, []Report{report})
+	if !strings.Contains(b.String(), "-[create-pods in namespace \"mldev\", RoleBinding/mldev/mldev-namespace-admin -> ClusterRole/namespace-admin]->") {
+		t.Errorf("unexpected text %s", b.String())
+	}
+}
+
+func TestAnalyzeAnyIdentity(t *testing.T) {
+	objects := setup(t)
+	report, err := Analyze(utils.DiscardLogger(), objects, "mallory", effective.Identity{User: "mallory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Reachable) != 1 || report.Reachable[0].Identity != AnyIdentity {
+		t.Fatalf("expected only %q reachable, got %+v", AnyIdentity, report.Reachable)
+	}
+	if !report.Escalates() {
+		t.Errorf("expected impersonating any user to escalate")
+	}
+}
+
+func TestAnalyzeNoEscalation(t *testing.T) {
+	objects := setup(t)
+	report, err := Analyze(utils.DiscardLogger(), objects, "operator", effective.Identity{Groups: []string{"oidc:cluster-operator"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Escalates() || len(report.Reachable) != 0 {
+		t.Errorf("expected no reachable identity, got %+v", report.Reachable)
+	}
+}
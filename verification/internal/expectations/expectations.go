@@ -0,0 +1,219 @@
+package expectations
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Expectation specs turn the "can"/"cannot" statements of the RBAC proposal into access reviews:
+
+	personas:
+	  namespace-admin:
+	    groups:
+	    - oidc:smoke-test-namespace-admin
+	expectations:
+	- description: namespace-admin cannot edit resource quota
+	  persona: namespace-admin
+	  namespace: smoke-test
+	  resource: quota          # plural name, short name or kind, resolved with the resource catalog
+	  verbs: [update, patch]
+	  expect: deny             # allow or deny
+	- description: cluster-operator cannot modify clusterrolebindings
+	  subject:
+	    groups: [oidc:cluster-operator]
+	  group: rbac.authorization.k8s.io
+	  resource: ClusterRoleBinding
+	  verb: update
+	  expect: deny
+
+An expectation without persona or subject is reviewed for the current user, i.e. the kubeconfig identity, with a
+SelfSubjectAccessReview. Otherwise a SubjectAccessReview is created, which requires the current user to be allowed to
+create subjectaccessreviews. A namespaced expectation without namespace is reviewed in each verified namespace.
+*/
+type Subject struct {
+	User   string   `json:"user,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type Expectation struct {
+	Description string   `json:"description,omitempty"`
+	Persona     string   `json:"persona,omitempty"`
+	Subject     *Subject `json:"subject,omitempty"`
+	Namespace   string   `json:"namespace,omitempty"`
+	Group       *string  `json:"group,omitempty"` // nil to resolve the apigroup from the resource
+	Resource    string   `json:"resource"`
+	Subresource string   `json:"subresource,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Verb        string   `json:"verb,omitempty"`
+	Verbs       []string `json:"verbs,omitempty"`
+	Expect      string   `json:"expect"`
+}
+
+type Spec struct {
+	Personas     map[string]Subject `json:"personas,omitempty"`
+	Expectations []Expectation      `json:"expectations"`
+}
+
+const (
+	ExpectAllow = "allow"
+	ExpectDeny  = "deny"
+)
+
+// Assertion is a resolved expectation, one per verb and namespace
+type Assertion struct {
+	Description string
+	Persona     string
+	Subject     *Subject // nil for the current user
+	Namespace   string
+	Group       string
+	Resource    string // plural name, without subresource
+	Subresource string
+	Name        string
+	Verb        string
+	Expected    bool
+}
+
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse expectations file %s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+// Resolve expands the expectations against the resource catalog, i.e. AllResourcesMap
+func Resolve(spec *Spec, all map[string]proc_rules.ApiGroupValueType, namespaces []string) ([]Assertion, error) {
+	var assertions []Assertion
+	for i, e := range spec.Expectations {
+		where := fmt.Sprintf("expectation #%d", i)
+		if e.Description != "" {
+			where += fmt.Sprintf(" (%s)", e.Description)
+		}
+
+		var expected bool
+		switch strings.ToLower(e.Expect) {
+		case ExpectAllow:
+			expected = true
+		case ExpectDeny:
+			expected = false
+		default:
+			return nil, fmt.Errorf("%s: expect must be allow or deny, got %q", where, e.Expect)
+		}
+
+		subject := e.Subject
+		if e.Persona != "" {
+			if subject != nil {
+				return nil, fmt.Errorf("%s: set either persona or subject", where)
+			}
+			p, ok := spec.Personas[e.Persona]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown persona %q", where, e.Persona)
+			}
+			subject = &p
+		}
+		if subject != nil && subject.User == "" && len(subject.Groups) == 0 {
+			return nil, fmt.Errorf("%s: the subject requires a user or groups", where)
+		}
+
+		verbs := e.Verbs
+		if e.Verb != "" {
+			verbs = append([]string{e.Verb}, verbs...)
+		}
+		if len(verbs) == 0 {
+			return nil, fmt.Errorf("%s: verb or verbs is required", where)
+		}
+
+		group, resource, res_entry, err := ResolveResource(all, e.Group, e.Resource)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where, err)
+		}
+		if e.Subresource != "" {
+			var ok bool
+			if res_entry, ok = all[group].Resource[resource+"/"+e.Subresource]; !ok {
+				return nil, fmt.Errorf("%s: subresource %q of %q does not exist in apigroup %q", where, e.Subresource, resource, group)
+			}
+		}
+
+		assertion_namespaces := []string{""}
+		if res_entry.Namespaced {
+			assertion_namespaces = namespaces
+			if e.Namespace != "" {
+				assertion_namespaces = []string{e.Namespace}
+			}
+		} else if e.Namespace != "" {
+			return nil, fmt.Errorf("%s: resource %q is cluster-scoped, remove the namespace", where, resource)
+		}
+
+		for _, ns := range assertion_namespaces {
+			for _, verb := range verbs {
+				assertions = append(assertions, Assertion{
+					Description: e.Description,
+					Persona:     e.Persona,
+					Subject:     subject,
+					Namespace:   ns,
+					Group:       group,
+					Resource:    resource,
+					Subresource: e.Subresource,
+					Name:        e.Name,
+					Verb:        verb,
+					Expected:    expected,
+				})
+			}
+		}
+	}
+	return assertions, nil
+}
+
+/*
+ResolveResource finds a resource by its plural name, short name or kind, case insensitive for kinds, within the given
+apigroup, or within all apigroups when group is nil. The match must be unique, the apigroup is required otherwise.
+*/
+func ResolveResource(all map[string]proc_rules.ApiGroupValueType, group *string, name string) (string, string, proc_rules.ResourceValueType, error) {
+	type match struct {
+		group    string
+		resource string
+		entry    proc_rules.ResourceValueType
+	}
+	var matches []match
+	for k, v := range all {
+		if group != nil && *group != k {
+			continue
+		}
+		for kk, vv := range v.Resource {
+			if vv.SubResource != "" {
+				continue
+			}
+			if kk == name || strings.EqualFold(vv.Kind, name) || slices.Contains(vv.ShortNames, name) {
+				matches = append(matches, match{k, kk, vv})
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if group != nil {
+			return "", "", proc_rules.ResourceValueType{}, fmt.Errorf("resource %q does not exist in apigroup %q", name, *group)
+		}
+		return "", "", proc_rules.ResourceValueType{}, fmt.Errorf("resource %q does not exist", name)
+	case 1:
+		return matches[0].group, matches[0].resource, matches[0].entry, nil
+	default:
+		var found []string
+		for _, m := range matches {
+			found = append(found, fmt.Sprintf("%s.%s", m.resource, m.group))
+		}
+		sort.Strings(found)
+		return "", "", proc_rules.ResourceValueType{}, fmt.Errorf("resource %q is ambiguous, set the group, found: %v", name, found)
+	}
+}
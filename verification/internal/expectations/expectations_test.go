@@ -0,0 +1,142 @@
+package expectations
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/exec                                               v1                                     true         PodExecOptions                   [create get]
+resourcequotas                    quota                 v1                                     true         ResourceQuota                    [create delete deletecollection get list patch update watch]
+events                            ev                    v1                                     true         Event                            [create delete deletecollection get list patch update watch]
+events                            ev                    events.k8s.io/v1                       true         Event                            [create delete deletecollection get list patch update watch]
+clusterrolebindings                                     rbac.authorization.k8s.io/v1           false        ClusterRoleBinding               [create delete deletecollection get list patch update watch]
+customresourcedefinitions         crd,crds              apiextensions.k8s.io/v1                false        CustomResourceDefinition         [create delete deletecollection get list patch update watch]
+jobs                              vcjob,vj              batch.volcano.sh/v1alpha1              true         Job                              [delete deletecollection get list patch create update watch]`
+
+var spec_text = `personas:
+  namespace-admin:
+    groups:
+    - oidc:smoke-test-namespace-admin
+expectations:
+- description: namespace-admin cannot edit resource quota
+  persona: namespace-admin
+  namespace: smoke-test
+  resource: quota
+  verbs: [update, patch]
+  expect: deny
+- description: cluster-operator cannot modify clusterrolebindings
+  subject:
+    groups: [oidc:cluster-operator]
+  resource: ClusterRoleBinding
+  verb: update
+  expect: deny
+- resource: po
+  subresource: exec
+  verb: create
+  expect: allow
+`
+
+func loadCatalog(t *testing.T) map[string]proc_rules.ApiGroupValueType {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+	return proc_rules.AllResourcesMap
+}
+
+func TestLoadAndResolve(t *testing.T) {
+	all := loadCatalog(t)
+	path := filepath.Join(t.TempDir(), "expectations.yaml")
+	os.WriteFile(path, []byte(spec_text), 0644)
+
+	spec, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertions, err := Resolve(spec, all, []string{"ns1", "ns2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &Subject{Groups: []string{"oidc:smoke-test-namespace-admin"}}
+	operator := &Subject{Groups: []string{"oidc:cluster-operator"}}
+	expect := []Assertion{
+		{"namespace-admin cannot edit resource quota", "namespace-admin", admin, "smoke-test", "", "resourcequotas", "", "", "update", false},
+		{"namespace-admin cannot edit resource quota", "namespace-admin", admin, "smoke-test", "", "resourcequotas", "", "", "patch", false},
+		{"cluster-operator cannot modify clusterrolebindings", "", operator, "", "rbac.authorization.k8s.io", "clusterrolebindings", "", "", "update", false},
+		{"", "", nil, "ns1", "", "pods", "exec", "", "create", true},
+		{"", "", nil, "ns2", "", "pods", "exec", "", "create", true},
+	}
+	if !reflect.DeepEqual(assertions, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, assertions)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expectations.yaml")
+	os.WriteFile(path, []byte("expectations:\n- resource: pods\n  verb: get\n  expected: allow\n"), 0644)
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for the unknown field \"expected\"")
+	}
+}
+
+func TestResolveResource(t *testing.T) {
+	all := loadCatalog(t)
+	core, events := "", "events.k8s.io"
+
+	cases := []struct {
+		group    *string
+		name     string
+		expected string
+		err      string
+	}{
+		{nil, "pods", "pods", ""},
+		{nil, "po", "pods", ""},
+		{nil, "pod", "pods", ""},
+		{nil, "resourcequota", "resourcequotas", ""},
+		{&events, "ev", "events", ""},
+		{&core, "Event", "events", ""},
+		{nil, "ev", "", "ambiguous"},
+		{nil, "podexecoptions", "", "does not exist"},
+		{&events, "pods", "", "does not exist in apigroup"},
+		{nil, "crd", "customresourcedefinitions", ""},
+		{nil, "crds", "customresourcedefinitions", ""},
+		{nil, "vcjob", "jobs", ""},
+		{nil, "vj", "jobs", ""},
+	}
+	for _, c := range cases {
+		_, resource, _, err := ResolveResource(all, c.group, c.name)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: expected error containing %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || resource != c.expected {
+			t.Errorf("%q: expected %q, got %q, %v", c.name, c.expected, resource, err)
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	all := loadCatalog(t)
+	cases := map[string]Expectation{
+		"expect must be allow or deny":    {Resource: "pods", Verb: "get", Expect: "yes"},
+		"unknown persona":                 {Persona: "nobody", Resource: "pods", Verb: "get", Expect: "allow"},
+		"verb or verbs is required":       {Resource: "pods", Expect: "allow"},
+		"is cluster-scoped":               {Resource: "clusterrolebindings", Namespace: "ns1", Verb: "get", Expect: "deny"},
+		"subresource \"log\" of \"pods\"": {Resource: "pods", Subresource: "log", Verb: "get", Expect: "allow"},
+	}
+	for msg, e := range cases {
+		_, err := Resolve(&Spec{Expectations: []Expectation{e}}, all, []string{"ns1"})
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected error containing %q, got %v", msg, err)
+		}
+	}
+}
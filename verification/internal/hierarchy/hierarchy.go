@@ -0,0 +1,113 @@
+package hierarchy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+)
+
+/*
+Assert a strict role hierarchy, i.e. as defined by the RBAC proposal:
+
+	cluster-admin ⊇ cluster-operator ⊇ cluster-viewer
+	namespace-admin ⊇ namespace-viewer
+
+Roles are ordered from the highest, each one must be a subset of the one above it. The permissions of a lower role
+missing from the higher one are computed with SubtractRules, the set-subtraction logic of FilterRules.
+*/
+type Violation struct {
+	Higher   string               `json:"higher"`
+	Lower    string               `json:"lower"`
+	Group    string               `json:"group"`
+	Resource string               `json:"resource"`
+	Verbs    []string             `json:"verbs"`
+	Rules    []proc_rules.RuleRef `json:"rules"` // the rules of the lower role granting the verbs
+}
+
+func Check(roles []proc_rules.ExpandedRole) []Violation {
+	var violations []Violation
+	for i := 1; i < len(roles); i++ {
+		higher, lower := roles[i-1], roles[i]
+		missing := proc_rules.SubtractRules(lower.Rules, higher.Rules)
+
+		var found []Violation
+		for k, v := range missing {
+			for kk, vv := range v.Resource {
+				var refs []proc_rules.RuleRef
+				for _, verb := range vv.Verbs {
+					for _, ref := range lower.Origin[proc_rules.PermissionKey{Group: k, Resource: kk, Verb: verb}] {
+						if !containsRef(refs, ref) {
+							refs = append(refs, ref)
+						}
+					}
+				}
+				verbs := append([]string{}, vv.Verbs...)
+				sort.Strings(verbs)
+				found = append(found, Violation{nameOf(higher), nameOf(lower), k, kk, verbs, refs})
+			}
+		}
+		sort.Slice(found, func(i, j int) bool {
+			if found[i].Group != found[j].Group {
+				return found[i].Group < found[j].Group
+			}
+			return found[i].Resource < found[j].Resource
+		})
+		violations = append(violations, found...)
+	}
+	return violations
+}
+
+func WriteText(w io.Writer, roles []proc_rules.ExpandedRole, violations []Violation) {
+	for i := 1; i < len(roles); i++ {
+		higher, lower := nameOf(roles[i-1]), nameOf(roles[i])
+		var found []Violation
+		for _, v := range violations {
+			if v.Higher == higher && v.Lower == lower {
+				found = append(found, v)
+			}
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(w, "%s ⊇ %s: ok\n", higher, lower)
+			continue
+		}
+		fmt.Fprintf(w, "%s ⊇ %s: %d resource(s) with permissions missing from %s\n", higher, lower, len(found), higher)
+		for _, v := range found {
+			fmt.Fprintf(w, "  apigroup: %q, resource: %q, verbs: %v\n", v.Group, v.Resource, v.Verbs)
+			for _, ref := range v.Rules {
+				fmt.Fprintf(w, "    granted by %s: document %d, rule %d\n", ref.File, ref.Document, ref.RuleIndex)
+			}
+		}
+	}
+}
+
+func WriteJson(w io.Writer, violations []Violation) error {
+	if violations == nil {
+		violations = []Violation{}
+	}
+	j, err := json.MarshalIndent(violations, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func nameOf(role proc_rules.ExpandedRole) string {
+	if role.Name != "" {
+		return role.Name
+	}
+	return role.File
+}
+
+func containsRef(refs []proc_rules.RuleRef, ref proc_rules.RuleRef) bool {
+	for _, r := range refs {
+		if r == ref {
+			return true
+		}
+	}
+	return false
+}
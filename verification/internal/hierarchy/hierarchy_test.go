@@ -0,0 +1,98 @@
+package hierarchy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/exec                                               v1                                     true         PodExecOptions                   [create get]
+resourcequotas                    quota                 v1                                     true         ResourceQuota                    [create delete deletecollection get list patch update watch]
+statefulsets                      sts                   apps/v1                                true         StatefulSet                      [create delete deletecollection get list patch update watch]`
+
+var roles_text = map[string]string{
+	"cluster-admin.yaml": `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-admin
+rules:
+- apiGroups: ["*"]
+  resources: ["*"]
+  verbs: ["*"]
+`,
+	"cluster-operator.yaml": `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-operator
+rules:
+- apiGroups: [""]
+  resources: ["pods", "resourcequotas"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: ["apps"]
+  resources: ["statefulsets"]
+  verbs: ["get"]
+`,
+	"cluster-viewer.yaml": `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-viewer
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["pods/exec", "resourcequotas"]
+  verbs: ["get", "update"]
+`,
+}
+
+func expand(t *testing.T, names ...string) []proc_rules.ExpandedRole {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+
+	var roles []proc_rules.ExpandedRole
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(roles_text[name]), 0644)
+		role, _ := proc_rules.ExpandRbacYaml(utils.DiscardLogger(), path)
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func TestCheck(t *testing.T) {
+	roles := expand(t, "cluster-admin.yaml", "cluster-operator.yaml", "cluster-viewer.yaml")
+	violations := Check(roles)
+
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got %+v", violations)
+	}
+	expect := []Violation{
+		{"cluster-operator", "cluster-viewer", "", "pods/exec", []string{"get"}, []proc_rules.RuleRef{{File: roles[2].File, Document: 0, RuleIndex: 1}}},
+		{"cluster-operator", "cluster-viewer", "", "resourcequotas", []string{"update"}, []proc_rules.RuleRef{{File: roles[2].File, Document: 0, RuleIndex: 1}}},
+	}
+	if !reflect.DeepEqual(violations, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, violations)
+	}
+
+	var b bytes.Buffer
+	WriteText(&b, roles, violations)
+	for _, s := range []string{"cluster-admin ⊇ cluster-operator: ok", "cluster-operator ⊇ cluster-viewer: 2 resource(s)", "document 0, rule 1"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in:\n%s", s, b.String())
+		}
+	}
+
+	if violations := Check(expand(t, "cluster-admin.yaml", "cluster-viewer.yaml")); len(violations) != 0 {
+		t.Errorf("expected cluster-admin to hold every permission, got %+v", violations)
+	}
+}
@@ -0,0 +1,205 @@
+package idp_groups
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Validate the Group subjects of the bindings against the groups of the identity provider, as diagnostics:
+
+	*missing-idp-group (error): a binding names a group the identity provider does not have
+	*empty-idp-group (warning): a binding names a group without members
+	*unbound-idp-group (info): a group of the identity provider no binding names
+
+The groups are read from a Keycloak realm export, i.e. "kc.sh export --realm <realm>", or from a generic yaml mapping
+each group to its members:
+
+	mldev-namespace-admin: [alice, bob]
+	cluster-operator: []
+
+The apiserver prefixes the groups claim with --oidc-groups-prefix, i.e. "oidc:", so only the Group subjects with the
+prefix are looked up, without it. With no prefix, every group but the "system:" ones is. A Keycloak group is claimed by
+its name, or by its full path, i.e. "/projects/mldev-namespace-admin", with full_path like the group mapper option. A
+realm export without users, i.e. a partial export, has no members: empty groups are then not reported.
+*/
+const (
+	RuleMissingGroup = "missing-idp-group"
+	RuleEmptyGroup   = "empty-idp-group"
+	RuleUnboundGroup = "unbound-idp-group"
+)
+
+// Rules describes the rule IDs of Analyze, with their default severity
+var Rules = []diagnostics.Rule{
+	{ID: RuleMissingGroup, Severity: diagnostics.SeverityError, Description: "The group named by the binding does not exist in the identity provider, the binding grants nobody."},
+	{ID: RuleEmptyGroup, Severity: diagnostics.SeverityWarning, Description: "The group named by the binding has no members in the identity provider."},
+	{ID: RuleUnboundGroup, Severity: diagnostics.SeverityInfo, Description: "No binding names the group of the identity provider."},
+}
+
+// Directory holds the members of each group of the identity provider, as claimed, without prefix
+type Directory struct {
+	Source       string
+	Groups       map[string][]string
+	MembersKnown bool
+}
+
+type keycloakGroup struct {
+	Name      string          `json:"name"`
+	Path      string          `json:"path"`
+	SubGroups []keycloakGroup `json:"subGroups"`
+}
+
+type keycloakUser struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"` // the group paths
+}
+
+type keycloakRealm struct {
+	Realm  string          `json:"realm"`
+	Groups []keycloakGroup `json:"groups"`
+	Users  *[]keycloakUser `json:"users"`
+}
+
+// Load reads a Keycloak realm export json, recognized by its "realm" key, or a group to members yaml
+func Load(path string, full_path bool) (Directory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Directory{}, err
+	}
+	dir, err := Parse(data, full_path)
+	if err != nil {
+		return dir, fmt.Errorf("%s: %w", path, err)
+	}
+	dir.Source = path
+	return dir, nil
+}
+
+func Parse(data []byte, full_path bool) (Directory, error) {
+	var realm keycloakRealm
+	if err := yaml.Unmarshal(data, &realm); err == nil && realm.Realm != "" {
+		return parseKeycloak(realm, full_path), nil
+	}
+	var groups map[string][]string
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return Directory{}, fmt.Errorf("neither a Keycloak realm export nor a group to members yaml: %w", err)
+	}
+	if groups == nil {
+		groups = make(map[string][]string)
+	}
+	return Directory{Groups: groups, MembersKnown: true}, nil
+}
+
+func Analyze(objects *rbac_objects.Objects, dir Directory, prefix string) []diagnostics.Diagnostic {
+	var diags []diagnostics.Diagnostic
+	report := func(rule_id string, severity diagnostics.Severity, file string, document int, format string, a ...interface{}) {
+		diags = append(diags, diagnostics.Diagnostic{
+			RuleID:    rule_id,
+			Severity:  severity,
+			Message:   fmt.Sprintf(format, a...),
+			File:      file,
+			Document:  document,
+			RuleIndex: -1,
+		})
+	}
+
+	bound := make(map[string]bool)
+	check := func(key string, subjects []rbacv1.Subject) {
+		origin := objects.Source[key]
+		file := origin.File
+		if file == "" {
+			file = origin.String()
+		}
+		for _, s := range subjects {
+			group, ok := claimOf(s, prefix)
+			if !ok {
+				continue
+			}
+			bound[group] = true
+			members, found := dir.Groups[group]
+			if !found {
+				report(RuleMissingGroup, diagnostics.SeverityError, file, origin.Document, "%s: group %q does not exist in the identity provider", key, s.Name)
+			} else if dir.MembersKnown && len(members) == 0 {
+				report(RuleEmptyGroup, diagnostics.SeverityWarning, file, origin.Document, "%s: group %q has no members", key, s.Name)
+			}
+		}
+	}
+	for _, b := range objects.ClusterRoleBindings {
+		check(rbac_objects.KeyOf("ClusterRoleBinding", "", b.Name), b.Subjects)
+	}
+	for _, b := range objects.RoleBindings {
+		check(rbac_objects.KeyOf("RoleBinding", b.Namespace, b.Name), b.Subjects)
+	}
+
+	groups := make([]string, 0, len(dir.Groups))
+	for group := range dir.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		if !bound[group] {
+			report(RuleUnboundGroup, diagnostics.SeverityInfo, dir.Source, 0, "group %q, %q with the prefix, is named by no binding", group, prefix+group)
+		}
+	}
+
+	diagnostics.Sort(diags)
+	return diags
+}
+
+// helper functions
+
+// claimOf strips the prefix of a Group subject, false when it is not a group of the identity provider
+func claimOf(s rbacv1.Subject, prefix string) (string, bool) {
+	if s.Kind != rbacv1.GroupKind || !strings.HasPrefix(s.Name, prefix) {
+		return "", false
+	}
+	if prefix == "" && strings.HasPrefix(s.Name, "system:") {
+		return "", false
+	}
+	return strings.TrimPrefix(s.Name, prefix), true
+}
+
+func parseKeycloak(realm keycloakRealm, full_path bool) Directory {
+	dir := Directory{Groups: make(map[string][]string), MembersKnown: realm.Users != nil}
+	// the claim of each group path
+	claims := make(map[string]string)
+	var walk func(groups []keycloakGroup, parent string)
+	walk = func(groups []keycloakGroup, parent string) {
+		for _, g := range groups {
+			path := g.Path
+			if path == "" {
+				path = parent + "/" + g.Name
+			}
+			claim := g.Name
+			if full_path {
+				claim = path
+			}
+			claims[path] = claim
+			if _, ok := dir.Groups[claim]; !ok {
+				dir.Groups[claim] = []string{}
+			}
+			walk(g.SubGroups, path)
+		}
+	}
+	walk(realm.Groups, "")
+
+	if realm.Users != nil {
+		for _, u := range *realm.Users {
+			for _, path := range u.Groups {
+				if claim, ok := claims[path]; ok {
+					dir.Groups[claim] = append(dir.Groups[claim], u.Username)
+				}
+			}
+		}
+	}
+	for _, members := range dir.Groups {
+		sort.Strings(members)
+	}
+	return dir
+}
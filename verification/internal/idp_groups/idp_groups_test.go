@@ -0,0 +1,132 @@
+package idp_groups
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+)
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: node-viewer
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:cluster-operator
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: system:authenticated
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: project-lima-namespace-admin
+  namespace: project-lima
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:project-lima-namespace-admin
+`
+
+var realm_json_text = `{
+  "realm": "k8s",
+  "groups": [
+    {"name": "cluster-operator", "path": "/cluster-operator", "subGroups": []},
+    {"name": "projects", "path": "/projects", "subGroups": [
+      {"name": "mldev-namespace-admin", "path": "/projects/mldev-namespace-admin", "subGroups": []}
+    ]}
+  ],
+  "users": [
+    {"username": "bob", "groups": ["/projects/mldev-namespace-admin"]},
+    {"username": "alice", "groups": ["/projects/mldev-namespace-admin", "/unknown"]}
+  ]
+}`
+
+func setup(t *testing.T) *rbac_objects.Objects {
+	objects := rbac_objects.New()
+	if err := objects.Decode("rbac.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestParse(t *testing.T) {
+	dir, err := Parse([]byte(realm_json_text), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string][]string{"cluster-operator": {}, "projects": {}, "mldev-namespace-admin": {"alice", "bob"}}
+	if !reflect.DeepEqual(dir.Groups, expect) || !dir.MembersKnown {
+		t.Errorf("expected groups %v, got %v", expect, dir.Groups)
+	}
+
+	dir, _ = Parse([]byte(realm_json_text), true)
+	if _, ok := dir.Groups["/projects/mldev-namespace-admin"]; !ok {
+		t.Errorf("expected the groups by full path, got %v", dir.Groups)
+	}
+
+	// a partial export has no users
+	dir, _ = Parse([]byte(`{"realm": "k8s", "groups": [{"name": "cluster-operator"}]}`), false)
+	if dir.MembersKnown || len(dir.Groups) != 1 {
+		t.Errorf("expected no known members, got %+v", dir)
+	}
+
+	dir, err = Parse([]byte("mldev-namespace-admin: [alice]\ncluster-operator: []\n"), false)
+	if err != nil || len(dir.Groups) != 2 || !dir.MembersKnown {
+		t.Errorf("unexpected group yaml %+v, %v", dir, err)
+	}
+	if _, err := Parse([]byte("- not a map"), false); err == nil {
+		t.Errorf("expected an error on a list")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	dir, _ := Parse([]byte(realm_json_text), false)
+	dir.Source = "realm.json"
+	diags := Analyze(setup(t), dir, "oidc:")
+
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.RuleID+" "+d.File+" "+d.Message)
+	}
+	expect := []string{
+		`empty-idp-group rbac.yaml ClusterRoleBinding/node-viewer: group "oidc:cluster-operator" has no members`,
+		`missing-idp-group rbac.yaml RoleBinding/project-lima/project-lima-namespace-admin: group "oidc:project-lima-namespace-admin" does not exist in the identity provider`,
+		`unbound-idp-group realm.json group "projects", "oidc:projects" with the prefix, is named by no binding`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+	if diagnostics.Count(diags, diagnostics.SeverityError) != 1 || diags[1].Document != 1 {
+		t.Errorf("expected the missing group on document 1, got %+v", diags[1])
+	}
+}
+
+func TestAnalyzeWithoutPrefix(t *testing.T) {
+	dir, _ := Parse([]byte("oidc:cluster-operator: [alice]\n"), false)
+	diags := Analyze(setup(t), dir, "")
+	// system:authenticated is not a group of the identity provider
+	for _, d := range diags {
+		if strings.Contains(d.Message, "system:") {
+			t.Errorf("unexpected %s", d.Message)
+		}
+	}
+	if diagnostics.Count(diags, diagnostics.SeverityError) != 2 {
+		t.Errorf("expected 2 missing groups, got %+v", diags)
+	}
+}
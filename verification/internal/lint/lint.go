@@ -0,0 +1,83 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/policy"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"golang.org/x/exp/slog"
+)
+
+// Rules describes the rule IDs of Lint, with their default severity
+var Rules = []diagnostics.Rule{
+	{ID: proc_rules.RuleInvalidYaml, Severity: diagnostics.SeverityError, Description: "The document can not be decoded as a kubernetes object."},
+	{ID: proc_rules.RuleIncompleteRule, Severity: diagnostics.SeverityError, Description: "The rule misses apiGroups, resources or verbs, it grants nothing."},
+	{ID: proc_rules.RuleUnknownApiGroup, Severity: diagnostics.SeverityError, Description: "The apigroup does not exist in the resource catalog, i.e. \"app\" instead of \"apps\"."},
+	{ID: proc_rules.RuleUnknownResource, Severity: diagnostics.SeverityError, Description: "The resource does not exist in the apigroup, i.e. \"pod\" instead of \"pods\"."},
+	{ID: proc_rules.RuleUnavailableVerb, Severity: diagnostics.SeverityWarning, Description: "The verb is not served for the resource, i.e. \"list\" on \"pods/exec\", it grants nothing."},
+	{ID: proc_rules.RuleNonResourceURL, Severity: diagnostics.SeverityInfo, Description: "nonResourceURLs rules are not verified."},
+}
+
+/*
+Lint the rbac yaml files against the resource catalog parsed by ParseAllApiresources, reporting what ParseK8sRbacYaml
+finds as diagnostics:
+
+	*invalid-yaml (error): the document can not be decoded
+	*incomplete-rule (error): a rule misses apiGroups, resources or verbs
+	*unknown-apigroup (error): i.e. "app" instead of "apps"
+	*unknown-resource (error): i.e. "pod" instead of "pods"
+	*unavailable-verb (warning): i.e. "list" on "pods/exec", which only supports create and get
+	*non-resource-url (info): nonResourceURLs rules are not verified
+
+The violations of the custom policies, if any, are reported along, their rule ID being the policy id.
+*/
+func Lint(logger *slog.Logger, paths []string, policies *policy.PolicySet, overrides map[string]diagnostics.Severity) ([]diagnostics.Diagnostic, error) {
+	files, err := CollectYamlFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var diags []diagnostics.Diagnostic
+	for _, file := range files {
+		role, role_diags := proc_rules.ExpandRbacYaml(logger, file)
+		diags = append(diags, role_diags...)
+		diags = append(diags, policies.CheckRole(role)...)
+		diags = append(diags, policies.CheckBindings(file)...)
+	}
+	diags = diagnostics.ApplySeverities(diags, overrides)
+	diagnostics.Sort(diags)
+	return diags, nil
+}
+
+// CollectYamlFiles expands the directories in paths to the .yaml and .yml files they contain, sorted
+func CollectYamlFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := strings.ToLower(filepath.Ext(p)); !d.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
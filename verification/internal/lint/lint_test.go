@@ -0,0 +1,134 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/exec                                               v1                                     true         PodExecOptions                   [create get]
+statefulsets                      sts                   apps/v1                                true         StatefulSet                      [create delete deletecollection get list patch update watch]`
+
+var role_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - pod
+  - pods/exec
+  verbs:
+  - get
+  - list
+- apiGroups:
+  - app
+  resources:
+  - statefulsets
+  verbs:
+  - get
+- nonResourceURLs:
+  - /healthz
+  verbs:
+  - get
+- apiGroups:
+  - "*"
+  resources:
+  - pods
+  verbs:
+  - "*"
+- apiGroups:
+  - apps
+  verbs:
+  - get
+`
+
+var binding_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-namespace-admin
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:smoke-test-namespace-admin
+`
+
+func setup(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"api_resources.txt":                  api_resource_txt,
+		"rbac/namespace-admin.yaml":          role_yaml_text,
+		"rbac/bindings/smoke-test-admin.yml": binding_yaml_text,
+		"rbac/README.md":                     "not a yaml file",
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+	return dir
+}
+
+func TestCollectYamlFiles(t *testing.T) {
+	dir := setup(t)
+	files, err := CollectYamlFiles([]string{filepath.Join(dir, "rbac")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[0]) != "smoke-test-admin.yml" || filepath.Base(files[1]) != "namespace-admin.yaml" {
+		t.Errorf("unexpected files %v", files)
+	}
+	if _, err := CollectYamlFiles([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir := setup(t)
+	diags, err := Lint(utils.DiscardLogger(), []string{filepath.Join(dir, "rbac")}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []struct {
+		rule_id    string
+		severity   diagnostics.Severity
+		rule_index int
+	}{
+		{proc_rules.RuleUnknownResource, diagnostics.SeverityError, 0},
+		{proc_rules.RuleUnavailableVerb, diagnostics.SeverityWarning, 0},
+		{proc_rules.RuleUnknownApiGroup, diagnostics.SeverityError, 1},
+		{proc_rules.RuleNonResourceURL, diagnostics.SeverityInfo, 2},
+		{proc_rules.RuleIncompleteRule, diagnostics.SeverityError, 4},
+	}
+	if len(diags) != len(expect) {
+		t.Fatalf("expected %d diagnostics, got %d:\n%v", len(expect), len(diags), diags)
+	}
+	for i, e := range expect {
+		d := diags[i]
+		if d.RuleID != e.rule_id || d.Severity != e.severity || d.RuleIndex != e.rule_index || d.Document != 0 {
+			t.Errorf("expected %s %s at rule %d, got %s", e.severity, e.rule_id, e.rule_index, d)
+		}
+	}
+
+	overrides := map[string]diagnostics.Severity{proc_rules.RuleUnavailableVerb: diagnostics.SeverityError, proc_rules.RuleNonResourceURL: diagnostics.SeverityOff}
+	diags, _ = Lint(utils.DiscardLogger(), []string{filepath.Join(dir, "rbac", "namespace-admin.yaml")}, nil, overrides)
+	if len(diags) != 4 || diagnostics.Count(diags, diagnostics.SeverityError) != 4 {
+		t.Errorf("expected 4 errors with the overrides, got:\n%v", diags)
+	}
+}
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+)
+
+/*
+Prometheus text format metrics of a verification run, either written to a file for node-exporter's textfile collector,
+or served on a local /metrics endpoint:
+
+	rbac_verification_reviews{outcome="failed",namespace="smoke-test",role="namespace-admin"} 3
+	rbac_verification_mismatches{apigroup="apps"} 3
+	rbac_verification_run_duration_seconds 12.5
+	rbac_verification_api_errors_total 0
+	rbac_verification_last_success_timestamp_seconds 1.6716e+09
+
+A run is successful when no review failed and no api error occurred. The api errors are counted per failed review,
+accumulated across runs from the previous metrics file, or by the serving process, after Successful is checked.
+*/
+type RunMetrics struct {
+	Run         verify.RunResult
+	Duration    time.Duration
+	ApiErrors   int // of the run, the total across runs once accumulated
+	LastSuccess time.Time
+}
+
+const (
+	lastSuccessMetric = "rbac_verification_last_success_timestamp_seconds"
+	apiErrorsMetric   = "rbac_verification_api_errors_total"
+)
+
+func (m RunMetrics) Successful() bool {
+	return m.ApiErrors == 0 && verify.Summarize(m.Run.Results)[verify.OutcomeFailed] == 0
+}
+
+func Write(w io.Writer, m RunMetrics) error {
+	type reviewLabels struct{ outcome, namespace string }
+	reviews := make(map[reviewLabels]int)
+	mismatches := make(map[string]int)
+	for _, r := range m.Run.Results {
+		reviews[reviewLabels{r.Outcome, r.Namespace}]++
+		if r.Outcome == verify.OutcomeFailed {
+			mismatches[r.Group]++
+		}
+	}
+	// every outcome is reported for every namespace, so that a fixed failure drops back to 0
+	for _, ns := range m.Run.Namespaces {
+		for _, outcome := range []string{verify.OutcomePassed, verify.OutcomeFailed, verify.OutcomeWaived} {
+			reviews[reviewLabels{outcome, ns}] += 0
+		}
+	}
+
+	var b bytes.Buffer
+	writeHeader(&b, "rbac_verification_reviews", "gauge", "Number of access reviews of the last run by outcome, namespace and role.")
+	var review_keys []reviewLabels
+	for k := range reviews {
+		review_keys = append(review_keys, k)
+	}
+	sort.Slice(review_keys, func(i, j int) bool {
+		if review_keys[i].namespace != review_keys[j].namespace {
+			return review_keys[i].namespace < review_keys[j].namespace
+		}
+		return review_keys[i].outcome < review_keys[j].outcome
+	})
+	for _, k := range review_keys {
+		fmt.Fprintf(&b, "rbac_verification_reviews{outcome=%s,namespace=%s,role=%s} %d\n", quote(k.outcome), quote(k.namespace), quote(m.Run.Role), reviews[k])
+	}
+
+	writeHeader(&b, "rbac_verification_mismatches", "gauge", "Number of failed access reviews of the last run by api group.")
+	var groups []string
+	for k := range mismatches {
+		groups = append(groups, k)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		fmt.Fprintf(&b, "rbac_verification_mismatches{apigroup=%s} %d\n", quote(g), mismatches[g])
+	}
+
+	writeHeader(&b, "rbac_verification_run_duration_seconds", "gauge", "Duration of the last run in seconds.")
+	fmt.Fprintf(&b, "rbac_verification_run_duration_seconds %s\n", formatFloat(m.Duration.Seconds()))
+
+	writeHeader(&b, apiErrorsMetric, "counter", "Number of failed access review api calls.")
+	fmt.Fprintf(&b, "%s %d\n", apiErrorsMetric, m.ApiErrors)
+
+	if !m.LastSuccess.IsZero() {
+		writeHeader(&b, lastSuccessMetric, "gauge", "Unix timestamp of the last run without failed reviews or api errors.")
+		fmt.Fprintf(&b, "%s %s\n", lastSuccessMetric, formatFloat(float64(m.LastSuccess.UnixNano())/1e9))
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// WriteFile writes the metrics to a temporary file renamed in place, so the textfile collector never reads a partial file
+func WriteFile(path string, m RunMetrics) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if err := Write(tmp, m); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadLastSuccess returns the last success timestamp of a previously written metrics file, zero if there is none
+func ReadLastSuccess(path string) time.Time {
+	if v, ok := readMetric(path, lastSuccessMetric); ok {
+		return time.Unix(0, int64(v*1e9))
+	}
+	return time.Time{}
+}
+
+// ReadApiErrors returns the api errors counter of a previously written metrics file, so it keeps counting across runs
+func ReadApiErrors(path string) int {
+	v, _ := readMetric(path, apiErrorsMetric)
+	return int(v)
+}
+
+// Handler serves the metrics of the last run on /metrics
+type Handler struct {
+	mu   sync.RWMutex
+	body []byte
+}
+
+func (h *Handler) Update(m RunMetrics) error {
+	var b bytes.Buffer
+	if err := Write(&b, m); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.body = b.Bytes()
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(h.body)
+}
+
+// helper functions
+func writeHeader(b *bytes.Buffer, name string, kind string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func quote(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// readMetric returns the value of an unlabeled metric of a previously written metrics file
+func readMetric(path string, name string) (float64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == name {
+			if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+)
+
+var run_metrics = RunMetrics{
+	Run: verify.RunResult{
+		Role:       "namespace-admin",
+		Namespaces: []string{"smoke-test"},
+		Results: []verify.ReviewResult{
+			{Group: "", Resource: "pods", Namespace: "smoke-test", Verb: "get", Outcome: verify.OutcomePassed},
+			{Group: "apps", Resource: "statefulsets", Namespace: "smoke-test", Verb: "get", Outcome: verify.OutcomeFailed},
+			{Group: "apps", Resource: "deployments", Namespace: "smoke-test", Verb: "get", Outcome: verify.OutcomeFailed},
+			{Group: "", Resource: "pods/exec", Namespace: "smoke-test", Verb: "list", Outcome: verify.OutcomeWaived},
+			{Group: "crd.projectcalico.org", Resource: "ippools", Namespace: "", Verb: "get", Outcome: verify.OutcomePassed},
+		},
+	},
+	Duration:    1500 * time.Millisecond,
+	ApiErrors:   2,
+	LastSuccess: time.Unix(1671500000, 0),
+}
+
+var expected_metrics = `# HELP rbac_verification_reviews Number of access reviews of the last run by outcome, namespace and role.
+# TYPE rbac_verification_reviews gauge
+rbac_verification_reviews{outcome="passed",namespace="",role="namespace-admin"} 1
+rbac_verification_reviews{outcome="failed",namespace="smoke-test",role="namespace-admin"} 2
+rbac_verification_reviews{outcome="passed",namespace="smoke-test",role="namespace-admin"} 1
+rbac_verification_reviews{outcome="waived",namespace="smoke-test",role="namespace-admin"} 1
+# HELP rbac_verification_mismatches Number of failed access reviews of the last run by api group.
+# TYPE rbac_verification_mismatches gauge
+rbac_verification_mismatches{apigroup="apps"} 2
+# HELP rbac_verification_run_duration_seconds Duration of the last run in seconds.
+# TYPE rbac_verification_run_duration_seconds gauge
+rbac_verification_run_duration_seconds 1.5
+# HELP rbac_verification_api_errors_total Number of failed access review api calls.
+# TYPE rbac_verification_api_errors_total counter
+rbac_verification_api_errors_total 2
+# HELP rbac_verification_last_success_timestamp_seconds Unix timestamp of the last run without failed reviews or api errors.
+# TYPE rbac_verification_last_success_timestamp_seconds gauge
+rbac_verification_last_success_timestamp_seconds 1.6715e+09
+`
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, run_metrics); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != expected_metrics {
+		t.Errorf("expected:\n%s\ngot\n%s", expected_metrics, b.String())
+	}
+	if run_metrics.Successful() {
+		t.Error("a run with failed reviews should not be successful")
+	}
+}
+
+func TestWriteFileAndReadLastSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_verification.prom")
+	if last := ReadLastSuccess(path); !last.IsZero() || ReadApiErrors(path) != 0 {
+		t.Errorf("expected no last success nor api errors without a metrics file, got %s", last)
+	}
+	if err := WriteFile(path, run_metrics); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected_metrics {
+		t.Errorf("unexpected metrics file content:\n%s", string(data))
+	}
+	if last := ReadLastSuccess(path); !last.Equal(run_metrics.LastSuccess) {
+		t.Errorf("expected last success %s, got %s", run_metrics.LastSuccess, last)
+	}
+	if api_errors := ReadApiErrors(path); api_errors != run_metrics.ApiErrors {
+		t.Errorf("expected %d api errors, got %d", run_metrics.ApiErrors, api_errors)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Errorf("expected the temporary file to be renamed, got %d files", len(files))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := &Handler{}
+	if err := h.Update(run_metrics); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Result().Body)
+	if string(body) != expected_metrics {
+		t.Errorf("unexpected metrics served:\n%s", string(body))
+	}
+}
@@ -0,0 +1,226 @@
+package minimize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Turn an expanded permission set back into compact rbac rules:
+
+	*within an apigroup, the resources with identical verbs are grouped into one rule
+	*rules with identical resources and verbs are merged across apigroups
+	*apigroups, resources and verbs are sorted, so are the rules, for a deterministic output
+
+Wildcards are not restored, the rules list the resources of the catalog, hence the minimized role is only equivalent
+to the original one against the catalog it was expanded with. nonResourceURLs rules are not expanded and are dropped.
+Rules restricted to resourceNames are not expanded either, expanding them would grant every object of their resources,
+they are carried through unchanged.
+*/
+type Rule struct {
+	ApiGroups     []string `json:"apiGroups"`
+	Resources     []string `json:"resources"`
+	Verbs         []string `json:"verbs"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+type Metadata struct {
+	Name string `json:"name"`
+}
+
+type ClusterRole struct {
+	ApiVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata"`
+	Rules      []Rule   `json:"rules"`
+}
+
+func Minimize(rules map[string]proc_rules.ApiGroupValueType) []Rule {
+	// group the resources of each apigroup by verbs
+	type groupedKey struct {
+		group string
+		verbs string
+	}
+	grouped := make(map[groupedKey][]string)
+	for k, v := range rules {
+		for kk, vv := range v.Resource {
+			if len(vv.Verbs) == 0 {
+				continue
+			}
+			verbs := append([]string{}, vv.Verbs...)
+			sort.Strings(verbs)
+			verbs = slices.Compact(verbs)
+			gk := groupedKey{k, strings.Join(verbs, ",")}
+			grouped[gk] = append(grouped[gk], kk)
+		}
+	}
+
+	// merge the apigroups with identical resources and verbs
+	type mergedKey struct {
+		resources string
+		verbs     string
+	}
+	merged := make(map[mergedKey][]string)
+	for gk, resources := range grouped {
+		sort.Strings(resources)
+		mk := mergedKey{strings.Join(resources, ","), gk.verbs}
+		merged[mk] = append(merged[mk], gk.group)
+	}
+
+	var ret []Rule
+	for mk, groups := range merged {
+		sort.Strings(groups)
+		resources, _ := utils.SplitString(mk.resources, ",")
+		verbs, _ := utils.SplitString(mk.verbs, ",")
+		ret = append(ret, Rule{groups, resources, verbs, nil})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if c := strings.Join(a.ApiGroups, ","); c != strings.Join(b.ApiGroups, ",") {
+			return c < strings.Join(b.ApiGroups, ",")
+		}
+		if c := strings.Join(a.Resources, ","); c != strings.Join(b.Resources, ",") {
+			return c < strings.Join(b.Resources, ",")
+		}
+		return strings.Join(a.Verbs, ",") < strings.Join(b.Verbs, ",")
+	})
+	return ret
+}
+
+/*
+Expand expands the rbac yaml file with ExpandRbacYaml, less the permissions granted only by rules restricted to
+resourceNames, which are returned apart and unchanged, in the order of the file.
+*/
+func Expand(logger *slog.Logger, path string) (proc_rules.ExpandedRole, []Rule, []diagnostics.Diagnostic, error) {
+	role, diags := proc_rules.ExpandRbacYaml(logger, path)
+	objects, err := rbac_objects.LoadFiles([]string{path})
+	if err != nil {
+		return role, nil, diags, err
+	}
+
+	type ruleKey struct {
+		document   int
+		rule_index int
+	}
+	var keys []ruleKey
+	restricted_rules := make(map[ruleKey]Rule)
+	collect := func(source rbac_objects.Origin, rules []rbacv1.PolicyRule) {
+		for i, r := range rules {
+			if len(r.ResourceNames) > 0 {
+				keys = append(keys, ruleKey{source.Document, i})
+				restricted_rules[ruleKey{source.Document, i}] = Rule{r.APIGroups, r.Resources, r.Verbs, r.ResourceNames}
+			}
+		}
+	}
+	for _, r := range objects.Roles {
+		collect(objects.Source[rbac_objects.KeyOf("Role", r.Namespace, r.Name)], r.Rules)
+	}
+	for _, r := range objects.ClusterRoles {
+		collect(objects.Source[rbac_objects.KeyOf("ClusterRole", "", r.Name)], r.Rules)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].document != keys[j].document {
+			return keys[i].document < keys[j].document
+		}
+		return keys[i].rule_index < keys[j].rule_index
+	})
+	var restricted []Rule
+	for _, k := range keys {
+		restricted = append(restricted, restricted_rules[k])
+	}
+
+	// drop the permissions no unrestricted rule grants
+	for key, refs := range role.Origin {
+		var kept []proc_rules.RuleRef
+		for _, ref := range refs {
+			if _, ok := restricted_rules[ruleKey{ref.Document, ref.RuleIndex}]; !ok {
+				kept = append(kept, ref)
+			}
+		}
+		if len(kept) > 0 {
+			role.Origin[key] = kept
+			continue
+		}
+		delete(role.Origin, key)
+		entry := role.Rules[key.Group].Resource[key.Resource]
+		var verbs []string
+		for _, v := range entry.Verbs {
+			if v != key.Verb {
+				verbs = append(verbs, v)
+			}
+		}
+		entry.Verbs = verbs
+		role.Rules[key.Group].Resource[key.Resource] = entry
+	}
+	return role, restricted, diags, nil
+}
+
+func Marshal(name string, rules []Rule) ([]byte, error) {
+	return yaml.Marshal(ClusterRole{
+		ApiVersion: "rbac.authorization.k8s.io/v1",
+		Kind:       "ClusterRole",
+		Metadata:   Metadata{Name: name},
+		Rules:      rules,
+	})
+}
+
+/*
+Verify proves the minimized yaml is equivalent to the role by expanding it again with Expand, against the current
+AllResourcesMap, and subtracting each permission set from the other; the rules restricted to resourceNames must be
+carried through unchanged. The expansion overwrites RbacRulesMap and RbacRulesOrigin.
+*/
+func Verify(logger *slog.Logger, role proc_rules.ExpandedRole, restricted []Rule, minimized []byte) error {
+	dir, err := os.MkdirTemp("", "minimize")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "minimized.yaml")
+	if err := os.WriteFile(path, minimized, 0644); err != nil {
+		return err
+	}
+
+	expanded, expanded_restricted, _, err := Expand(logger, path)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(restricted, expanded_restricted) {
+		return fmt.Errorf("the minimized role changes the resourceNames rules of %s: %v", role.File, expanded_restricted)
+	}
+	if missing := keysOf(proc_rules.SubtractRules(role.Rules, expanded.Rules)); len(missing) > 0 {
+		return fmt.Errorf("the minimized role misses permissions of %s: %v", role.File, missing)
+	}
+	if extra := keysOf(proc_rules.SubtractRules(expanded.Rules, role.Rules)); len(extra) > 0 {
+		return fmt.Errorf("the minimized role grants permissions not in %s: %v", role.File, extra)
+	}
+	return nil
+}
+
+// helper functions
+
+// keysOf lists the resources with verbs, a resource granted none of its available verbs grants nothing
+func keysOf(rules map[string]proc_rules.ApiGroupValueType) []string {
+	var keys []string
+	for k, v := range rules {
+		for kk, vv := range v.Resource {
+			if len(vv.Verbs) > 0 {
+				keys = append(keys, fmt.Sprintf("%s.%s %v", kk, k, vv.Verbs))
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
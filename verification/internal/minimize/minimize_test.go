@@ -0,0 +1,144 @@
+package minimize
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+bindings                                                v1                                     true         Binding                          [create]
+events                            ev                    v1                                     true         Event                            [create delete deletecollection get list patch update watch]
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/exec                                               v1                                     true         PodExecOptions                   [create get]
+pods/log                                                v1                                     true         Pod                              [get]
+services                          svc                   v1                                     true         Service                          [create delete get list patch update watch]
+events                            ev                    events.k8s.io/v1                       true         Event                            [create delete deletecollection get list patch update watch]`
+
+// pods/* entries repeated across rules, "bindings" granted none of its verbs
+var role_text = `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: [""]
+  resources: ["pods", "pods/exec", "bindings"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["pods/log", "services"]
+  verbs: ["get"]
+- apiGroups: [""]
+  resources: ["pods", "pods/exec", "services"]
+  verbs: ["create", "list", "get"]
+- apiGroups: ["events.k8s.io"]
+  resources: ["events"]
+  verbs: ["watch", "get"]
+- apiGroups: [""]
+  resources: ["events"]
+  verbs: ["get", "watch"]
+`
+
+func TestMinimize(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+	path := filepath.Join(dir, "role.yaml")
+	os.WriteFile(path, []byte(role_text), 0644)
+	role, _ := proc_rules.ExpandRbacYaml(utils.DiscardLogger(), path)
+
+	rules := Minimize(role.Rules)
+	expect := []Rule{
+		{[]string{""}, []string{"pods", "services"}, []string{"create", "get", "list"}, nil},
+		{[]string{""}, []string{"pods/exec"}, []string{"create", "get"}, nil},
+		{[]string{""}, []string{"pods/log"}, []string{"get"}, nil},
+		{[]string{"", "events.k8s.io"}, []string{"events"}, []string{"get", "watch"}, nil},
+	}
+	if !reflect.DeepEqual(rules, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, rules)
+	}
+
+	data, err := Marshal(role.Name, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: namespace-admin\n") {
+		t.Errorf("unexpected yaml:\n%s", data)
+	}
+	if err := Verify(utils.DiscardLogger(), role, nil, data); err != nil {
+		t.Errorf("expected the minimized role to be equivalent: %s", err)
+	}
+
+	missing, _ := Marshal(role.Name, rules[1:])
+	if err := Verify(utils.DiscardLogger(), role, nil, missing); err == nil || !strings.Contains(err.Error(), "misses permissions") {
+		t.Errorf("expected missing permissions, got %v", err)
+	}
+}
+
+// "secrets" is granted only to its named objects, "services" both by name and to all objects
+var restricted_role_text = `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: restricted
+rules:
+- apiGroups: [""]
+  resources: ["secrets"]
+  resourceNames: ["token"]
+  verbs: ["get"]
+- apiGroups: [""]
+  resources: ["services"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["services"]
+  resourceNames: ["api"]
+  verbs: ["get", "update"]
+`
+
+func TestMinimizeResourceNames(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt+`
+secrets                                                 v1                                     true         Secret                           [create delete deletecollection get list patch update watch]`), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+	path := filepath.Join(dir, "role.yaml")
+	os.WriteFile(path, []byte(restricted_role_text), 0644)
+	role, restricted, _, err := Expand(utils.DiscardLogger(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect_restricted := []Rule{
+		{[]string{""}, []string{"secrets"}, []string{"get"}, []string{"token"}},
+		{[]string{""}, []string{"services"}, []string{"get", "update"}, []string{"api"}},
+	}
+	if !reflect.DeepEqual(restricted, expect_restricted) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect_restricted, restricted)
+	}
+	rules := append(Minimize(role.Rules), restricted...)
+	expect := append([]Rule{{[]string{""}, []string{"services"}, []string{"get", "list"}, nil}}, expect_restricted...)
+	if !reflect.DeepEqual(rules, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, rules)
+	}
+
+	data, err := Marshal(role.Name, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "resourceNames:\n  - token\n") {
+		t.Errorf("expected the resourceNames to be kept:\n%s", data)
+	}
+	if err := Verify(utils.DiscardLogger(), role, restricted, data); err != nil {
+		t.Errorf("expected the minimized role to be equivalent: %s", err)
+	}
+
+	// expanding the restricted rule grants every secret
+	widened := append([]Rule{}, rules...)
+	widened[1] = Rule{[]string{""}, []string{"secrets"}, []string{"get"}, nil}
+	data, _ = Marshal(role.Name, widened)
+	if err := Verify(utils.DiscardLogger(), role, restricted, data); err == nil {
+		t.Errorf("expected the widened role to fail verification")
+	}
+}
@@ -0,0 +1,356 @@
+package onboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v3"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+/*
+Generate the bindings of a new team namespace, like the hand-copied smoke-test and mldev files:
+
+	*<namespace>-namespace-admin-rolebinding.yaml: the admin group bound to the namespace-admin ClusterRole
+	*<namespace>-namespace-viewer-rolebinding.yaml: the viewer group bound to the view ClusterRole
+	*the node-viewer ClusterRoleBinding: the admin group appended to its subjects, so it can view the nodes
+
+The groups are named by the convention, "{namespace}" replaced with the namespace, i.e. "oidc:{namespace}-namespace-admin".
+The subject is appended to the text of the ClusterRoleBinding file, which must end with its subjects.
+
+Before anything is written, Verify expands the permissions of both groups from the files with the new bindings,
+offline, and checks that each group is granted exactly the namespaced permissions of its role in the namespace, and
+nothing namespaced anywhere else.
+*/
+type Convention struct {
+	AdminGroup        string `json:"adminGroup"`
+	ViewerGroup       string `json:"viewerGroup"`
+	AdminRole         string `json:"adminRole"`
+	ViewerRole        string `json:"viewerRole"`
+	NodeViewerBinding string `json:"nodeViewerBinding"`
+}
+
+// DefaultConvention is the naming of the existing namespaces
+var DefaultConvention = Convention{
+	AdminGroup:        "oidc:{namespace}-namespace-admin",
+	ViewerGroup:       "oidc:{namespace}-namespace-viewer",
+	AdminRole:         "namespace-admin",
+	ViewerRole:        "view",
+	NodeViewerBinding: "node-viewer",
+}
+
+type File struct {
+	Path    string `json:"path"`
+	Created bool   `json:"created"` // false when an existing file is updated
+	Content string `json:"content"`
+}
+
+type Plan struct {
+	Namespace   string `json:"namespace"`
+	AdminGroup  string `json:"adminGroup"`
+	ViewerGroup string `json:"viewerGroup"`
+	Files       []File `json:"files"`
+	convention  Convention
+}
+
+type Check struct {
+	Group       string   `json:"group"`
+	Description string   `json:"description"`
+	Passed      bool     `json:"passed"`
+	Details     []string `json:"details,omitempty"`
+}
+
+const namespacePlaceholder = "{namespace}"
+
+const roleBindingFormat = `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: %s
+  namespace: %s
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: %s
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: %s
+`
+
+const groupSubjectFormat = `- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: %s
+`
+
+// Generate plans the files of the namespace in dir, objects are the loaded files of dir
+func Generate(objects *rbac_objects.Objects, dir string, namespace string, c Convention) (Plan, error) {
+	// the namespace names the files and groups, i.e. "../x" would write outside dir
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return Plan{}, fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+	}
+	plan := Plan{
+		Namespace:   namespace,
+		AdminGroup:  strings.ReplaceAll(c.AdminGroup, namespacePlaceholder, namespace),
+		ViewerGroup: strings.ReplaceAll(c.ViewerGroup, namespacePlaceholder, namespace),
+		convention:  c,
+	}
+	for _, b := range []struct{ suffix, role, group string }{
+		{"namespace-admin", c.AdminRole, plan.AdminGroup},
+		{"namespace-viewer", c.ViewerRole, plan.ViewerGroup},
+	} {
+		name := namespace + "-" + b.suffix
+		if _, ok := objects.Source[rbac_objects.KeyOf("RoleBinding", namespace, name)]; ok {
+			return plan, fmt.Errorf("RoleBinding %s/%s already exists", namespace, name)
+		}
+		path := filepath.Join(dir, name+"-rolebinding.yaml")
+		if _, err := os.Stat(path); err == nil {
+			return plan, fmt.Errorf("%s already exists", path)
+		}
+		plan.Files = append(plan.Files, File{path, true, fmt.Sprintf(roleBindingFormat, name, namespace, b.role, b.group)})
+	}
+
+	key := rbac_objects.KeyOf("ClusterRoleBinding", "", c.NodeViewerBinding)
+	origin, ok := objects.Source[key]
+	if !ok || origin.File == "" {
+		return plan, fmt.Errorf("%s is not loaded from the files", key)
+	}
+	for _, b := range objects.ClusterRoleBindings {
+		if b.Name == c.NodeViewerBinding && slices.ContainsFunc(b.Subjects, func(s rbacv1.Subject) bool {
+			return s.Kind == rbacv1.GroupKind && s.Name == plan.AdminGroup
+		}) {
+			// already a subject
+			return plan, nil
+		}
+	}
+	content, err := appendSubject(origin, plan.AdminGroup)
+	if err != nil {
+		return plan, err
+	}
+	plan.Files = append(plan.Files, File{origin.File, false, content})
+	return plan, nil
+}
+
+// Objects loads the files as they will be once the plan is written
+func (p Plan) Objects(files []string) (*rbac_objects.Objects, error) {
+	var kept []string
+	for _, f := range files {
+		if !slices.ContainsFunc(p.Files, func(pf File) bool { return filepath.Clean(pf.Path) == filepath.Clean(f) }) {
+			kept = append(kept, f)
+		}
+	}
+	objects, err := rbac_objects.LoadFiles(kept)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range p.Files {
+		if err := objects.Decode(f.Path, strings.NewReader(f.Content)); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// Verify checks the permissions of both groups from objects, as returned by Objects, against the resource catalog parsed by ParseAllApiresources
+func Verify(logger *slog.Logger, objects *rbac_objects.Objects, p Plan) ([]Check, error) {
+	var checks []Check
+	var admin effective.Permissions
+	for i, g := range []struct{ group, role string }{{p.AdminGroup, p.convention.AdminRole}, {p.ViewerGroup, p.convention.ViewerRole}} {
+		perms, err := effective.Compute(logger, objects, effective.Identity{Groups: []string{g.group}})
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			admin = perms
+		}
+
+		intended := Check{Group: g.group, Description: fmt.Sprintf("granted ClusterRole %q in namespace %q", g.role, p.Namespace), Passed: true}
+		role, ok := perms.Namespaces[p.Namespace]
+		if _, found := objects.ClusterRole(g.role); !found {
+			intended.Passed = false
+			intended.Details = append(intended.Details, fmt.Sprintf("ClusterRole %q is neither in the files nor a default role", g.role))
+		} else if !ok || len(namespacedOf(role.Rules)) == 0 {
+			intended.Passed = false
+			intended.Details = append(intended.Details, "no namespaced permission is granted in the namespace")
+		} else {
+			expected, err := expandRole(logger, objects, p.Namespace, g.role)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range namespacedOf(proc_rules.SubtractRules(expected.Rules, role.Rules)) {
+				intended.Details = append(intended.Details, "missing: "+r)
+			}
+			for _, r := range namespacedOf(proc_rules.SubtractRules(role.Rules, expected.Rules)) {
+				intended.Details = append(intended.Details, "beyond the role: "+r)
+			}
+			intended.Passed = len(intended.Details) == 0
+		}
+		checks = append(checks, intended)
+
+		isolated := Check{Group: g.group, Description: "no namespaced permission outside of the namespace", Passed: true}
+		for _, ns := range perms.Scopes()[1:] {
+			if ns != p.Namespace {
+				isolated.Details = append(isolated.Details, fmt.Sprintf("namespace %q: granted by %v", ns, bindingsIn(perms.Bindings, ns)))
+			}
+		}
+		for _, r := range namespacedOf(perms.Cluster.Rules) {
+			isolated.Details = append(isolated.Details, "cluster-wide: "+r)
+		}
+		isolated.Passed = len(isolated.Details) == 0
+		checks = append(checks, isolated)
+	}
+
+	key := rbac_objects.KeyOf("ClusterRoleBinding", "", p.convention.NodeViewerBinding)
+	checks = append(checks, Check{Group: p.AdminGroup, Description: "bound by " + key, Passed: slices.Contains(admin.Bindings, key)})
+	return checks, nil
+}
+
+// Passed reports whether all checks passed
+func Passed(checks []Check) bool {
+	for _, c := range checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes the files of the plan
+func (p Plan) Write() error {
+	for _, f := range p.Files {
+		if err := os.WriteFile(f.Path, []byte(f.Content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteText(w io.Writer, p Plan, checks []Check) {
+	fmt.Fprintf(w, "Onboarding namespace %q, admin group %q, viewer group %q\n", p.Namespace, p.AdminGroup, p.ViewerGroup)
+	for _, f := range p.Files {
+		action := "update"
+		if f.Created {
+			action = "create"
+		}
+		fmt.Fprintf(w, "  %s %s\n", action, f.Path)
+	}
+	for _, c := range checks {
+		result := "PASS"
+		if !c.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "%s: %s: %s\n", result, c.Group, c.Description)
+		for _, d := range c.Details {
+			fmt.Fprintf(w, "  %s\n", d)
+		}
+	}
+}
+
+func WriteJson(w io.Writer, p Plan, checks []Check) error {
+	if checks == nil {
+		checks = []Check{}
+	}
+	j, err := json.MarshalIndent(struct {
+		Plan   Plan    `json:"plan"`
+		Checks []Check `json:"checks"`
+	}{p, checks}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+
+// appendSubject appends the group to the subjects of the ClusterRoleBinding, the last key of the last document of its file
+func appendSubject(origin rbac_objects.Origin, group string) (string, error) {
+	data, err := os.ReadFile(origin.File)
+	if err != nil {
+		return "", err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var last yaml.Node
+	documents := 0
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", fmt.Errorf("%s: %w", origin.File, err)
+		}
+		last = node
+		documents++
+	}
+	if documents != origin.Document+1 || len(last.Content) == 0 {
+		return "", fmt.Errorf("%s: the ClusterRoleBinding is not the last document, add %q to its subjects by hand", origin.File, group)
+	}
+	root := last.Content[0]
+	if root.Kind != yaml.MappingNode || len(root.Content) < 2 || root.Content[len(root.Content)-2].Value != "subjects" {
+		return "", fmt.Errorf("%s: the ClusterRoleBinding does not end with its subjects, add %q to them by hand", origin.File, group)
+	}
+
+	content := string(data)
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + fmt.Sprintf(groupSubjectFormat, group), nil
+}
+
+/*
+expandRole expands the ClusterRole as bound alone in the namespace, to a group no other binding names. The bindings of
+"system:authenticated" apply to any group, so they are in both expansions.
+*/
+func expandRole(logger *slog.Logger, objects *rbac_objects.Objects, namespace string, name string) (proc_rules.ExpandedRole, error) {
+	const group = "onboard:intended-role"
+	bound := rbac_objects.New()
+	bound.Merge(objects)
+	bound.AddRoleBinding(rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: group, Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: group}},
+	}, rbac_objects.Origin{})
+	perms, err := effective.Compute(logger, bound, effective.Identity{Groups: []string{group}})
+	if err != nil {
+		return proc_rules.ExpandedRole{}, err
+	}
+	return perms.Role(namespace), nil
+}
+
+// namespacedOf lists the namespaced resources granted some verb, as "apigroup: <group>, resource: <resource>, verbs:
+// <verbs>", sorted
+func namespacedOf(rules map[string]proc_rules.ApiGroupValueType) []string {
+	var ret []string
+	for k, v := range rules {
+		for kk, vv := range v.Resource {
+			if vv.Namespaced && len(vv.Verbs) > 0 {
+				ret = append(ret, fmt.Sprintf("apigroup: %q, resource: %q, verbs: %v", k, kk, vv.Verbs))
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func bindingsIn(bindings []string, namespace string) []string {
+	var ret []string
+	for _, b := range bindings {
+		if strings.HasPrefix(b, "RoleBinding/"+namespace+"/") {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
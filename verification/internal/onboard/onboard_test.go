@@ -0,0 +1,199 @@
+package onboard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+nodes                             no                    v1                                     false        Node                             [create delete deletecollection get list patch update watch]
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]`
+
+var rbac_files = map[string]string{
+	"namespace-admin-clusterrole.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["*"]
+`,
+	"view-clusterrole.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: view
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list"]
+`,
+	"node-viewer-clusterrole.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: node-viewer
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list"]
+`,
+	"node-viewer-clusterrolebinding.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: node-viewer
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin`,
+	"smoke-test-namespace-viewer-rolebinding.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-namespace-viewer
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: view
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:namespace-viewer
+`,
+}
+
+func setup(t *testing.T) (string, []string, *rbac_objects.Objects) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api_resources.txt")
+	os.WriteFile(path, []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(path)
+
+	var files []string
+	for name, text := range rbac_files {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, []byte(text), 0644)
+		files = append(files, file)
+	}
+	objects, err := rbac_objects.LoadFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, files, objects
+}
+
+func TestGenerateAndVerify(t *testing.T) {
+	dir, files, objects := setup(t)
+	plan, err := Generate(objects, dir, "team-x", DefaultConvention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Files) != 3 || plan.AdminGroup != "oidc:team-x-namespace-admin" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	admin := plan.Files[0]
+	if admin.Path != filepath.Join(dir, "team-x-namespace-admin-rolebinding.yaml") || !admin.Created ||
+		!strings.Contains(admin.Content, "  namespace: team-x\n") {
+		t.Errorf("unexpected admin binding %+v", admin)
+	}
+	// the subject is appended after the last line, without a trailing newline
+	if !strings.HasSuffix(plan.Files[2].Content, "  name: oidc:mldev-namespace-admin\n- apiGroup: rbac.authorization.k8s.io\n  kind: Group\n  name: oidc:team-x-namespace-admin\n") {
+		t.Errorf("unexpected node-viewer binding\n%s", plan.Files[2].Content)
+	}
+
+	planned, err := plan.Objects(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(planned.ClusterRoleBindings) != 1 || len(planned.RoleBindings) != 3 {
+		t.Fatalf("expected the updated binding to replace the file, got %d clusterrolebindings", len(planned.ClusterRoleBindings))
+	}
+	checks, err := Verify(utils.DiscardLogger(), planned, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Passed(checks) || len(checks) != 5 {
+		t.Errorf("expected all checks to pass, got %+v", checks)
+	}
+
+	if err := plan.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(planned, dir, "team-x", DefaultConvention); err == nil {
+		t.Errorf("expected an error onboarding the namespace again")
+	}
+}
+
+func TestGenerateInvalidNamespace(t *testing.T) {
+	dir, _, objects := setup(t)
+	for _, namespace := range []string{"../x", "Team-X", ""} {
+		if _, err := Generate(objects, dir, namespace, DefaultConvention); err == nil || !strings.Contains(err.Error(), "invalid namespace") {
+			t.Errorf("%q: expected an invalid namespace error, got %v", namespace, err)
+		}
+	}
+}
+
+func TestVerifySharedGroup(t *testing.T) {
+	dir, files, objects := setup(t)
+	c := DefaultConvention
+	c.ViewerGroup = "oidc:namespace-viewer"
+	plan, err := Generate(objects, dir, "team-x", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned, _ := plan.Objects(files)
+	checks, _ := Verify(utils.DiscardLogger(), planned, plan)
+	if Passed(checks) {
+		t.Fatalf("expected the shared viewer group to fail")
+	}
+	for _, check := range checks {
+		if !check.Passed && (check.Group != "oidc:namespace-viewer" || !strings.Contains(strings.Join(check.Details, ""), "smoke-test")) {
+			t.Errorf("unexpected failed check %+v", check)
+		}
+	}
+}
+
+func TestVerifyMissingRole(t *testing.T) {
+	dir, files, objects := setup(t)
+	c := DefaultConvention
+	c.AdminRole = "team-admin"
+	plan, _ := Generate(objects, dir, "team-x", c)
+	planned, _ := plan.Objects(files)
+	checks, _ := Verify(utils.DiscardLogger(), planned, plan)
+	if checks[0].Passed || !strings.Contains(checks[0].Details[0], "team-admin") {
+		t.Errorf("expected the missing admin role to fail, got %+v", checks[0])
+	}
+}
+
+func TestVerifyBeyondRole(t *testing.T) {
+	dir, files, objects := setup(t)
+	plan, _ := Generate(objects, dir, "team-x", DefaultConvention)
+	planned, _ := plan.Objects(files)
+	// the viewer group is also admin of the namespace, it holds more than view there
+	planned.AddRoleBinding(rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-x-extra", Namespace: "team-x"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "namespace-admin"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: plan.ViewerGroup}},
+	}, rbac_objects.Origin{File: "extra.yaml"})
+	checks, err := Verify(utils.DiscardLogger(), planned, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := checks[2]
+	if viewer.Passed || len(viewer.Details) != 1 || !strings.HasPrefix(viewer.Details[0], `beyond the role: apigroup: "", resource: "pods", verbs: [create delete deletecollection`) {
+		t.Errorf("expected the viewer group to hold more than view, got %+v", viewer)
+	}
+	if !checks[0].Passed {
+		t.Errorf("expected the admin group to pass, got %+v", checks[0])
+	}
+}
@@ -0,0 +1,152 @@
+package orphans
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slices"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+/*
+Find the leftover and dangling rbac objects, i.e. the rolebindings of a decommissioned project, as diagnostics:
+
+	*dangling-role-ref (error): a binding's roleRef points to a missing Role or ClusterRole
+	*missing-namespace (error): a RoleBinding, or a Role, in a namespace that does not exist
+	*unreferenced-role (warning): a Role or ClusterRole no binding references
+	*missing-serviceaccount (warning): a ServiceAccount subject whose account does not exist
+
+The builtin ClusterRoles, i.e. the upstream defaults admin, edit, view and cluster-admin, and the "system:" ClusterRoles
+exist in every cluster: a roleRef to one of them is not dangling when it is not loaded, and they are never reported as
+unreferenced. A ClusterRole aggregated into a referenced one is referenced. Namespaces and service accounts are only
+checked when some were loaded, i.e. from a live snapshot, a service account only when the accounts of its namespace were.
+
+Objects of a file are reported on their document, objects of the cluster on the file "cluster", document 0.
+*/
+const (
+	RuleDanglingRoleRef       = "dangling-role-ref"
+	RuleMissingNamespace      = "missing-namespace"
+	RuleUnreferencedRole      = "unreferenced-role"
+	RuleMissingServiceAccount = "missing-serviceaccount"
+)
+
+// Rules describes the rule IDs of Analyze, with their default severity
+var Rules = []diagnostics.Rule{
+	{ID: RuleDanglingRoleRef, Severity: diagnostics.SeverityError, Description: "The roleRef of the binding points to a missing Role or ClusterRole, it grants nothing."},
+	{ID: RuleMissingNamespace, Severity: diagnostics.SeverityError, Description: "The object is in a namespace that does not exist, i.e. of a decommissioned project."},
+	{ID: RuleUnreferencedRole, Severity: diagnostics.SeverityWarning, Description: "No binding references the role."},
+	{ID: RuleMissingServiceAccount, Severity: diagnostics.SeverityWarning, Description: "The ServiceAccount subject of the binding does not exist."},
+}
+
+func Analyze(objects *rbac_objects.Objects, builtin []string) []diagnostics.Diagnostic {
+	var diags []diagnostics.Diagnostic
+	report := func(rule_id string, severity diagnostics.Severity, key string, format string, a ...interface{}) {
+		origin := objects.Source[key]
+		file := origin.File
+		if file == "" {
+			file = origin.String()
+		}
+		diags = append(diags, diagnostics.Diagnostic{
+			RuleID:    rule_id,
+			Severity:  severity,
+			Message:   fmt.Sprintf("%s: %s", key, fmt.Sprintf(format, a...)),
+			File:      file,
+			Document:  origin.Document,
+			RuleIndex: -1,
+		})
+	}
+
+	namespaces := make(map[string]bool)
+	for _, ns := range objects.Namespaces {
+		namespaces[ns.Name] = true
+	}
+	accounts := make(map[string]bool)
+	account_namespaces := make(map[string]bool)
+	for _, sa := range objects.ServiceAccounts {
+		accounts[sa.Namespace+"/"+sa.Name] = true
+		account_namespaces[sa.Namespace] = true
+	}
+
+	referenced := make(map[string]bool)
+	checkBinding := func(key string, namespace string, ref rbacv1.RoleRef, subjects []rbacv1.Subject) {
+		ref_namespace := ""
+		if ref.Kind == "Role" {
+			ref_namespace = namespace
+		}
+		ref_key := rbac_objects.KeyOf(ref.Kind, ref_namespace, ref.Name)
+		referenced[ref_key] = true
+		_, found := objects.RulesOf(ref, namespace)
+		if !found && ref.Kind == "ClusterRole" {
+			found = isBuiltin(ref.Name, builtin)
+		}
+		if !found {
+			report(RuleDanglingRoleRef, diagnostics.SeverityError, key, "roleRef %s does not exist", ref_key)
+		}
+		for _, s := range subjects {
+			if s.Kind != rbacv1.ServiceAccountKind || !account_namespaces[s.Namespace] {
+				continue
+			}
+			if !accounts[s.Namespace+"/"+s.Name] {
+				report(RuleMissingServiceAccount, diagnostics.SeverityWarning, key, "serviceaccount %s/%s does not exist", s.Namespace, s.Name)
+			}
+		}
+	}
+
+	for _, b := range objects.ClusterRoleBindings {
+		checkBinding(rbac_objects.KeyOf("ClusterRoleBinding", "", b.Name), "", b.RoleRef, b.Subjects)
+	}
+	for _, b := range objects.RoleBindings {
+		key := rbac_objects.KeyOf("RoleBinding", b.Namespace, b.Name)
+		if len(namespaces) > 0 && !namespaces[b.Namespace] {
+			report(RuleMissingNamespace, diagnostics.SeverityError, key, "namespace %q does not exist", b.Namespace)
+		}
+		checkBinding(key, b.Namespace, b.RoleRef, b.Subjects)
+	}
+
+	for _, role := range objects.Roles {
+		key := rbac_objects.KeyOf("Role", role.Namespace, role.Name)
+		if len(namespaces) > 0 && !namespaces[role.Namespace] {
+			report(RuleMissingNamespace, diagnostics.SeverityError, key, "namespace %q does not exist", role.Namespace)
+		}
+		if !referenced[key] {
+			report(RuleUnreferencedRole, diagnostics.SeverityWarning, key, "no binding references the role")
+		}
+	}
+	for _, role := range objects.ClusterRoles {
+		key := rbac_objects.KeyOf("ClusterRole", "", role.Name)
+		if referenced[key] || aggregatedIntoReferenced(objects, role, referenced) {
+			continue
+		}
+		if isBuiltin(role.Name, builtin) {
+			continue
+		}
+		report(RuleUnreferencedRole, diagnostics.SeverityWarning, key, "no binding references the role")
+	}
+
+	diagnostics.Sort(diags)
+	return diags
+}
+
+// helper functions
+func isBuiltin(name string, builtin []string) bool {
+	return slices.Contains(builtin, name) || strings.HasPrefix(name, "system:")
+}
+
+func aggregatedIntoReferenced(objects *rbac_objects.Objects, role rbacv1.ClusterRole, referenced map[string]bool) bool {
+	for _, cr := range objects.ClusterRoles {
+		if cr.AggregationRule == nil || !referenced[rbac_objects.KeyOf("ClusterRole", "", cr.Name)] {
+			continue
+		}
+		for _, selector := range cr.AggregationRule.ClusterRoleSelectors {
+			s, err := metav1.LabelSelectorAsSelector(&selector)
+			if err == nil && s.Matches(labels.Set(role.Labels)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package orphans
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+  labels:
+    rbac.authorization.k8s.io/aggregate-to-admin: "true"
+rules: []
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: admin
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.authorization.k8s.io/aggregate-to-admin: "true"
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: leftover
+rules: []
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: admin
+subjects:
+- kind: ServiceAccount
+  name: deployer
+  namespace: mldev
+- kind: ServiceAccount
+  name: builder
+  namespace: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: project-lima-namespace-admin
+  namespace: project-lima
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: namespace-admin
+subjects:
+- kind: ServiceAccount
+  name: deployer
+  namespace: project-lima
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-view
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: view
+`
+
+func TestAnalyze(t *testing.T) {
+	objects := rbac_objects.New()
+	if err := objects.Decode("rbac.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a live snapshot, namespaces and service accounts are not checked
+	diags := Analyze(objects, []string{"admin", "edit", "view", "cluster-admin"})
+	expect := []string{
+		"rbac.yaml: document 2: warning: ClusterRole/leftover: no binding references the role [unreferenced-role]",
+		"rbac.yaml: document 4: error: RoleBinding/project-lima/project-lima-namespace-admin: roleRef Role/project-lima/namespace-admin does not exist [dangling-role-ref]",
+	}
+	assertDiagnostics(t, diags, expect)
+
+	live := rbac_objects.New()
+	for _, ns := range []string{"mldev", "smoke-test"} {
+		live.AddNamespace(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}, rbac_objects.OriginCluster)
+	}
+	live.AddServiceAccount(corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "mldev"}}, rbac_objects.OriginCluster)
+	objects.Merge(live)
+	diags = Analyze(objects, []string{"admin", "edit", "view", "cluster-admin"})
+	expect = []string{
+		"rbac.yaml: document 2: warning: ClusterRole/leftover: no binding references the role [unreferenced-role]",
+		"rbac.yaml: document 3: warning: RoleBinding/mldev/mldev-admin: serviceaccount mldev/builder does not exist [missing-serviceaccount]",
+		"rbac.yaml: document 4: error: RoleBinding/project-lima/project-lima-namespace-admin: namespace \"project-lima\" does not exist [missing-namespace]",
+		"rbac.yaml: document 4: error: RoleBinding/project-lima/project-lima-namespace-admin: roleRef Role/project-lima/namespace-admin does not exist [dangling-role-ref]",
+	}
+	assertDiagnostics(t, diags, expect)
+
+	if counts := diagnostics.Count(diags, diagnostics.SeverityError); counts != 2 {
+		t.Errorf("expected 2 errors, got %d", counts)
+	}
+}
+
+func assertDiagnostics(t *testing.T, diags []diagnostics.Diagnostic, expect []string) {
+	t.Helper()
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+}
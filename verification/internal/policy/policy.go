@@ -0,0 +1,345 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+Custom guardrails written as CEL expressions, each one must evaluate to true for a compliant permission or binding:
+
+	policies:
+	- id: no-cluster-namespace-delete
+	  severity: error
+	  scope: permission
+	  message: cluster roles must not delete namespaces
+	  expression: "!(role.namespaced == false && verbs.exists(v, v in ['delete', 'deletecollection']) && resource == 'namespaces')"
+	- id: no-cluster-admin-binding
+	  scope: binding
+	  expression: "binding.roleRef.name != 'cluster-admin'"
+
+A "permission" policy is evaluated for each apigroup and resource of the expanded roles, with the variables:
+
+	*role: {name, kind, namespaced, file}, namespaced is true for a Role, false for a ClusterRole
+	*group, resource: i.e. "" and "pods/exec", as written in rbac rules
+	*subresource: i.e. "exec", "" for a resource
+	*namespaced: true when the resource is namespaced
+	*verbs: the verbs granted on the resource
+
+A "binding" policy is evaluated for each RoleBinding and ClusterRoleBinding, with the variable:
+
+	*binding: {kind, name, namespace, file, roleRef: {apiGroup, kind, name}, subjects: [{kind, name, namespace, apiGroup}]}
+
+Violations are diagnostics with the policy id as rule ID, error severity unless set.
+*/
+type Policy struct {
+	ID         string               `json:"id"`
+	Severity   diagnostics.Severity `json:"severity,omitempty"`
+	Scope      string               `json:"scope"`
+	Expression string               `json:"expression"`
+	Message    string               `json:"message,omitempty"`
+}
+
+const (
+	ScopePermission = "permission"
+	ScopeBinding    = "binding"
+)
+
+type PolicySet struct {
+	policies []Policy
+	programs []cel.Program
+}
+
+// Load parses and compiles the policy file, rejecting unknown fields, scopes and severities, and non boolean expressions
+func Load(path string) (*PolicySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Policies []Policy `json:"policies"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	return Compile(file.Policies)
+}
+
+func Compile(policies []Policy) (*PolicySet, error) {
+	permission_env, err := cel.NewEnv(
+		cel.Variable("role", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("group", cel.StringType),
+		cel.Variable("resource", cel.StringType),
+		cel.Variable("subresource", cel.StringType),
+		cel.Variable("namespaced", cel.BoolType),
+		cel.Variable("verbs", cel.ListType(cel.StringType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	binding_env, err := cel.NewEnv(
+		cel.Variable("binding", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &PolicySet{}
+	ids := make(map[string]bool)
+	for i, p := range policies {
+		if p.ID == "" {
+			return nil, fmt.Errorf("policy #%d: id is required", i)
+		}
+		if ids[p.ID] {
+			return nil, fmt.Errorf("policy %s: duplicate id", p.ID)
+		}
+		ids[p.ID] = true
+		switch p.Severity {
+		case "":
+			p.Severity = diagnostics.SeverityError
+		case diagnostics.SeverityError, diagnostics.SeverityWarning, diagnostics.SeverityInfo, diagnostics.SeverityOff:
+		default:
+			return nil, fmt.Errorf("policy %s: invalid severity %q, expecting error, warning, info or off", p.ID, p.Severity)
+		}
+
+		var env *cel.Env
+		switch p.Scope {
+		case ScopePermission:
+			env = permission_env
+		case ScopeBinding:
+			env = binding_env
+		default:
+			return nil, fmt.Errorf("policy %s: scope must be %s or %s, got %q", p.ID, ScopePermission, ScopeBinding, p.Scope)
+		}
+		ast, issues := env.Compile(p.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("policy %s: %w", p.ID, issues.Err())
+		}
+		if !cel.BoolType.IsAssignableType(ast.OutputType()) {
+			return nil, fmt.Errorf("policy %s: expression must evaluate to a bool, got %s", p.ID, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", p.ID, err)
+		}
+		set.policies = append(set.policies, p)
+		set.programs = append(set.programs, program)
+	}
+	return set, nil
+}
+
+// Rules describes the policies as rule IDs
+func (s *PolicySet) Rules() []diagnostics.Rule {
+	if s == nil {
+		return nil
+	}
+	var rules []diagnostics.Rule
+	for _, p := range s.policies {
+		rules = append(rules, diagnostics.Rule{ID: p.ID, Severity: p.Severity, Description: messageOf(p, nil)})
+	}
+	return rules
+}
+
+// CheckRole evaluates the permission policies for each apigroup and resource of the role
+func (s *PolicySet) CheckRole(role proc_rules.ExpandedRole) []diagnostics.Diagnostic {
+	if s == nil || len(role.Rules) == 0 {
+		return nil
+	}
+	role_var := map[string]interface{}{
+		"name":       role.Name,
+		"kind":       role.Kind,
+		"namespaced": role.Kind == "Role",
+		"file":       role.File,
+	}
+
+	var diags []diagnostics.Diagnostic
+	for k, v := range role.Rules {
+		for kk, vv := range v.Resource {
+			if len(vv.Verbs) == 0 {
+				continue
+			}
+			verbs := append([]string{}, vv.Verbs...)
+			sort.Strings(verbs)
+			vars := map[string]interface{}{
+				"role":        role_var,
+				"group":       k,
+				"resource":    kk,
+				"subresource": vv.SubResource,
+				"namespaced":  vv.Namespaced,
+				"verbs":       verbs,
+			}
+			for i, p := range s.policies {
+				if p.Scope != ScopePermission {
+					continue
+				}
+				compliant, err := s.eval(i, vars)
+				if compliant {
+					continue
+				}
+				message := fmt.Sprintf("%s: apigroup %q, resource %q, verbs %v", messageOf(p, err), k, kk, verbs)
+				refs := refsOf(role, k, kk, verbs)
+				if len(refs) == 0 {
+					refs = []proc_rules.RuleRef{{File: role.File, Document: 0, RuleIndex: -1}}
+				}
+				for _, ref := range refs {
+					diags = append(diags, diagnostics.Diagnostic{
+						RuleID:    p.ID,
+						Severity:  p.Severity,
+						Message:   message,
+						File:      ref.File,
+						Document:  ref.Document,
+						RuleIndex: ref.RuleIndex,
+					})
+				}
+			}
+		}
+	}
+	sort.Slice(diags, func(i, j int) bool { return diags[i].Message < diags[j].Message })
+	diagnostics.Sort(diags)
+	return diags
+}
+
+// CheckBindings evaluates the binding policies for each RoleBinding and ClusterRoleBinding of the yaml file
+func (s *PolicySet) CheckBindings(path string) []diagnostics.Diagnostic {
+	if s == nil || !s.hasScope(ScopeBinding) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var diags []diagnostics.Diagnostic
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bufio.NewReader(f), 100)
+	for document := 0; ; document++ {
+		var b binding
+		if err := decoder.Decode(&b); err != nil {
+			// io.EOF, invalid documents are reported by ParseK8sRbacYaml
+			break
+		}
+		if b.Kind != "RoleBinding" && b.Kind != "ClusterRoleBinding" {
+			continue
+		}
+		vars := map[string]interface{}{"binding": b.toVar(path)}
+		for i, p := range s.policies {
+			if p.Scope != ScopeBinding {
+				continue
+			}
+			if compliant, err := s.eval(i, vars); !compliant {
+				diags = append(diags, diagnostics.Diagnostic{
+					RuleID:    p.ID,
+					Severity:  p.Severity,
+					Message:   fmt.Sprintf("%s: %s %q of %s %q", messageOf(p, err), b.Kind, b.Metadata.Name, b.RoleRef.Kind, b.RoleRef.Name),
+					File:      path,
+					Document:  document,
+					RuleIndex: -1,
+				})
+			}
+		}
+	}
+	return diags
+}
+
+// helper functions
+type subject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	ApiGroup  string `json:"apiGroup"`
+}
+
+type binding struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	RoleRef struct {
+		ApiGroup string `json:"apiGroup"`
+		Kind     string `json:"kind"`
+		Name     string `json:"name"`
+	} `json:"roleRef"`
+	Subjects []subject `json:"subjects"`
+}
+
+func (b binding) toVar(path string) map[string]interface{} {
+	subjects := make([]interface{}, 0, len(b.Subjects))
+	for _, s := range b.Subjects {
+		subjects = append(subjects, map[string]interface{}{
+			"kind":      s.Kind,
+			"name":      s.Name,
+			"namespace": s.Namespace,
+			"apiGroup":  s.ApiGroup,
+		})
+	}
+	return map[string]interface{}{
+		"kind":      b.Kind,
+		"name":      b.Metadata.Name,
+		"namespace": b.Metadata.Namespace,
+		"file":      path,
+		"roleRef": map[string]interface{}{
+			"apiGroup": b.RoleRef.ApiGroup,
+			"kind":     b.RoleRef.Kind,
+			"name":     b.RoleRef.Name,
+		},
+		"subjects": subjects,
+	}
+}
+
+// eval returns whether the policy holds, an evaluation error, i.e. a missing key, is a violation
+func (s *PolicySet) eval(i int, vars map[string]interface{}) (bool, error) {
+	out, _, err := s.programs[i].Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	compliant, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluated to %v, not a bool", out.Value())
+	}
+	return compliant, nil
+}
+
+func (s *PolicySet) hasScope(scope string) bool {
+	for _, p := range s.policies {
+		if p.Scope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func messageOf(p Policy, err error) string {
+	message := p.Message
+	if message == "" {
+		message = fmt.Sprintf("violates %s", p.Expression)
+	}
+	if err != nil {
+		message += fmt.Sprintf(" (evaluation error: %s)", err.Error())
+	}
+	return message
+}
+
+func refsOf(role proc_rules.ExpandedRole, group string, resource string, verbs []string) []proc_rules.RuleRef {
+	var refs []proc_rules.RuleRef
+	for _, verb := range verbs {
+		for _, ref := range role.Origin[proc_rules.PermissionKey{Group: group, Resource: resource, Verb: verb}] {
+			found := false
+			for _, r := range refs {
+				found = found || r == ref
+			}
+			if !found {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+namespaces                        ns                    v1                                     false        Namespace                        [create delete get list patch update watch]
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]`
+
+var policy_text = `policies:
+- id: no-cluster-namespace-delete
+  scope: permission
+  message: cluster roles must not delete namespaces
+  expression: "!(role.namespaced == false && verbs.exists(v, v in ['delete', 'deletecollection']) && resource == 'namespaces')"
+- id: no-user-bindings
+  severity: warning
+  scope: binding
+  expression: "binding.subjects.all(s, s.kind != 'User')"
+`
+
+var rbac_text = `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-operator
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["delete"]
+- apiGroups: [""]
+  resources: ["namespaces"]
+  verbs: ["get", "delete"]
+---
+kind: ClusterRoleBinding
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-operator
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-operator
+subjects:
+- kind: Group
+  name: oidc:cluster-operator
+- kind: User
+  name: jane
+`
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+	os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(policy_text), 0644)
+	path := filepath.Join(dir, "cluster-operator.yaml")
+	os.WriteFile(path, []byte(rbac_text), 0644)
+
+	policies, err := Load(filepath.Join(dir, "policy.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, _ := proc_rules.ExpandRbacYaml(utils.DiscardLogger(), path)
+	if role.Kind != "ClusterRole" {
+		t.Errorf("expected kind ClusterRole, got %q", role.Kind)
+	}
+
+	diags := append(policies.CheckRole(role), policies.CheckBindings(path)...)
+	expect := []diagnostics.Diagnostic{
+		{
+			RuleID:    "no-cluster-namespace-delete",
+			Severity:  diagnostics.SeverityError,
+			Message:   `cluster roles must not delete namespaces: apigroup "", resource "namespaces", verbs [delete get]`,
+			File:      path,
+			Document:  0,
+			RuleIndex: 1,
+		},
+		{
+			RuleID:    "no-user-bindings",
+			Severity:  diagnostics.SeverityWarning,
+			Message:   `violates binding.subjects.all(s, s.kind != 'User'): ClusterRoleBinding "cluster-operator" of ClusterRole "cluster-operator"`,
+			File:      path,
+			Document:  1,
+			RuleIndex: -1,
+		},
+	}
+	if !reflect.DeepEqual(diags, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, diags)
+	}
+
+	var nil_set *PolicySet
+	if diags := nil_set.CheckRole(role); diags != nil {
+		t.Errorf("expected no diagnostics without policies, got %+v", diags)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := map[string]Policy{
+		"id is required":          {Scope: ScopePermission, Expression: "true"},
+		"scope must be":           {ID: "p", Scope: "role", Expression: "true"},
+		"invalid severity":        {ID: "p", Severity: "fatal", Scope: ScopePermission, Expression: "true"},
+		"must evaluate to a bool": {ID: "p", Scope: ScopePermission, Expression: "resource"},
+		"undeclared reference":    {ID: "p", Scope: ScopeBinding, Expression: "resource == 'pods'"},
+	}
+	for msg, p := range cases {
+		if _, err := Compile([]Policy{p}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected error containing %q, got %v", msg, err)
+		}
+	}
+	if _, err := Compile([]Policy{{ID: "p", Scope: ScopeBinding, Expression: "true"}, {ID: "p", Scope: ScopeBinding, Expression: "true"}}); err == nil {
+		t.Error("expected an error for duplicate ids")
+	}
+}
@@ -0,0 +1,94 @@
+package process_rules
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+/*
+Positions of the documents and rules of the rbac yaml files, recorded by ParseK8sRbacYaml, so diagnostics located by
+{file, document, rule index} can be reported on lines, i.e. as code-scanning annotations. Documents are split and
+counted like the yaml decoder of ParseK8sRbacYaml: on lines starting with "---", skipping empty documents.
+*/
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type positionKey struct {
+	file       string
+	document   int
+	rule_index int
+}
+
+var rulePositions = make(map[positionKey]Position)
+
+// PositionOf returns the position of the rule, or of the document for rule index -1 or an unknown rule
+func PositionOf(file string, document int, rule_index int) (Position, bool) {
+	if p, ok := rulePositions[positionKey{file, document, rule_index}]; ok {
+		return p, true
+	}
+	p, ok := rulePositions[positionKey{file, document, -1}]
+	return p, ok
+}
+
+// RecordPositions records the positions of the documents and rules of a rbac yaml file, replacing previous ones
+func RecordPositions(file string, data []byte) {
+	for k := range rulePositions {
+		if k.file == file {
+			delete(rulePositions, k)
+		}
+	}
+
+	document, start_line, line_number := 0, 1, 0
+	var chunk bytes.Buffer
+	flush := func() {
+		if chunk.Len() == 0 {
+			return
+		}
+		recordDocument(file, document, start_line, chunk.Bytes())
+		document++
+		chunk.Reset()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line_number++
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") {
+			flush()
+			start_line = line_number + 1
+			continue
+		}
+		if chunk.Len() == 0 {
+			start_line = line_number
+		}
+		chunk.WriteString(line)
+		chunk.WriteString("\n")
+	}
+	flush()
+}
+
+func recordDocument(file string, document int, start_line int, data []byte) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return
+	}
+	doc := root.Content[0]
+	rulePositions[positionKey{file, document, -1}] = Position{doc.Line + start_line - 1, doc.Column}
+	if doc.Kind != yamlv3.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "rules" || doc.Content[i+1].Kind != yamlv3.SequenceNode {
+			continue
+		}
+		for rule_index, rule := range doc.Content[i+1].Content {
+			rulePositions[positionKey{file, document, rule_index}] = Position{rule.Line + start_line - 1, rule.Column}
+		}
+	}
+}
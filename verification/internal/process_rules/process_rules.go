@@ -2,12 +2,16 @@ package process_rules
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
 	"strings"
 
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
 	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
 	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
@@ -52,12 +56,78 @@ var AllResourcesMap map[string]ApiGroupValueType
 // RBAC yaml input rule serialized
 var RbacRulesMap map[string]ApiGroupValueType
 
+// Name of the role in the RBAC yaml input
+var RbacRoleName string
+
+// Kind of the role in the RBAC yaml input, "Role" or "ClusterRole"
+var RbacRoleKind string
+
+// Rules of the RBAC yaml input granting each expanded apigroup, resource and verb
+var RbacRulesOrigin map[PermissionKey][]RuleRef
+
+type PermissionKey struct {
+	Group    string `json:"group"`
+	Resource string `json:"resource"`
+	Verb     string `json:"verb"`
+}
+
+// RuleRef locates a rule in a rbac yaml file, Wildcard is set when the rule has "*" apiGroups, resources or verbs
+type RuleRef struct {
+	File      string `json:"file"`
+	Document  int    `json:"document"`
+	RuleIndex int    `json:"ruleIndex"`
+	Wildcard  bool   `json:"wildcard"`
+}
+
+/*
+Verbs checked by the authorizer but not served by the api, hence missing from "kubectl api-resources" output. They are
+still valid in rbac rules, and "*" verbs grant them too. The resources only checked by the authorizer, i.e. "users" or
+"signers", are valid without an entry in the catalog.
+*/
+var SpecialVerbs = map[string]map[string][]string{
+	"": {
+		"serviceaccounts": {"impersonate"},
+		"users":           {"impersonate"},
+		"groups":          {"impersonate"},
+	},
+	"authentication.k8s.io": {
+		"userextras": {"impersonate"},
+		"uids":       {"impersonate"},
+	},
+	"rbac.authorization.k8s.io": {
+		"roles":        {"bind", "escalate"},
+		"clusterroles": {"bind", "escalate"},
+	},
+	"certificates.k8s.io": {
+		"signers": {"approve", "sign"},
+	},
+	"policy": {
+		"podsecuritypolicies": {"use"},
+	},
+}
+
 // Forbidden ApiGroups, Resources and Verbs
 var ForbiddenRulesMap map[string]ApiGroupValueType
 
+// Rule IDs of the diagnostics found parsing a rbac yaml file
+const (
+	RuleInvalidYaml     = "invalid-yaml"
+	RuleIncompleteRule  = "incomplete-rule"
+	RuleNonResourceURL  = "non-resource-url"
+	RuleUnknownApiGroup = "unknown-apigroup"
+	RuleUnknownResource = "unknown-resource"
+	RuleUnavailableVerb = "unavailable-verb"
+)
+
 func ParseAllApiresources(path string) {
 	f := utils.ReadFile(path)
-	fileScanner := bufio.NewScanner(f)
+	ParseAllApiresourcesReader(f)
+	f.Close()
+}
+
+// ParseAllApiresourcesReader parses the resource catalog of r, i.e. the one of a snapshot archive
+func ParseAllApiresourcesReader(r io.Reader) {
+	fileScanner := bufio.NewScanner(r)
 	fileScanner.Split(bufio.ScanLines)
 	var fileLines []string
 	var header string
@@ -119,14 +189,14 @@ func ParseAllApiresources(path string) {
 		entry.Resource[name] = ResourceValueType{
 			subresource_name,
 			versions,
-			strings.Fields(short_names),
+			// several short names are comma-joined, i.e. "crd,crds"
+			strings.FieldsFunc(short_names, func(r rune) bool { return r == ',' || r == ' ' }),
 			kind,
 			namespaced == "true",
 			strings.Fields(verbs),
 		}
 		AllResourcesMap[apigroup] = entry
 	}
-	f.Close()
 }
 
 /*
@@ -144,25 +214,84 @@ I1219 20:25:17.188213 1678894 request.go:1181] Response Body:
 
 yes
 */
-func ParseK8sRbacYaml(path string) {
-
+func ParseK8sRbacYaml(logger *slog.Logger, path string) []diagnostics.Diagnostic {
 	f := utils.ReadFile(path)
-	decoder := yamlutil.NewYAMLOrJSONDecoder(bufio.NewReader(f), 100)
+	if f == nil {
+		return []diagnostics.Diagnostic{{
+			RuleID:    RuleInvalidYaml,
+			Severity:  diagnostics.SeverityError,
+			Message:   "failed to open file",
+			File:      path,
+			Document:  0,
+			RuleIndex: -1,
+		}}
+	}
+	defer f.Close()
+	return ParseK8sRbacYamlReader(logger, path, f)
+}
+
+// ParseK8sRbacYamlReader is ParseK8sRbacYaml reading from r, path only names the input in diagnostics and origins.
+// The rules of every Role and ClusterRole of the documents are merged into RbacRulesMap, RbacRoleName is the first role.
+func ParseK8sRbacYamlReader(logger *slog.Logger, path string, r io.Reader) []diagnostics.Diagnostic {
+	var diags []diagnostics.Diagnostic
+	report := func(rule_id string, severity diagnostics.Severity, document int, rule_index int, format string, a ...interface{}) {
+		diags = append(diags, diagnostics.Diagnostic{
+			RuleID:    rule_id,
+			Severity:  severity,
+			Message:   fmt.Sprintf(format, a...),
+			File:      path,
+			Document:  document,
+			RuleIndex: rule_index,
+		})
+	}
 
-	for {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		report(RuleInvalidYaml, diagnostics.SeverityError, 0, -1, "failed to read file: %s", err.Error())
+		return diags
+	}
+	RecordPositions(path, data)
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(data), 100)
+
+	RbacRulesMap = make(map[string]ApiGroupValueType)
+	RbacRulesOrigin = make(map[PermissionKey][]RuleRef)
+	RbacRoleName = ""
+	RbacRoleKind = ""
+	for document := 0; ; document++ {
 		var rawObj runtime.RawExtension
 		if err := decoder.Decode(&rawObj); err != nil {
+			if err != io.EOF {
+				report(RuleInvalidYaml, diagnostics.SeverityError, document, -1, "failed to decode yaml: %s", err.Error())
+			}
 			break
 		}
+		if len(rawObj.Raw) == 0 {
+			// empty document
+			continue
+		}
 
 		obj, gkv, err := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(rawObj.Raw, nil, nil)
+		if err != nil {
+			logger.Error("Failed to decode rbac yaml", err, "file", path, "document", document)
+			report(RuleInvalidYaml, diagnostics.SeverityError, document, -1, "failed to decode kubernetes object: %s", err.Error())
+			continue
+		}
 		unstructuredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
 		if err != nil {
-			utils.FatalLogger.Printf(err.Error())
+			logger.Error("Failed to convert rbac yaml", err, "file", path, "document", document)
+			report(RuleInvalidYaml, diagnostics.SeverityError, document, -1, "failed to convert kubernetes object: %s", err.Error())
+			continue
+		}
+		logger.Info("Processing", "kind", gkv.Kind, "file", path, "document", document)
+		if gkv.Kind != "ClusterRole" && gkv.Kind != "Role" {
+			// bindings carry no rules
+			continue
+		}
+		if metadata, ok := unstructuredMap["metadata"].(map[string]interface{}); ok && RbacRoleName == "" {
+			RbacRoleName, _ = metadata["name"].(string)
+			RbacRoleKind = gkv.Kind
 		}
-		utils.InfoLogger.Printf("Processing %s", gkv.Kind)
 
-		RbacRulesMap = make(map[string]ApiGroupValueType)
 		jsonStream, err := json.Marshal(unstructuredMap["rules"])
 		if err != nil {
 			break
@@ -172,19 +301,30 @@ func ParseK8sRbacYaml(path string) {
 		for {
 			// type to Marshall k8s rbac yaml
 			type ArvEntry struct {
-				ApiGroups []string `json:"apiGroups"`
-				Resources []string `json:"resources"`
-				Verbs     []string `json:"verbs"`
+				ApiGroups       []string `json:"apiGroups"`
+				Resources       []string `json:"resources"`
+				Verbs           []string `json:"verbs"`
+				NonResourceURLs []string `json:"nonResourceURLs"`
 			}
 			var arvEntryArray []ArvEntry
 			if err := jsonDecoder.Decode(&arvEntryArray); err != nil {
 				break
 			}
-			for _, arv := range arvEntryArray {
+			for rule_index, arv := range arvEntryArray {
 				apiGroups := arv.ApiGroups
 				resources := arv.Resources
 				verbs := arv.Verbs
 
+				if len(arv.NonResourceURLs) > 0 && len(resources) == 0 {
+					report(RuleNonResourceURL, diagnostics.SeverityInfo, document, rule_index, "nonResourceURLs %v are not verified", arv.NonResourceURLs)
+					continue
+				}
+				if len(apiGroups) == 0 || len(resources) == 0 || len(verbs) == 0 {
+					logger.Warn("Found incomplete rule, skipping", "file", path, "document", document, "rule", rule_index)
+					report(RuleIncompleteRule, diagnostics.SeverityError, document, rule_index, "rule requires apiGroups, resources and verbs")
+					continue
+				}
+
 				// star * handler at apigroup
 				var apigroup_keys []string
 				if apiGroups[0] == "*" {
@@ -201,7 +341,8 @@ func ParseK8sRbacYaml(path string) {
 
 				for _, ag := range apigroup_keys {
 					if ag_entry, ok := AllResourcesMap[ag]; !ok {
-						log.Default().Printf("Found nonexisting apigroup: %s, skipping!\n", ag)
+						logger.Warn("Found nonexisting apigroup, skipping", "apigroup", ag)
+						report(RuleUnknownApiGroup, diagnostics.SeverityError, document, rule_index, "apigroup %q does not exist", ag)
 						continue
 					} else {
 						entry, ok := RbacRulesMap[ag]
@@ -236,8 +377,12 @@ func ParseK8sRbacYaml(path string) {
 								subresource_name = split_names[1]
 							}
 
-							if re_entry, ok := ag_entry.Resource[res]; !ok {
-								utils.InfoLogger.Printf("Found nonexisting resource %s in apigroup: %s, skipping!\n", res, ag)
+							if re_entry, ok := lookupResource(ag_entry, ag, res); !ok {
+								logger.Warn("Found nonexisting resource, skipping", "apigroup", ag, "resource", res)
+								// with apiGroups "*", a resource is unknown only when none of the apigroups has it
+								if apiGroups[0] != "*" || !resourceExists(res) {
+									report(RuleUnknownResource, diagnostics.SeverityError, document, rule_index, "resource %q does not exist in apigroup %q", res, ag)
+								}
 								//continue
 							} else {
 								/*
@@ -256,17 +401,36 @@ func ParseK8sRbacYaml(path string) {
 									re_entry.Namespaced,
 									nil,
 								}
+								// the same resource can be granted by several rules, merge their verbs
+								if prev, ok := entry.Resource[res]; ok {
+									res_type.Verbs = prev.Verbs
+								}
+								var granted, unavailable []string
+								available := append(append([]string{}, re_entry.Verbs...), SpecialVerbs[ag][res]...)
 								if verbs[0] == "*" {
-									res_type.Verbs = re_entry.Verbs
+									granted = available
 								} else {
 									for _, verb := range verbs {
-										if !slices.Contains(re_entry.Verbs, verb) {
-											utils.InfoLogger.Printf("Found non-avaible verb %s for resource %s, ignoring", verb, res)
+										if !slices.Contains(available, verb) {
+											logger.Info("Found non-available verb, ignoring", "apigroup", ag, "resource", res, "verb", verb)
+											unavailable = append(unavailable, verb)
 										} else {
-											res_type.Verbs = append(res_type.Verbs, verb)
+											granted = append(granted, verb)
 										}
 									}
 								}
+								// a verb unavailable for some of the "*" resources is expected
+								if len(unavailable) > 0 && resources[0] != "*" {
+									report(RuleUnavailableVerb, diagnostics.SeverityWarning, document, rule_index, "verbs %v are not available for resource %q in apigroup %q, available verbs: %v", unavailable, res, ag, available)
+								}
+								ref := RuleRef{path, document, rule_index, apiGroups[0] == "*" || resources[0] == "*" || verbs[0] == "*"}
+								for _, verb := range granted {
+									if !slices.Contains(res_type.Verbs, verb) {
+										res_type.Verbs = append(res_type.Verbs, verb)
+									}
+									key := PermissionKey{ag, res, verb}
+									RbacRulesOrigin[key] = append(RbacRulesOrigin[key], ref)
+								}
 								entry.Resource[res] = res_type
 							}
 						}
@@ -276,7 +440,28 @@ func ParseK8sRbacYaml(path string) {
 			}
 		}
 	}
-	f.Close()
+	return diags
+}
+
+// ExpandedRole keeps the expansion of a rbac yaml file, so several files can be compared
+type ExpandedRole struct {
+	File   string
+	Name   string
+	Kind   string
+	Rules  map[string]ApiGroupValueType
+	Origin map[PermissionKey][]RuleRef
+}
+
+// ExpandRbacYaml parses the rbac yaml file with ParseK8sRbacYaml, against the current AllResourcesMap
+func ExpandRbacYaml(logger *slog.Logger, path string) (ExpandedRole, []diagnostics.Diagnostic) {
+	diags := ParseK8sRbacYaml(logger, path)
+	return ExpandedRole{path, RbacRoleName, RbacRoleKind, RbacRulesMap, RbacRulesOrigin}, diags
+}
+
+// ExpandRbacYamlReader is ExpandRbacYaml reading from r, i.e. for embedded role files
+func ExpandRbacYamlReader(logger *slog.Logger, path string, r io.Reader) (ExpandedRole, []diagnostics.Diagnostic) {
+	diags := ParseK8sRbacYamlReader(logger, path, r)
+	return ExpandedRole{path, RbacRoleName, RbacRoleKind, RbacRulesMap, RbacRulesOrigin}, diags
 }
 
 /*
@@ -290,18 +475,23 @@ Substract allowed items (recorded in 'rbac') from 'all' items, result would be "
 	verbs are checked against such resource, here we try to mimic the same behavoir.
 */
 func FilterRules() {
-	ForbiddenRulesMap = make(map[string]ApiGroupValueType)
+	ForbiddenRulesMap = SubtractRules(AllResourcesMap, RbacRulesMap)
+}
+
+// SubtractRules returns the apigroups, resources and verbs found in 'all' but not in 'rbac', following the logic of FilterRules
+func SubtractRules(all map[string]ApiGroupValueType, rbac map[string]ApiGroupValueType) map[string]ApiGroupValueType {
+	ret := make(map[string]ApiGroupValueType)
 
-	for k, v := range AllResourcesMap {
-		if rb_val, ok := RbacRulesMap[k]; !ok {
+	for k, v := range all {
+		if rb_val, ok := rbac[k]; !ok {
 			// did not find the entire apiGroup, copy over.
-			addToRetObject(ForbiddenRulesMap, k, v, "", nil, nil)
+			addToRetObject(ret, k, v, "", nil, nil)
 		} else {
 			// found the apiGroup, need to verify each Resource, compare val vs. rb_val
 			for kk, vv := range v.Resource {
 				if rb_res, ok := rb_val.Resource[kk]; !ok {
 					// Found a resource not allowed, copy the entire resource over
-					addToRetObject(ForbiddenRulesMap, k, nil, kk, vv, nil)
+					addToRetObject(ret, k, nil, kk, vv, nil)
 				} else {
 					// Found apiGroup, found resource, need to check the verbs are equal.
 					var neg_verbs []string
@@ -311,15 +501,37 @@ func FilterRules() {
 						}
 					}
 					if len(neg_verbs) > 0 {
-						addToRetObject(ForbiddenRulesMap, k, nil, kk, vv, neg_verbs)
+						addToRetObject(ret, k, nil, kk, vv, neg_verbs)
 					}
 				}
 			}
 		}
 	}
+	return ret
 }
 
 // helper functions
+
+// lookupResource finds res in the catalog, a resource of SpecialVerbs only checked by the authorizer, i.e. "users", is cluster scoped with no other verbs
+func lookupResource(ag_entry ApiGroupValueType, ag string, res string) (ResourceValueType, bool) {
+	if re_entry, ok := ag_entry.Resource[res]; ok {
+		return re_entry, true
+	}
+	if _, ok := SpecialVerbs[ag][res]; ok {
+		return ResourceValueType{}, true
+	}
+	return ResourceValueType{}, false
+}
+
+func resourceExists(res string) bool {
+	for k, ag := range AllResourcesMap {
+		if _, ok := lookupResource(ag, k, res); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func addToRetObject(ret map[string]ApiGroupValueType, k string, ag interface{}, kk string, res interface{}, verbs []string) {
 	ret_entry, ok := ret[k]
 	if !ok {
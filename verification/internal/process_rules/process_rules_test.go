@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	"golang.org/x/exp/slog"
 )
 
 var api_resource_txt = `#Test All Resources File#
@@ -445,6 +447,7 @@ var fb_json_str string = `{
 	}`
 
 var ar, rb, fb map[string]ApiGroupValueType
+var logger *slog.Logger
 
 func TestMain(m *testing.M) {
 	setup()
@@ -454,7 +457,8 @@ func TestMain(m *testing.M) {
 }
 
 func setup() {
-	if err := utils.Set_logging("./unitest_logging.log"); err != nil {
+	var err error
+	if logger, _, err = utils.NewLogger(utils.LogOptions{Level: "debug", File: "./unitest_logging.log"}); err != nil {
 		log.Panic(err)
 	}
 	if err := os.WriteFile("./test_all_api_resources.txt", []byte(api_resource_txt), 0644); err != nil {
@@ -498,7 +502,7 @@ func TestParseAllApiResources(t *testing.T) {
 
 func TestParseK8sRbacYaml(t *testing.T) {
 	ParseAllApiresources("./test_all_api_resources.txt")
-	ParseK8sRbacYaml("./test_clusterrole.yaml")
+	ParseK8sRbacYaml(logger, "./test_clusterrole.yaml")
 
 	if !reflect.DeepEqual(RbacRulesMap, rb) {
 		t.Error("Maps are not equal!")
@@ -507,11 +511,14 @@ func TestParseK8sRbacYaml(t *testing.T) {
 		t.Log("Received:\n")
 		utils.PrettyPrintJson(RbacRulesMap)
 	}
+	if RbacRoleName != "namespace-admin" {
+		t.Errorf("expected role name namespace-admin, got %s", RbacRoleName)
+	}
 }
 
 func TestFilterRules(t *testing.T) {
 	ParseAllApiresources("./test_all_api_resources.txt")
-	ParseK8sRbacYaml("./test_clusterrole.yaml")
+	ParseK8sRbacYaml(logger, "./test_clusterrole.yaml")
 
 	FilterRules()
 	if !reflect.DeepEqual(ForbiddenRulesMap, fb) {
@@ -527,7 +534,7 @@ func TestFilterRules(t *testing.T) {
 	ForbiddenRulesMap = make(map[string]ApiGroupValueType)
 	fb = make(map[string]ApiGroupValueType)
 
-	ParseK8sRbacYaml("./test_all_star_clusterrole.yaml")
+	ParseK8sRbacYaml(logger, "./test_all_star_clusterrole.yaml")
 	FilterRules()
 
 	//nothing should be forbidden, ForbiddenRulesMap is empty now.
@@ -610,3 +617,114 @@ func TestAddToRetObject(t *testing.T) {
 		}
 	}
 }
+
+func TestParseK8sRbacYamlMergesRules(t *testing.T) {
+	var multi_rules_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - configmaps
+  verbs:
+  - create
+  - delete
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-namespace-admin
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin-extra
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - configmaps
+  verbs:
+  - get
+  - delete
+`
+	path := filepath.Join(t.TempDir(), "multi.yaml")
+	if err := os.WriteFile(path, []byte(multi_rules_yaml_text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ParseAllApiresources("./test_all_api_resources.txt")
+	if diags := ParseK8sRbacYaml(logger, path); len(diags) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diags)
+	}
+
+	expect := []string{"create", "delete", "get"}
+	if verbs := RbacRulesMap[""].Resource["configmaps"].Verbs; !reflect.DeepEqual(verbs, expect) {
+		t.Errorf("expected merged verbs %v, got %v", expect, verbs)
+	}
+	if RbacRoleName != "namespace-admin" {
+		t.Errorf("expected role name namespace-admin, got %s", RbacRoleName)
+	}
+}
+
+func TestParseK8sRbacYamlRecordsPositions(t *testing.T) {
+	var positions_yaml_text = `---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: positions
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - pods
+  verbs:
+  - get
+-   apiGroups: [""]
+    resources: [pod]
+    verbs: [get]
+---
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: positions-extra
+rules:
+- apiGroups: [""]
+  resources: [configmaps]
+  verbs: [get]
+`
+	path := filepath.Join(t.TempDir(), "positions.yaml")
+	if err := os.WriteFile(path, []byte(positions_yaml_text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ParseAllApiresources("./test_all_api_resources.txt")
+	ParseK8sRbacYaml(logger, path)
+
+	tests := []struct {
+		document   int
+		rule_index int
+		expect     Position
+	}{
+		{0, -1, Position{2, 1}},
+		{0, 0, Position{7, 3}},
+		{0, 1, Position{13, 5}},
+		{0, 7, Position{2, 1}},
+		{1, 0, Position{23, 3}},
+	}
+	for _, tt := range tests {
+		p, ok := PositionOf(path, tt.document, tt.rule_index)
+		if !ok || p != tt.expect {
+			t.Errorf("expected document %d rule %d at %v, got %v", tt.document, tt.rule_index, tt.expect, p)
+		}
+	}
+	if _, ok := PositionOf(path, 2, -1); ok {
+		t.Errorf("expected no document 2")
+	}
+}
@@ -0,0 +1,325 @@
+package rbac_graph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+/*
+Build the subject -> binding -> role graph of the loaded roles and bindings, to draw as a Graphviz DOT file or a Mermaid
+flowchart:
+
+	*subject -> RoleBinding or ClusterRoleBinding: labeled with the binding scope, the namespace or "cluster-wide"
+	*binding -> Role or ClusterRole: the roleRef, a role not loaded, i.e. a builtin one, is drawn dashed
+	*ClusterRole -> ClusterRole: the ClusterRoles aggregated into it
+
+Nodes are grouped by namespace: service accounts, RoleBindings and Roles in their namespace, users, groups,
+ClusterRoleBindings and ClusterRoles at the top level. Only the roles referenced by a drawn binding are drawn.
+
+A Filter selects the bindings naming a subject and, or, the RoleBindings of a namespace. ClusterRoleBindings grant in
+every namespace, they are drawn with a namespace filter only when they name a service account of the namespace.
+*/
+type Node struct {
+	ID        string `json:"id"` // i.e. "n0", a valid DOT and Mermaid identifier
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Missing   bool   `json:"missing,omitempty"` // a roleRef to a role not loaded
+}
+
+type Edge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"`
+}
+
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+type Filter struct {
+	Subject   string // a user or group name, "<namespace>/<name>" or "system:serviceaccount:<namespace>:<name>" for a service account
+	Namespace string
+}
+
+const (
+	LabelRoleRef    = "roleRef"
+	LabelAggregates = "aggregates"
+	LabelCluster    = "cluster-wide"
+)
+
+func Build(objects *rbac_objects.Objects, filter Filter) Graph {
+	b := builder{nodes: make(map[string]Node), edges: make(map[Edge]bool)}
+	add := func(kind string, namespace string, name string, ref rbacv1.RoleRef, subjects []rbacv1.Subject) {
+		if !filter.selects(namespace, subjects) {
+			return
+		}
+		binding := b.node(kind, namespace, name, false)
+		label := LabelCluster
+		if namespace != "" {
+			label = namespace
+		}
+		for _, s := range subjects {
+			subject_namespace := ""
+			if s.Kind == rbacv1.ServiceAccountKind {
+				subject_namespace = s.Namespace
+			}
+			b.edge(b.node(s.Kind, subject_namespace, s.Name, false), binding, label)
+		}
+
+		ref_namespace := ""
+		if ref.Kind == "Role" {
+			ref_namespace = namespace
+		}
+		role_rules, found := objects.RulesOf(ref, namespace)
+		role := b.node(ref.Kind, ref_namespace, ref.Name, !found)
+		b.edge(binding, role, LabelRoleRef)
+		for _, rr := range role_rules {
+			if rr.AggregatedInto != "" {
+				b.edge(b.node("ClusterRole", "", rr.AggregatedInto, false), b.node("ClusterRole", "", rr.Name, false), LabelAggregates)
+			}
+		}
+	}
+
+	for _, crb := range objects.ClusterRoleBindings {
+		add("ClusterRoleBinding", "", crb.Name, crb.RoleRef, crb.Subjects)
+	}
+	for _, rb := range objects.RoleBindings {
+		add("RoleBinding", rb.Namespace, rb.Name, rb.RoleRef, rb.Subjects)
+	}
+	return b.graph()
+}
+
+// Namespaces lists the namespaces of the nodes, sorted, "" first for the top level
+func (g Graph) Namespaces() []string {
+	seen := map[string]bool{"": true}
+	namespaces := []string{""}
+	for _, n := range g.Nodes {
+		if !seen[n.Namespace] {
+			seen[n.Namespace] = true
+			namespaces = append(namespaces, n.Namespace)
+		}
+	}
+	sort.Strings(namespaces[1:])
+	return namespaces
+}
+
+func WriteDot(w io.Writer, g Graph) {
+	fmt.Fprintln(w, "digraph rbac {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [fontname=\"Helvetica\"];")
+	for _, ns := range g.Namespaces() {
+		indent := "  "
+		if ns != "" {
+			fmt.Fprintf(w, "  subgraph %q {\n", "cluster_"+ns)
+			fmt.Fprintf(w, "    label=%q;\n", "namespace "+ns)
+			indent = "    "
+		}
+		for _, n := range g.Nodes {
+			if n.Namespace != ns {
+				continue
+			}
+			style := ""
+			if n.Missing {
+				style = ", style=dashed"
+			}
+			fmt.Fprintf(w, "%s%s [label=\"%s\\n%s\", shape=%s%s];\n", indent, n.ID, n.Kind, escapeDot(n.Name), dotShapes[n.Kind], style)
+		}
+		if ns != "" {
+			fmt.Fprintln(w, "  }")
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "  %s -> %s [label=\"%s\"];\n", e.From, e.To, escapeDot(e.Label))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func WriteMermaid(w io.Writer, g Graph) {
+	fmt.Fprintln(w, "flowchart LR")
+	for _, ns := range g.Namespaces() {
+		indent := "  "
+		if ns != "" {
+			fmt.Fprintf(w, "  subgraph ns_%s [\"namespace %s\"]\n", mermaidId(ns), escapeMermaid(ns))
+			indent = "    "
+		}
+		for _, n := range g.Nodes {
+			if n.Namespace != ns {
+				continue
+			}
+			shape := mermaidShapes[n.Kind]
+			fmt.Fprintf(w, "%s%s%s\"%s<br/>%s\"%s\n", indent, n.ID, shape[0], n.Kind, escapeMermaid(n.Name), shape[1])
+		}
+		if ns != "" {
+			fmt.Fprintln(w, "  end")
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "  %s -->|\"%s\"| %s\n", e.From, escapeMermaid(e.Label), e.To)
+	}
+	var missing []string
+	for _, n := range g.Nodes {
+		if n.Missing {
+			missing = append(missing, n.ID)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintln(w, "  classDef missing stroke-dasharray: 5 5")
+		fmt.Fprintf(w, "  class %s missing\n", strings.Join(missing, ","))
+	}
+}
+
+// helper functions
+var dotShapes = map[string]string{
+	rbacv1.UserKind:           "ellipse",
+	rbacv1.GroupKind:          "ellipse",
+	rbacv1.ServiceAccountKind: "ellipse",
+	"RoleBinding":             "box",
+	"ClusterRoleBinding":      "box",
+	"Role":                    "component",
+	"ClusterRole":             "component",
+}
+
+// the opening and closing brackets of the Mermaid node shapes
+var mermaidShapes = map[string][2]string{
+	rbacv1.UserKind:           {"([", "])"},
+	rbacv1.GroupKind:          {"([", "])"},
+	rbacv1.ServiceAccountKind: {"([", "])"},
+	"RoleBinding":             {"[", "]"},
+	"ClusterRoleBinding":      {"[", "]"},
+	"Role":                    {"[[", "]]"},
+	"ClusterRole":             {"[[", "]]"},
+}
+
+// the order of the node kinds, subjects first
+var kindOrder = map[string]int{
+	rbacv1.UserKind:           0,
+	rbacv1.GroupKind:          1,
+	rbacv1.ServiceAccountKind: 2,
+	"ClusterRoleBinding":      3,
+	"RoleBinding":             4,
+	"ClusterRole":             5,
+	"Role":                    6,
+}
+
+type builder struct {
+	nodes map[string]Node
+	edges map[Edge]bool
+}
+
+// node adds a node, keyed like rbac_objects.KeyOf, and returns its key, a role found missing once stays missing
+func (b *builder) node(kind string, namespace string, name string, missing bool) string {
+	key := rbac_objects.KeyOf(kind, namespace, name)
+	n, ok := b.nodes[key]
+	if !ok {
+		n = Node{Kind: kind, Name: name, Namespace: namespace}
+	}
+	n.Missing = n.Missing || missing
+	b.nodes[key] = n
+	return key
+}
+
+func (b *builder) edge(from string, to string, label string) {
+	b.edges[Edge{from, to, label}] = true
+}
+
+// graph sorts the nodes by namespace, kind and name, numbers them and points the edges to their IDs
+func (b *builder) graph() Graph {
+	keys := make([]string, 0, len(b.nodes))
+	for key := range b.nodes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := b.nodes[keys[i]], b.nodes[keys[j]]
+		if a.Namespace != c.Namespace {
+			return a.Namespace < c.Namespace
+		}
+		if a.Kind != c.Kind {
+			return kindOrder[a.Kind] < kindOrder[c.Kind]
+		}
+		return a.Name < c.Name
+	})
+
+	g := Graph{Nodes: []Node{}, Edges: []Edge{}}
+	order := make(map[string]int)
+	for i, key := range keys {
+		n := b.nodes[key]
+		n.ID = fmt.Sprintf("n%d", i)
+		order[key] = i
+		g.Nodes = append(g.Nodes, n)
+	}
+	edges := make([]Edge, 0, len(b.edges))
+	for e := range b.edges {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, c := edges[i], edges[j]
+		if a.From != c.From {
+			return order[a.From] < order[c.From]
+		}
+		if a.To != c.To {
+			return order[a.To] < order[c.To]
+		}
+		return a.Label < c.Label
+	})
+	for _, e := range edges {
+		g.Edges = append(g.Edges, Edge{g.Nodes[order[e.From]].ID, g.Nodes[order[e.To]].ID, e.Label})
+	}
+	return g
+}
+
+// selects reports whether the filter selects a binding in namespace, "" for a ClusterRoleBinding, with the subjects
+func (f Filter) selects(namespace string, subjects []rbacv1.Subject) bool {
+	if f.Subject != "" {
+		named := false
+		for _, s := range subjects {
+			named = named || f.names(s)
+		}
+		if !named {
+			return false
+		}
+	}
+	if f.Namespace == "" || namespace == f.Namespace {
+		return true
+	}
+	if namespace != "" {
+		return false
+	}
+	for _, s := range subjects {
+		if s.Kind == rbacv1.ServiceAccountKind && s.Namespace == f.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) names(s rbacv1.Subject) bool {
+	if s.Kind != rbacv1.ServiceAccountKind {
+		return s.Name == f.Subject
+	}
+	return f.Subject == s.Namespace+"/"+s.Name || f.Subject == fmt.Sprintf("system:serviceaccount:%s:%s", s.Namespace, s.Name)
+}
+
+func escapeDot(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`)
+}
+
+func escapeMermaid(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
+
+// mermaidId replaces the characters not allowed in a Mermaid identifier
+func mermaidId(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,163 @@
+package rbac_graph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+)
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.example.com/aggregate-to-namespace-admin: "true"
+rules: []
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin-pods
+  labels:
+    rbac.example.com/aggregate-to-namespace-admin: "true"
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["*"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-namespace-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+- kind: ServiceAccount
+  name: deployer
+  namespace: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-namespace-viewer
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: view
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:namespace-viewer
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: deployer-nodes
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- kind: ServiceAccount
+  name: deployer
+  namespace: mldev
+`
+
+func setup(t *testing.T) *rbac_objects.Objects {
+	objects := rbac_objects.New()
+	if err := objects.Decode("objects.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+// describe lists the edges as "<kind>/<name> -label-> <kind>/<name>"
+func describe(g Graph) []string {
+	nodes := make(map[string]Node)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+	}
+	var edges []string
+	for _, e := range g.Edges {
+		from, to := nodes[e.From], nodes[e.To]
+		edges = append(edges, from.Kind+"/"+from.Name+" -"+e.Label+"-> "+to.Kind+"/"+to.Name)
+	}
+	return edges
+}
+
+func TestBuild(t *testing.T) {
+	g := Build(setup(t), Filter{})
+	edges := strings.Join(describe(g), "\n")
+	for _, expect := range []string{
+		"Group/oidc:mldev-namespace-admin -mldev-> RoleBinding/mldev-namespace-admin",
+		"ServiceAccount/deployer -cluster-wide-> ClusterRoleBinding/deployer-nodes",
+		"RoleBinding/mldev-namespace-admin -roleRef-> ClusterRole/namespace-admin",
+		"ClusterRole/namespace-admin -aggregates-> ClusterRole/namespace-admin-pods",
+		"RoleBinding/smoke-test-namespace-viewer -roleRef-> ClusterRole/view",
+	} {
+		if !strings.Contains(edges, expect) {
+			t.Errorf("expected edge %q in\n%s", expect, edges)
+		}
+	}
+	if got := g.Namespaces(); strings.Join(got, ",") != ",mldev,smoke-test" {
+		t.Errorf("unexpected namespaces %v", got)
+	}
+	for _, n := range g.Nodes {
+		if missing := n.Name == "view" || n.Name == "node-viewer"; n.Missing != missing {
+			t.Errorf("expected %s missing %v", n.Name, missing)
+		}
+		if n.Kind == "ServiceAccount" && n.Namespace != "mldev" {
+			t.Errorf("expected the service account in mldev, got %q", n.Namespace)
+		}
+	}
+}
+
+func TestBuildFilter(t *testing.T) {
+	objects := setup(t)
+	g := Build(objects, Filter{Subject: "oidc:namespace-viewer"})
+	if edges := describe(g); len(edges) != 2 || len(g.Nodes) != 3 {
+		t.Errorf("expected only the smoke-test viewer binding, got %v", edges)
+	}
+
+	// the ClusterRoleBinding names a service account of mldev
+	g = Build(objects, Filter{Namespace: "mldev"})
+	edges := strings.Join(describe(g), "\n")
+	if !strings.Contains(edges, "ClusterRoleBinding/deployer-nodes") || strings.Contains(edges, "smoke-test") {
+		t.Errorf("unexpected edges for namespace mldev\n%s", edges)
+	}
+
+	g = Build(objects, Filter{Subject: "system:serviceaccount:mldev:deployer", Namespace: "mldev"})
+	if len(describe(g)) != 6 {
+		t.Errorf("unexpected edges for the deployer in mldev %v", describe(g))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	g := Build(setup(t), Filter{Namespace: "smoke-test"})
+	var dot bytes.Buffer
+	WriteDot(&dot, g)
+	for _, expect := range []string{"subgraph \"cluster_smoke-test\" {", "label=\"namespace smoke-test\";", "style=dashed", "[label=\"smoke-test\"];"} {
+		if !strings.Contains(dot.String(), expect) {
+			t.Errorf("expected %q in\n%s", expect, dot.String())
+		}
+	}
+
+	var mermaid bytes.Buffer
+	WriteMermaid(&mermaid, g)
+	for _, expect := range []string{"flowchart LR", "subgraph ns_smoke_test [\"namespace smoke-test\"]", "[[\"ClusterRole<br/>view\"]]", "-->|\"roleRef\"|", "class n1 missing"} {
+		if !strings.Contains(mermaid.String(), expect) {
+			t.Errorf("expected %q in\n%s", expect, mermaid.String())
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package rbac_objects
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+/*
+The Role, ClusterRole, RoleBinding and ClusterRoleBinding objects, loaded from rbac yaml files or from a live cluster,
+for the analyses spanning several roles and bindings, i.e. who-can. Unlike ParseK8sRbacYaml, the rules are kept as
+written, they are matched against a request the way the kubernetes RBAC authorizer does, without the resource catalog.
+The Namespaces and ServiceAccounts the bindings refer to are loaded along, when found.
+
+Source records where each object was loaded from, keyed by KeyOf.
+*/
+type Objects struct {
+	Roles               []rbacv1.Role
+	ClusterRoles        []rbacv1.ClusterRole
+	RoleBindings        []rbacv1.RoleBinding
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding
+	Namespaces          []corev1.Namespace
+	ServiceAccounts     []corev1.ServiceAccount
+	Source              map[string]Origin
+}
+
+// Origin locates an object in a rbac yaml file, File is empty for an object of the cluster
+type Origin struct {
+	File     string `json:"file,omitempty"`
+	Document int    `json:"document"`
+}
+
+var OriginCluster = Origin{}
+
+func (o Origin) String() string {
+	if o.File == "" {
+		return "cluster"
+	}
+	return fmt.Sprintf("%s: document %d", o.File, o.Document)
+}
+
+// KeyOf identifies an object, i.e. "RoleBinding/mldev/mldev-namespace-admin" or "ClusterRole/namespace-admin"
+func KeyOf(kind string, namespace string, name string) string {
+	if namespace == "" {
+		return kind + "/" + name
+	}
+	return kind + "/" + namespace + "/" + name
+}
+
+// LoadFiles decodes the rbac objects of the yaml files, other kinds are skipped, positions are recorded for PositionOf
+func LoadFiles(files []string) (*Objects, error) {
+	objects := New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		proc_rules.RecordPositions(file, data)
+		if err := objects.Decode(file, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func New() *Objects {
+	return &Objects{Source: make(map[string]Origin)}
+}
+
+// Decode adds the rbac objects of the yaml stream r, path only names the input in Source
+func (o *Objects) Decode(path string, r io.Reader) error {
+	decoder := yamlutil.NewYAMLOrJSONDecoder(r, 100)
+	for document := 0; ; document++ {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode %s: document %d: %w", path, document, err)
+		}
+		raw.Raw = bytes.TrimSpace(raw.Raw)
+		if len(raw.Raw) == 0 || string(raw.Raw) == "null" {
+			// empty document
+			continue
+		}
+		var meta metav1.TypeMeta
+		if err := json.Unmarshal(raw.Raw, &meta); err != nil {
+			return fmt.Errorf("failed to decode %s: document %d: %w", path, document, err)
+		}
+
+		source := Origin{path, document}
+		var err error
+		switch meta.Kind {
+		case "Role":
+			var role rbacv1.Role
+			if err = json.Unmarshal(raw.Raw, &role); err == nil {
+				o.AddRole(role, source)
+			}
+		case "ClusterRole":
+			var role rbacv1.ClusterRole
+			if err = json.Unmarshal(raw.Raw, &role); err == nil {
+				o.AddClusterRole(role, source)
+			}
+		case "RoleBinding":
+			var binding rbacv1.RoleBinding
+			if err = json.Unmarshal(raw.Raw, &binding); err == nil {
+				o.AddRoleBinding(binding, source)
+			}
+		case "ClusterRoleBinding":
+			var binding rbacv1.ClusterRoleBinding
+			if err = json.Unmarshal(raw.Raw, &binding); err == nil {
+				o.AddClusterRoleBinding(binding, source)
+			}
+		case "Namespace":
+			var namespace corev1.Namespace
+			if err = json.Unmarshal(raw.Raw, &namespace); err == nil {
+				o.AddNamespace(namespace, source)
+			}
+		case "ServiceAccount":
+			var account corev1.ServiceAccount
+			if err = json.Unmarshal(raw.Raw, &account); err == nil {
+				o.AddServiceAccount(account, source)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode %s %s: document %d: %w", meta.Kind, path, document, err)
+		}
+	}
+}
+
+// LoadCluster lists the rbac objects of the cluster of the kubeconfig
+func LoadCluster(ctx context.Context, kubeconfig string) (*Objects, error) {
+	clientset, err := NewClientset(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return LoadClientset(ctx, clientset)
+}
+
+// NewClientset creates a clientset for the current context of the kubeconfig
+func NewClientset(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+	return clientset, nil
+}
+
+func LoadClientset(ctx context.Context, clientset kubernetes.Interface) (*Objects, error) {
+	objects := New()
+	rbac := clientset.RbacV1()
+	roles, err := rbac.Roles("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	for _, role := range roles.Items {
+		objects.AddRole(role, OriginCluster)
+	}
+	cluster_roles, err := rbac.ClusterRoles().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusterroles: %w", err)
+	}
+	for _, role := range cluster_roles.Items {
+		objects.AddClusterRole(role, OriginCluster)
+	}
+	role_bindings, err := rbac.RoleBindings("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rolebindings: %w", err)
+	}
+	for _, binding := range role_bindings.Items {
+		objects.AddRoleBinding(binding, OriginCluster)
+	}
+	cluster_role_bindings, err := rbac.ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusterrolebindings: %w", err)
+	}
+	for _, binding := range cluster_role_bindings.Items {
+		objects.AddClusterRoleBinding(binding, OriginCluster)
+	}
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, namespace := range namespaces.Items {
+		objects.AddNamespace(namespace, OriginCluster)
+	}
+	accounts, err := clientset.CoreV1().ServiceAccounts("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list serviceaccounts: %w", err)
+	}
+	for _, account := range accounts.Items {
+		objects.AddServiceAccount(account, OriginCluster)
+	}
+	return objects, nil
+}
+
+// Merge adds the objects of other not found in o, i.e. the live objects missing from the files
+func (o *Objects) Merge(other *Objects) {
+	for _, role := range other.Roles {
+		if _, ok := o.Source[KeyOf("Role", role.Namespace, role.Name)]; !ok {
+			o.AddRole(role, other.Source[KeyOf("Role", role.Namespace, role.Name)])
+		}
+	}
+	for _, role := range other.ClusterRoles {
+		if _, ok := o.Source[KeyOf("ClusterRole", "", role.Name)]; !ok {
+			o.AddClusterRole(role, other.Source[KeyOf("ClusterRole", "", role.Name)])
+		}
+	}
+	for _, binding := range other.RoleBindings {
+		if _, ok := o.Source[KeyOf("RoleBinding", binding.Namespace, binding.Name)]; !ok {
+			o.AddRoleBinding(binding, other.Source[KeyOf("RoleBinding", binding.Namespace, binding.Name)])
+		}
+	}
+	for _, binding := range other.ClusterRoleBindings {
+		if _, ok := o.Source[KeyOf("ClusterRoleBinding", "", binding.Name)]; !ok {
+			o.AddClusterRoleBinding(binding, other.Source[KeyOf("ClusterRoleBinding", "", binding.Name)])
+		}
+	}
+	for _, namespace := range other.Namespaces {
+		if _, ok := o.Source[KeyOf("Namespace", "", namespace.Name)]; !ok {
+			o.AddNamespace(namespace, other.Source[KeyOf("Namespace", "", namespace.Name)])
+		}
+	}
+	for _, account := range other.ServiceAccounts {
+		if _, ok := o.Source[KeyOf("ServiceAccount", account.Namespace, account.Name)]; !ok {
+			o.AddServiceAccount(account, other.Source[KeyOf("ServiceAccount", account.Namespace, account.Name)])
+		}
+	}
+}
+
+func (o *Objects) AddRole(role rbacv1.Role, source Origin) {
+	o.Roles = append(o.Roles, role)
+	o.Source[KeyOf("Role", role.Namespace, role.Name)] = source
+}
+
+func (o *Objects) AddClusterRole(role rbacv1.ClusterRole, source Origin) {
+	o.ClusterRoles = append(o.ClusterRoles, role)
+	o.Source[KeyOf("ClusterRole", "", role.Name)] = source
+}
+
+func (o *Objects) AddRoleBinding(binding rbacv1.RoleBinding, source Origin) {
+	o.RoleBindings = append(o.RoleBindings, binding)
+	o.Source[KeyOf("RoleBinding", binding.Namespace, binding.Name)] = source
+}
+
+func (o *Objects) AddClusterRoleBinding(binding rbacv1.ClusterRoleBinding, source Origin) {
+	o.ClusterRoleBindings = append(o.ClusterRoleBindings, binding)
+	o.Source[KeyOf("ClusterRoleBinding", "", binding.Name)] = source
+}
+
+func (o *Objects) AddNamespace(namespace corev1.Namespace, source Origin) {
+	o.Namespaces = append(o.Namespaces, namespace)
+	o.Source[KeyOf("Namespace", "", namespace.Name)] = source
+}
+
+func (o *Objects) AddServiceAccount(account corev1.ServiceAccount, source Origin) {
+	o.ServiceAccounts = append(o.ServiceAccounts, account)
+	o.Source[KeyOf("ServiceAccount", account.Namespace, account.Name)] = source
+}
+
+func (o *Objects) Role(namespace string, name string) (rbacv1.Role, bool) {
+	for _, role := range o.Roles {
+		if role.Namespace == namespace && role.Name == name {
+			return role, true
+		}
+	}
+	return rbacv1.Role{}, false
+}
+
+func (o *Objects) ClusterRole(name string) (rbacv1.ClusterRole, bool) {
+	for _, role := range o.ClusterRoles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+	return rbacv1.ClusterRole{}, false
+}
+
+// RoleRules are rules of a role, AggregatedInto names the ClusterRole they are aggregated into, if any
+type RoleRules struct {
+	Kind           string
+	Name           string
+	AggregatedInto string
+	Rules          []rbacv1.PolicyRule
+}
+
+/*
+RulesOf resolves the roleRef of a binding in namespace ("" for a ClusterRoleBinding). A ClusterRole with an
+aggregationRule holds the rules of the ClusterRoles its selectors match, recursively, like the aggregation controller
+fills them in a cluster. When none of the loaded ClusterRoles match, i.e. a partial set of files, its own rules are
+kept. Returns false when the role is missing.
+*/
+func (o *Objects) RulesOf(ref rbacv1.RoleRef, namespace string) ([]RoleRules, bool) {
+	switch ref.Kind {
+	case "Role":
+		role, ok := o.Role(namespace, ref.Name)
+		if !ok {
+			return nil, false
+		}
+		return []RoleRules{{Kind: "Role", Name: role.Name, Rules: role.Rules}}, true
+	case "ClusterRole":
+		role, ok := o.ClusterRole(ref.Name)
+		if !ok {
+			return nil, false
+		}
+		return o.clusterRoleRules(role, "", map[string]bool{}), true
+	}
+	return nil, false
+}
+
+// RuleAllows matches a rule against a resource request like the kubernetes RBAC authorizer: "*" matches any verb,
+// apigroup or resource, "*/scale" the scale subresource of any resource. A rule with resourceNames only grants the
+// named objects, it never matches a request without a name, i.e. list.
+func RuleAllows(rule rbacv1.PolicyRule, verb string, group string, resource string, subresource string) bool {
+	if len(rule.ResourceNames) > 0 {
+		return false
+	}
+	combined := resource
+	if subresource != "" {
+		combined = resource + "/" + subresource
+	}
+	return matches(rule.Verbs, verb) && matches(rule.APIGroups, group) &&
+		(matches(rule.Resources, combined) || (subresource != "" && slices.Contains(rule.Resources, "*/"+subresource)))
+}
+
+// helper functions
+func (o *Objects) clusterRoleRules(role rbacv1.ClusterRole, aggregated_into string, visited map[string]bool) []RoleRules {
+	visited[role.Name] = true
+	own := []RoleRules{{Kind: "ClusterRole", Name: role.Name, AggregatedInto: aggregated_into, Rules: role.Rules}}
+	if role.AggregationRule == nil {
+		return own
+	}
+
+	var rules []RoleRules
+	for _, selector := range role.AggregationRule.ClusterRoleSelectors {
+		s, err := metav1.LabelSelectorAsSelector(&selector)
+		if err != nil {
+			continue
+		}
+		for _, cr := range o.ClusterRoles {
+			if visited[cr.Name] || !s.Matches(labels.Set(cr.Labels)) {
+				continue
+			}
+			rules = append(rules, o.clusterRoleRules(cr, role.Name, visited)...)
+		}
+	}
+	if len(rules) == 0 {
+		return own
+	}
+	return rules
+}
+
+func matches(values []string, value string) bool {
+	return slices.Contains(values, "*") || slices.Contains(values, value)
+}
@@ -0,0 +1,118 @@
+package rbac_objects
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: admin
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.authorization.k8s.io/aggregate-to-admin: "true"
+rules: []
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+  labels:
+    rbac.authorization.k8s.io/aggregate-to-admin: "true"
+rules:
+- apiGroups: [""]
+  resources: ["pods/exec"]
+  verbs: ["create"]
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+`
+
+func TestDecode(t *testing.T) {
+	objects := New()
+	if err := objects.Decode("objects.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects.ClusterRoles) != 2 || len(objects.RoleBindings) != 1 || len(objects.Roles) != 0 {
+		t.Fatalf("unexpected objects %+v", objects)
+	}
+	if source := objects.Source["RoleBinding/mldev/mldev-admin"]; source.String() != "objects.yaml: document 3" {
+		t.Errorf("unexpected source %v", source)
+	}
+
+	rules, ok := objects.RulesOf(objects.RoleBindings[0].RoleRef, "mldev")
+	if !ok || len(rules) != 1 || rules[0].Name != "namespace-admin" || rules[0].AggregatedInto != "admin" {
+		t.Errorf("expected the rules aggregated from namespace-admin, got %+v", rules)
+	}
+	if _, ok := objects.RulesOf(rbacv1.RoleRef{Kind: "Role", Name: "admin"}, "mldev"); ok {
+		t.Errorf("expected a missing Role")
+	}
+
+	if err := New().Decode("invalid.yaml", strings.NewReader("kind: RoleBinding\nsubjects: 3\n")); err == nil {
+		t.Errorf("expected a decoding error")
+	}
+}
+
+func TestRuleAllows(t *testing.T) {
+	tests := []struct {
+		rule        rbacv1.PolicyRule
+		verb        string
+		group       string
+		resource    string
+		subresource string
+		expect      bool
+	}{
+		{rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}, "get", "", "pods", "", true},
+		{rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}, "get", "", "pods", "exec", false},
+		{rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}, "create", "apps", "deployments", "scale", true},
+		{rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"*/scale"}, Verbs: []string{"update"}}, "update", "apps", "deployments", "scale", true},
+		{rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}}, "get", "", "deployments", "", false},
+		{rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"kubeadm-config"}, Verbs: []string{"get"}}, "get", "", "configmaps", "", false},
+	}
+	for i, tt := range tests {
+		if got := RuleAllows(tt.rule, tt.verb, tt.group, tt.resource, tt.subresource); got != tt.expect {
+			t.Errorf("test %d: expected %v, got %v", i, tt.expect, got)
+		}
+	}
+}
+
+func TestLoadClientset(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "node-viewer"}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "viewer", Namespace: "mldev"}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "node-viewer"}},
+	)
+	objects, err := LoadClientset(context.Background(), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects.ClusterRoles) != 1 || len(objects.Roles) != 1 || len(objects.ClusterRoleBindings) != 1 {
+		t.Fatalf("unexpected objects %+v", objects)
+	}
+	if source := objects.Source["Role/mldev/viewer"]; source.String() != "cluster" {
+		t.Errorf("unexpected source %v", source)
+	}
+}
@@ -0,0 +1,95 @@
+package rbac_rules_verification
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/expectations"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/who_can"
+	"golang.org/x/exp/slog"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// Authorizer answers the access reviews of the current user, subject nil, or of a subject
+type Authorizer interface {
+	Review(attributes *authorizationv1.ResourceAttributes, subject *expectations.Subject) (authorizationv1.SubjectAccessReviewStatus, error)
+}
+
+type clusterAuthorizer struct {
+	auth_client authorizationv1client.AuthorizationV1Interface
+}
+
+// NewClusterAuthorizer reviews with the apiserver of the kubeconfig, a SelfSubjectAccessReview or a SubjectAccessReview
+func NewClusterAuthorizer(logger *slog.Logger, path string) (Authorizer, error) {
+	auth_client, err := getClientset(logger, path)
+	if err != nil {
+		return nil, err
+	}
+	return clusterAuthorizer{auth_client}, nil
+}
+
+func (a clusterAuthorizer) Review(attributes *authorizationv1.ResourceAttributes, subject *expectations.Subject) (authorizationv1.SubjectAccessReviewStatus, error) {
+	if subject == nil {
+		response, err := a.auth_client.SelfSubjectAccessReviews().Create(context.TODO(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attributes},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return authorizationv1.SubjectAccessReviewStatus{}, err
+		}
+		return response.Status, nil
+	}
+	response, err := a.auth_client.SubjectAccessReviews().Create(context.TODO(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: attributes, User: subject.User, Groups: subject.Groups},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, err
+	}
+	return response.Status, nil
+}
+
+type offlineAuthorizer struct {
+	objects  *rbac_objects.Objects
+	identity effective.Identity
+}
+
+/*
+NewOfflineAuthorizer reviews against the roles and bindings of objects, i.e. of a snapshot, the way the RBAC authorizer
+does, the current user being identity. Unlike the apiserver, other authorizers and resourceNames rules are ignored.
+The "resource/subresource" names of the catalog sweep are split.
+*/
+func NewOfflineAuthorizer(objects *rbac_objects.Objects, identity effective.Identity) Authorizer {
+	return offlineAuthorizer{objects, identity}
+}
+
+func (a offlineAuthorizer) Review(attributes *authorizationv1.ResourceAttributes, subject *expectations.Subject) (authorizationv1.SubjectAccessReviewStatus, error) {
+	identity := a.identity
+	if subject != nil {
+		identity = effective.Identity{User: subject.User, Groups: subject.Groups}
+	}
+	resource, subresource := attributes.Resource, attributes.Subresource
+	if index := strings.Index(resource, "/"); index > 0 {
+		resource, subresource = resource[:index], resource[index+1:]
+	}
+	grants := who_can.Query(a.objects, who_can.Request{
+		Verb:        attributes.Verb,
+		Group:       attributes.Group,
+		Resource:    resource,
+		Subresource: subresource,
+		Namespace:   attributes.Namespace,
+	})
+	for _, g := range grants {
+		if identity.Matches([]rbacv1.Subject{{Kind: g.Subject.Kind, Name: g.Subject.Name, Namespace: g.Subject.Namespace}}) {
+			return authorizationv1.SubjectAccessReviewStatus{
+				Allowed: true,
+				Reason:  fmt.Sprintf("RBAC: allowed by %s of %s to %s %q, offline", g.Binding, g.RoleRef, g.Subject.Kind, g.Subject.Name),
+			}, nil
+		}
+	}
+	return authorizationv1.SubjectAccessReviewStatus{}, nil
+}
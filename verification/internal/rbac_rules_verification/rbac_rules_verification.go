@@ -1,44 +1,48 @@
 package rbac_rules_verification
 
 import (
-	"context"
 	"fmt"
+	"time"
 
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/baseline"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/expectations"
 	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
-	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	"golang.org/x/exp/slog"
 	authorizationv1 "k8s.io/api/authorization/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func getClientset(path string) authorizationv1client.AuthorizationV1Interface {
+func getClientset(logger *slog.Logger, path string) (authorizationv1client.AuthorizationV1Interface, error) {
 	var kubeconfig *string = &path
 
 	// use the current context in kubeconfig
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
-		utils.FatalLogger.Printf("Failed to build kubeconfig, error:\n%s", err.Error())
-		panic(err.Error())
+		logger.Error("Failed to build kubeconfig", err, "kubeconfig", path)
+		return nil, err
 	}
 
 	// create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		utils.FatalLogger.Printf("Failed to create clientset, error:\n%s", err.Error())
-		panic(err.Error())
+		logger.Error("Failed to create clientset", err, "kubeconfig", path)
+		return nil, err
 	}
 
 	auth_client := clientset.AuthorizationV1()
-	return auth_client
+	return auth_client, nil
 }
 
-func processResourcesFiles(all_res_path string, rb_rule_path string) (map[string]proc_rules.ApiGroupValueType, map[string]proc_rules.ApiGroupValueType) {
+func processResourcesFiles(logger *slog.Logger, all_res_path string, rb_rule_path string) (map[string]proc_rules.ApiGroupValueType, map[string]proc_rules.ApiGroupValueType) {
 
-	proc_rules.ParseAllApiresources(all_res_path)
-	proc_rules.ParseK8sRbacYaml(rb_rule_path)
+	if all_res_path != "" {
+		// otherwise, the catalog is already parsed, i.e. from a snapshot
+		proc_rules.ParseAllApiresources(all_res_path)
+	}
+	proc_rules.ParseK8sRbacYaml(logger, rb_rule_path)
 	proc_rules.FilterRules()
 	rb_rules := proc_rules.RbacRulesMap
 	fb_rules := proc_rules.ForbiddenRulesMap
@@ -46,8 +50,8 @@ func processResourcesFiles(all_res_path string, rb_rule_path string) (map[string
 	return rb_rules, fb_rules
 }
 
-func CreateSubjectAccessReviewList(all_res_path string, rb_rule_path string, ns string) ([]*authorizationv1.SelfSubjectAccessReview, []*authorizationv1.SelfSubjectAccessReview) {
-	rb, fb := processResourcesFiles(all_res_path, rb_rule_path)
+func CreateSubjectAccessReviewList(logger *slog.Logger, all_res_path string, rb_rule_path string, ns string) ([]*authorizationv1.SelfSubjectAccessReview, []*authorizationv1.SelfSubjectAccessReview) {
+	rb, fb := processResourcesFiles(logger, all_res_path, rb_rule_path)
 	var sar_allowed, sar_forbidden []*authorizationv1.SelfSubjectAccessReview
 	for k, v := range rb {
 		for kk, vv := range v.Resource {
@@ -100,52 +104,145 @@ func CreateSubjectAccessReviewList(all_res_path string, rb_rule_path string, ns
 	return sar_allowed, sar_forbidden
 }
 
-func doSelfSubjectAccessReview(auth_client authorizationv1client.AuthorizationV1Interface, sar *authorizationv1.SelfSubjectAccessReview, expect bool) (bool, error) {
+func doSelfSubjectAccessReview(logger *slog.Logger, authorizer Authorizer, sar *authorizationv1.SelfSubjectAccessReview, expect bool, waivers *baseline.Baseline) (ReviewResult, error) {
 
-	response, err := auth_client.SelfSubjectAccessReviews().Create(context.TODO(), sar, metav1.CreateOptions{})
+	attributes := sar.Spec.ResourceAttributes
+	status, err := authorizer.Review(attributes, nil)
 	if err != nil {
-		utils.FatalLogger.Printf("Failed to create SelfSubjectAccessReviews, error:\n%s", err.Error())
-		return false, err
+		logger.Error("Failed to create SelfSubjectAccessReviews", err,
+			"apigroup", attributes.Group, "resource", attributes.Resource, "namespace", attributes.Namespace, "verb", attributes.Verb)
+		return ReviewResult{}, err
 	}
 
-	name := response.Spec.ResourceAttributes.Name
-	apigroup := response.Spec.ResourceAttributes.Group
-	namespace := response.Spec.ResourceAttributes.Namespace
-	resource := response.Spec.ResourceAttributes.Resource
-	verb := response.Spec.ResourceAttributes.Verb
+	result := ReviewResult{
+		Group:           attributes.Group,
+		Resource:        attributes.Resource,
+		Subresource:     attributes.Subresource,
+		Name:            attributes.Name,
+		Namespace:       attributes.Namespace,
+		Verb:            attributes.Verb,
+		Expected:        expect,
+		Allowed:         status.Allowed,
+		Reason:          status.Reason,
+		EvaluationError: status.EvaluationError,
+	}
+	evaluateReview(logger, &result, waivers)
+	return result, nil
+}
 
-	utils.InfoLogger.Printf("Reviewing access for {apigroup: %s, resource: %s, name :%s, namespace: %s, verb: %s} expecting: %t\n", apigroup, resource, name, namespace, verb, expect)
-	if response.Status.Allowed {
+// evaluateReview compares the verdict with the expected one, sets the outcome, prints and logs the review
+func evaluateReview(logger *slog.Logger, result *ReviewResult, waivers *baseline.Baseline) {
+	expect, verdict := result.Expected, result.Allowed
+	if verdict {
 		fmt.Println("yes")
-		utils.InfoLogger.Printf("Verdict: yes")
 	} else {
 		fmt.Println("no")
-		utils.InfoLogger.Printf("Verdict: no")
-		if len(response.Status.Reason) > 0 {
-			utils.InfoLogger.Printf(" - %v", response.Status.Reason)
-		}
-		if len(response.Status.EvaluationError) > 0 {
-			utils.InfoLogger.Printf(" - %v", response.Status.EvaluationError)
-		}
 		fmt.Println()
 	}
-	verdict := response.Status.Allowed
-	if expect == response.Status.Allowed {
+
+	result.Outcome = OutcomePassed
+	level := slog.LevelInfo
+	now := time.Now()
+	if expect == verdict {
 		fmt.Printf("---Review Passed, expecting %t, received %t\n", expect, verdict)
-		utils.InfoLogger.Printf("Review Passed, expecting %t, received %t\n", expect, verdict)
+	} else if w := waivers.Match(result.Group, result.Resource, result.Verb, result.Namespace, now); w != nil && !w.Expired(now) {
+		result.Outcome = OutcomeWaived
+		result.Waiver = w.Justification
+		fmt.Printf("~~~Review Waived, expecting %t, received %t, owner: %s, justification: %s\n", expect, verdict, w.Owner, w.Justification)
 	} else {
-		fmt.Printf("+++Review Failed, expecting %t, received %t\n", expect, verdict)
-		utils.ErrorLogger.Printf("Review Failed, expecting %t, received %t\n", expect, verdict)
+		result.Outcome = OutcomeFailed
+		level = slog.LevelError
+		if w != nil {
+			result.Waiver = w.Justification
+			result.WaiverExpired = true
+			fmt.Printf("+++Review Failed, expecting %t, received %t, waiver by %s expired on %s\n", expect, verdict, w.Owner, w.Expires)
+		} else {
+			fmt.Printf("+++Review Failed, expecting %t, received %t\n", expect, verdict)
+		}
+	}
+	if result.Description != "" {
+		fmt.Printf("   %s\n", result.Description)
 	}
-	return verdict, nil
+	logger.Log(level, "Review",
+		"persona", result.Persona,
+		"apigroup", result.Group,
+		"resource", result.Resource,
+		"subresource", result.Subresource,
+		"name", result.Name,
+		"namespace", result.Namespace,
+		"verb", result.Verb,
+		"expected", expect,
+		"verdict", verdict,
+		"outcome", result.Outcome,
+		"reason", result.Reason,
+		"evaluationError", result.EvaluationError,
+		"waiver", result.Waiver,
+		"waiverExpired", result.WaiverExpired,
+		"description", result.Description,
+	)
 }
 
-func DoBatchSelfSubjectAccessReviews(path string, l []*authorizationv1.SelfSubjectAccessReview, expect bool) error {
-	auth_client := getClientset(path)
+/*
+DoBatchSelfSubjectAccessReviews reviews each of the SelfSubjectAccessReviews in l, failed reviews covered by an
+unexpired waiver of the baseline (nil for none) are reported as waived. A review failing with an api error is skipped,
+its error returned, and the batch goes on.
+*/
+func DoBatchSelfSubjectAccessReviews(logger *slog.Logger, authorizer Authorizer, l []*authorizationv1.SelfSubjectAccessReview, expect bool, waivers *baseline.Baseline) ([]ReviewResult, []error) {
+	var results []ReviewResult
+	var errs []error
 	for _, sar := range l {
-		if _, err := doSelfSubjectAccessReview(auth_client, sar, expect); err != nil {
-			return err
+		result, err := doSelfSubjectAccessReview(logger, authorizer, sar, expect, waivers)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, errs
+}
+
+/*
+DoExpectationReviews reviews the resolved expectation assertions, with a SelfSubjectAccessReview for those without
+subject, with a SubjectAccessReview otherwise. Unlike the catalog sweep, the subresource is not folded into the resource.
+Like DoBatchSelfSubjectAccessReviews, the reviews failing with an api error are skipped and their errors returned.
+*/
+func DoExpectationReviews(logger *slog.Logger, authorizer Authorizer, assertions []expectations.Assertion, waivers *baseline.Baseline) ([]ReviewResult, []error) {
+	var results []ReviewResult
+	var errs []error
+	for _, a := range assertions {
+		attributes := &authorizationv1.ResourceAttributes{
+			Namespace:   a.Namespace,
+			Verb:        a.Verb,
+			Group:       a.Group,
+			Resource:    a.Resource,
+			Subresource: a.Subresource,
+			Name:        a.Name,
+		}
+
+		status, err := authorizer.Review(attributes, a.Subject)
+		if err != nil {
+			logger.Error("Failed to create access review", err,
+				"persona", a.Persona, "apigroup", a.Group, "resource", a.Resource, "namespace", a.Namespace, "verb", a.Verb)
+			errs = append(errs, err)
+			continue
+		}
+
+		result := ReviewResult{
+			Persona:         a.Persona,
+			Description:     a.Description,
+			Group:           a.Group,
+			Resource:        a.Resource,
+			Subresource:     a.Subresource,
+			Name:            a.Name,
+			Namespace:       a.Namespace,
+			Verb:            a.Verb,
+			Expected:        a.Expected,
+			Allowed:         status.Allowed,
+			Reason:          status.Reason,
+			EvaluationError: status.EvaluationError,
 		}
+		evaluateReview(logger, &result, waivers)
+		results = append(results, result)
 	}
-	return nil
+	return results, errs
 }
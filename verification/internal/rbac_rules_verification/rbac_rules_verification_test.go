@@ -9,10 +9,22 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/effective"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/expectations"
 	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
 	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	"golang.org/x/exp/slog"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var api_resource_txt = `#Test All Resources File#
@@ -67,6 +79,7 @@ rules:
   - list
 `
 var rb, fb map[string]proc_rules.ApiGroupValueType
+var logger *slog.Logger
 
 func TestMain(m *testing.M) {
 	setup()
@@ -76,23 +89,25 @@ func TestMain(m *testing.M) {
 }
 
 func setup() {
-	if err := utils.Set_logging("./unitest_logging.log"); err != nil {
+	var err error
+	if logger, _, err = utils.NewLogger(utils.LogOptions{Level: "debug", File: "./unitest_logging.log"}); err != nil {
 		log.Panic(err)
 	}
 	dev_config_yaml_str, err := base64.StdEncoding.DecodeString(os.ExpandEnv("$KUBECONFIG"))
 	if err != nil {
-		utils.FatalLogger.Panicf("Failed to base64 decode kubeconfig string: %s", err.Error())
+		logger.Error("Failed to base64 decode kubeconfig string", err)
+		log.Panic(err)
 	}
 	if err := os.WriteFile("./test_dev_config.yaml", []byte(dev_config_yaml_str), 0644); err != nil {
-		utils.FatalLogger.Printf("Failed to create kubeconfig file, error:\n%s", err.Error())
+		logger.Error("Failed to create kubeconfig file", err)
 		log.Panic(err)
 	}
 	if err := os.WriteFile("./test_all_api_resources.txt", []byte(api_resource_txt), 0644); err != nil {
-		utils.FatalLogger.Printf("Failed to create allresource file, error:\n%s", err.Error())
+		logger.Error("Failed to create allresource file", err)
 		log.Panic(err)
 	}
 	if err := os.WriteFile("./test_clusterrole.yaml", []byte(rbac_yaml_text), 0644); err != nil {
-		utils.FatalLogger.Printf("Failed to create rbac yaml file, error:\n%s", err.Error())
+		logger.Error("Failed to create rbac yaml file", err)
 		log.Panic(err)
 	}
 
@@ -105,13 +120,16 @@ func shutdown() {
 	fmt.Printf("All Done.")
 }
 func TestGetClientset(t *testing.T) {
-	if ret := getClientset("./test_dev_config.yaml"); ret == nil {
-		t.Error("Failed to create clientset.")
+	if ret, err := getClientset(logger, "./test_dev_config.yaml"); err != nil || ret == nil {
+		t.Errorf("Failed to create clientset: %v", err)
+	}
+	if _, err := getClientset(logger, "./missing_config.yaml"); err == nil {
+		t.Error("Expected an error for a missing kubeconfig.")
 	}
 }
 
 func TestCreateSubjectAccessReviewList(t *testing.T) {
-	sar_allowed, sar_forbidden := CreateSubjectAccessReviewList("./test_all_api_resources.txt", "./test_clusterrole.yaml", "smoke-test")
+	sar_allowed, sar_forbidden := CreateSubjectAccessReviewList(logger, "./test_all_api_resources.txt", "./test_clusterrole.yaml", "smoke-test")
 	if len(sar_allowed) == 0 || len(sar_forbidden) == 0 {
 		t.Errorf("Getting wrong length,  sar_allowed: %d, sar_forbidden %d", len(sar_allowed), len(sar_forbidden))
 	}
@@ -120,11 +138,145 @@ func TestCreateSubjectAccessReviewList(t *testing.T) {
 
 // This is a real functional test not a unit test, no PASS/FAIL criteria yet
 func TestDoBatchSelfSubjectAccessReviews(t *testing.T) {
-	sar_allowed, sar_forbidden := CreateSubjectAccessReviewList("./test_all_api_resources.txt", "./test_clusterrole.yaml", "smoke-test")
-	if err := DoBatchSelfSubjectAccessReviews("./test_dev_config.yaml", sar_allowed, true); err != nil {
-		t.Errorf("Test Error %s", err.Error())
+	sar_allowed, sar_forbidden := CreateSubjectAccessReviewList(logger, "./test_all_api_resources.txt", "./test_clusterrole.yaml", "smoke-test")
+	authorizer, err := NewClusterAuthorizer(logger, "./test_dev_config.yaml")
+	if err != nil {
+		t.Fatalf("Test Error %s", err.Error())
+	}
+	if _, errs := DoBatchSelfSubjectAccessReviews(logger, authorizer, sar_allowed, true, nil); len(errs) > 0 {
+		t.Errorf("Test Error %v", errs)
+	}
+	if _, errs := DoBatchSelfSubjectAccessReviews(logger, authorizer, sar_forbidden, false, nil); len(errs) > 0 {
+		t.Errorf("Test Error %v", errs)
+	}
+}
+
+func TestOfflineAuthorizer(t *testing.T) {
+	objects := rbac_objects.New()
+	objects.AddClusterRole(rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "pod-exec"}, Rules: []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods", "pods/exec"}, Verbs: []string{"get", "create"}},
+	}}, rbac_objects.OriginCluster)
+	objects.AddRoleBinding(rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "exec", Namespace: "smoke-test"},
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "pod-exec"},
+		Subjects: []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "oidc:smoke-test-namespace-admin"}}}, rbac_objects.OriginCluster)
+	authorizer := NewOfflineAuthorizer(objects, effective.Identity{User: "alice", Groups: []string{"oidc:smoke-test-namespace-admin"}})
+
+	// the catalog sweep names the subresource in the resource
+	exec := &authorizationv1.ResourceAttributes{Namespace: "smoke-test", Verb: "create", Resource: "pods/exec", Subresource: "exec", Name: "exec"}
+	if status, _ := authorizer.Review(exec, nil); !status.Allowed || !strings.Contains(status.Reason, "RoleBinding/smoke-test/exec") {
+		t.Errorf("expected pods/exec allowed, got %+v", status)
+	}
+	if status, _ := authorizer.Review(exec, &expectations.Subject{User: "bob"}); status.Allowed {
+		t.Errorf("expected pods/exec forbidden to bob")
+	}
+	other := &authorizationv1.ResourceAttributes{Namespace: "mldev", Verb: "get", Resource: "pods"}
+	if status, _ := authorizer.Review(other, nil); status.Allowed {
+		t.Errorf("expected pods forbidden in mldev")
+	}
+}
+
+func TestSaveAndLoadRunResult(t *testing.T) {
+	run_result := RunResult{
+		RbacYaml:   "./test_clusterrole.yaml",
+		Role:       "namespace-admin",
+		Namespaces: []string{"smoke-test"},
+		StartTime:  time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Results: []ReviewResult{
+			{Resource: "pods", Namespace: "smoke-test", Verb: "get", Expected: true, Allowed: true, Reason: "allowed by RoleBinding", Outcome: OutcomePassed},
+			{Resource: "secrets", Namespace: "smoke-test", Verb: "delete", Expected: false, Allowed: true, Outcome: OutcomeFailed, Waiver: "w1"},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := SaveRunResult(path, run_result); err != nil {
+		t.Fatal(err)
+	}
+	run, err := LoadRunResult(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(run, run_result) {
+		t.Errorf("run result does not round trip, got %+v", run)
+	}
+}
+
+// failingAuthorizer allows every review but those of the failing resource, which fail with an api error
+type failingAuthorizer struct {
+	failing string
+}
+
+func (a failingAuthorizer) Review(attributes *authorizationv1.ResourceAttributes, subject *expectations.Subject) (authorizationv1.SubjectAccessReviewStatus, error) {
+	if attributes.Resource == a.failing {
+		return authorizationv1.SubjectAccessReviewStatus{}, fmt.Errorf("the server is currently unable to handle the request")
+	}
+	return authorizationv1.SubjectAccessReviewStatus{Allowed: true}, nil
+}
+
+func TestDoBatchSelfSubjectAccessReviewsErrors(t *testing.T) {
+	var l []*authorizationv1.SelfSubjectAccessReview
+	for _, resource := range []string{"pods", "secrets", "configmaps", "secrets"} {
+		l = append(l, &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "smoke-test", Verb: "get", Resource: resource},
+		}})
+	}
+	// each failed review is an error, the others are still reviewed
+	results, errs := DoBatchSelfSubjectAccessReviews(logger, failingAuthorizer{"secrets"}, l, true, nil)
+	if len(errs) != 2 || len(results) != 2 || results[1].Resource != "configmaps" {
+		t.Errorf("expected 2 errors and 2 results, got %v and %+v", errs, results)
+	}
+}
+
+func TestCreateSubjectAccessReviewListMergesDocuments(t *testing.T) {
+	var multi_rbac_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-namespace-admin
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin-extra
+rules:
+- apiGroups: [""]
+  resources: ["pods", "configmaps"]
+  verbs: ["list"]
+`
+	path := filepath.Join(t.TempDir(), "multi.yaml")
+	if err := os.WriteFile(path, []byte(multi_rbac_yaml_text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sar_allowed, sar_forbidden := CreateSubjectAccessReviewList(logger, "./test_all_api_resources.txt", path, "smoke-test")
+
+	// the rules of both roles are allowed, pods with the union of the verbs
+	var allowed []string
+	for _, sar := range sar_allowed {
+		a := sar.Spec.ResourceAttributes
+		allowed = append(allowed, a.Resource+" "+a.Verb)
+	}
+	sort.Strings(allowed)
+	expect := []string{"configmaps list", "pods get", "pods list"}
+	if !reflect.DeepEqual(allowed, expect) {
+		t.Errorf("expected allowed %v, got %v", expect, allowed)
+	}
+	for _, sar := range sar_forbidden {
+		if a := sar.Spec.ResourceAttributes; a.Resource == "pods" && (a.Verb == "get" || a.Verb == "list") {
+			t.Errorf("expected pods %s allowed, got it forbidden", a.Verb)
+		}
 	}
-	if err := DoBatchSelfSubjectAccessReviews("./test_dev_config.yaml", sar_forbidden, false); err != nil {
-		t.Errorf("Test Error %s", err.Error())
+	if proc_rules.RbacRoleName != "namespace-admin" {
+		t.Errorf("expected the role name of the first role, got %s", proc_rules.RbacRoleName)
 	}
 }
@@ -0,0 +1,72 @@
+package rbac_rules_verification
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Outcomes of a single review
+const (
+	OutcomePassed = "passed"
+	OutcomeFailed = "failed"
+	OutcomeWaived = "waived"
+)
+
+// ReviewResult records the verdict of one SelfSubjectAccessReview against the expected one
+type ReviewResult struct {
+	// set for the reviews of an expectation spec
+	Persona     string `json:"persona,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Group           string `json:"group"`
+	Resource        string `json:"resource"`
+	Subresource     string `json:"subresource"`
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	Verb            string `json:"verb"`
+	Expected        bool   `json:"expected"`
+	Allowed         bool   `json:"allowed"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+	Outcome         string `json:"outcome"`
+	// set when a baseline waiver matched the failed review
+	Waiver        string `json:"waiver,omitempty"`
+	WaiverExpired bool   `json:"waiverExpired,omitempty"`
+}
+
+// RunResult is a verification run saved as json, two of them are compared by the diff subcommand
+type RunResult struct {
+	RbacYaml   string         `json:"rbacYaml"`
+	Role       string         `json:"role"`
+	Namespaces []string       `json:"namespaces"`
+	StartTime  time.Time      `json:"startTime"`
+	Results    []ReviewResult `json:"results"`
+}
+
+func SaveRunResult(path string, run RunResult) error {
+	j, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, j, 0644)
+}
+
+func LoadRunResult(path string) (RunResult, error) {
+	var run RunResult
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return run, err
+	}
+	err = json.Unmarshal(data, &run)
+	return run, err
+}
+
+// Summarize counts the results by outcome
+func Summarize(results []ReviewResult) map[string]int {
+	summary := map[string]int{OutcomePassed: 0, OutcomeFailed: 0, OutcomeWaived: 0}
+	for _, r := range results {
+		summary[r.Outcome]++
+	}
+	return summary
+}
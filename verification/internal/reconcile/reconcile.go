@@ -0,0 +1,422 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slices"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbacv1ac "k8s.io/client-go/applyconfigurations/rbac/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+/*
+Plan and apply the Roles, ClusterRoles and bindings of the files to a cluster, like "terraform plan" then "apply":
+
+	*create: the object is not in the cluster
+	*update: the object differs, the changes are computed semantically, i.e. rules reordered or split are unchanged
+	*replace: the roleRef of a binding differs, it is immutable so the binding is deleted then created
+	*prune: the object carries the ownership label of the owner but is not in the files anymore, only with prune since
+	 the files may be a subset of the repo
+
+Every applied object is labeled OwnerLabel=<owner>, so only the objects applied by us are ever pruned, and sent with a
+server-side apply under the field manager, forcing the conflicts on the fields we own. Roles are applied before the
+bindings and the bindings pruned before the roles. With dry_run, the apiserver validates the requests, admission
+included, without persisting them, a replaced binding is only deleted.
+
+The rules of a ClusterRole with an aggregationRule are filled by the aggregation controller, they are not compared.
+*/
+const (
+	OpCreate    = "create"
+	OpUpdate    = "update"
+	OpReplace   = "replace"
+	OpPrune     = "prune"
+	OpUnchanged = "unchanged"
+)
+
+const (
+	OwnerLabel          = "app.kubernetes.io/managed-by"
+	DefaultOwner        = "k8s-rbac-verification"
+	DefaultFieldManager = "k8s-rbac-verification"
+)
+
+type Action struct {
+	Op        string   `json:"op"`
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	Source    string   `json:"source"` // the file of the object, "cluster" for a pruned one
+	Changes   []string `json:"changes,omitempty"`
+}
+
+type Plan struct {
+	Owner   string   `json:"owner"`
+	Actions []Action `json:"actions"`
+	desired *rbac_objects.Objects
+}
+
+// the order objects are applied in, the reverse for prune
+var kindOrder = map[string]int{"ClusterRole": 0, "Role": 1, "ClusterRoleBinding": 2, "RoleBinding": 3}
+
+func Compute(desired *rbac_objects.Objects, live *rbac_objects.Objects, owner string, prune bool) Plan {
+	plan := Plan{Owner: owner, Actions: []Action{}, desired: desired}
+	add := func(kind string, namespace string, name string, found bool, changes []string, replace bool) {
+		a := Action{Op: OpUnchanged, Kind: kind, Namespace: namespace, Name: name, Changes: changes}
+		a.Source = desired.Source[rbac_objects.KeyOf(kind, namespace, name)].String()
+		switch {
+		case !found:
+			a.Op, a.Changes = OpCreate, nil
+		case replace:
+			a.Op = OpReplace
+		case len(changes) > 0:
+			a.Op = OpUpdate
+		}
+		plan.Actions = append(plan.Actions, a)
+	}
+
+	for _, d := range desired.ClusterRoles {
+		l, found := live.ClusterRole(d.Name)
+		add("ClusterRole", "", d.Name, found, roleChanges(d.ObjectMeta, l.ObjectMeta, d.Rules, l.Rules, d.AggregationRule, l.AggregationRule, owner), false)
+	}
+	for _, d := range desired.Roles {
+		l, found := live.Role(d.Namespace, d.Name)
+		add("Role", d.Namespace, d.Name, found, roleChanges(d.ObjectMeta, l.ObjectMeta, d.Rules, l.Rules, nil, nil, owner), false)
+	}
+	for _, d := range desired.ClusterRoleBindings {
+		l, found := clusterRoleBinding(live, d.Name)
+		changes, replace := bindingChanges(d.ObjectMeta, l.ObjectMeta, d.RoleRef, l.RoleRef, d.Subjects, l.Subjects, owner)
+		add("ClusterRoleBinding", "", d.Name, found, changes, replace)
+	}
+	for _, d := range desired.RoleBindings {
+		l, found := roleBinding(live, d.Namespace, d.Name)
+		changes, replace := bindingChanges(d.ObjectMeta, l.ObjectMeta, d.RoleRef, l.RoleRef, d.Subjects, l.Subjects, owner)
+		add("RoleBinding", d.Namespace, d.Name, found, changes, replace)
+	}
+
+	prune_missing := func(kind string, meta metav1.ObjectMeta) {
+		if !prune || meta.Labels[OwnerLabel] != owner {
+			return
+		}
+		if _, ok := desired.Source[rbac_objects.KeyOf(kind, meta.Namespace, meta.Name)]; !ok {
+			plan.Actions = append(plan.Actions, Action{Op: OpPrune, Kind: kind, Namespace: meta.Namespace, Name: meta.Name, Source: rbac_objects.OriginCluster.String()})
+		}
+	}
+	for _, l := range live.ClusterRoles {
+		prune_missing("ClusterRole", l.ObjectMeta)
+	}
+	for _, l := range live.Roles {
+		prune_missing("Role", l.ObjectMeta)
+	}
+	for _, l := range live.ClusterRoleBindings {
+		prune_missing("ClusterRoleBinding", l.ObjectMeta)
+	}
+	for _, l := range live.RoleBindings {
+		prune_missing("RoleBinding", l.ObjectMeta)
+	}
+
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		a, b := plan.Actions[i], plan.Actions[j]
+		if (a.Op == OpPrune) != (b.Op == OpPrune) {
+			return b.Op == OpPrune
+		}
+		if a.Kind != b.Kind {
+			if a.Op == OpPrune {
+				return kindOrder[a.Kind] > kindOrder[b.Kind]
+			}
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return plan
+}
+
+// Count counts the actions of the op
+func (p Plan) Count(op string) int {
+	count := 0
+	for _, a := range p.Actions {
+		if a.Op == op {
+			count++
+		}
+	}
+	return count
+}
+
+// Changed reports whether applying the plan changes the cluster
+func (p Plan) Changed() bool {
+	return p.Count(OpUnchanged) != len(p.Actions)
+}
+
+// Apply executes the actions of the plan, in order, and stops at the first failure
+func Apply(ctx context.Context, clientset kubernetes.Interface, p Plan, field_manager string, dry_run bool) error {
+	apply_opts := metav1.ApplyOptions{FieldManager: field_manager, Force: true}
+	delete_opts := metav1.DeleteOptions{}
+	if dry_run {
+		apply_opts.DryRun = []string{metav1.DryRunAll}
+		delete_opts.DryRun = []string{metav1.DryRunAll}
+	}
+	rbac := clientset.RbacV1()
+
+	for _, a := range p.Actions {
+		var err error
+		if a.Op == OpPrune || a.Op == OpReplace {
+			switch a.Kind {
+			case "ClusterRole":
+				err = rbac.ClusterRoles().Delete(ctx, a.Name, delete_opts)
+			case "Role":
+				err = rbac.Roles(a.Namespace).Delete(ctx, a.Name, delete_opts)
+			case "ClusterRoleBinding":
+				err = rbac.ClusterRoleBindings().Delete(ctx, a.Name, delete_opts)
+			case "RoleBinding":
+				err = rbac.RoleBindings(a.Namespace).Delete(ctx, a.Name, delete_opts)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to delete %s: %w", rbac_objects.KeyOf(a.Kind, a.Namespace, a.Name), err)
+			}
+		}
+		// a dry run keeps the replaced binding, applying its new roleRef would be refused
+		if a.Op == OpPrune || a.Op == OpUnchanged || (dry_run && a.Op == OpReplace) {
+			continue
+		}
+
+		switch a.Kind {
+		case "ClusterRole":
+			role, _ := p.desired.ClusterRole(a.Name)
+			ac := rbacv1ac.ClusterRole(a.Name)
+			if err = convert(role, p.Owner, ac); err == nil {
+				_, err = rbac.ClusterRoles().Apply(ctx, ac, apply_opts)
+			}
+		case "Role":
+			role, _ := p.desired.Role(a.Namespace, a.Name)
+			ac := rbacv1ac.Role(a.Name, a.Namespace)
+			if err = convert(role, p.Owner, ac); err == nil {
+				_, err = rbac.Roles(a.Namespace).Apply(ctx, ac, apply_opts)
+			}
+		case "ClusterRoleBinding":
+			binding, _ := clusterRoleBinding(p.desired, a.Name)
+			ac := rbacv1ac.ClusterRoleBinding(a.Name)
+			if err = convert(binding, p.Owner, ac); err == nil {
+				_, err = rbac.ClusterRoleBindings().Apply(ctx, ac, apply_opts)
+			}
+		case "RoleBinding":
+			binding, _ := roleBinding(p.desired, a.Namespace, a.Name)
+			ac := rbacv1ac.RoleBinding(a.Name, a.Namespace)
+			if err = convert(binding, p.Owner, ac); err == nil {
+				_, err = rbac.RoleBindings(a.Namespace).Apply(ctx, ac, apply_opts)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply %s: %w", rbac_objects.KeyOf(a.Kind, a.Namespace, a.Name), err)
+		}
+	}
+	return nil
+}
+
+func WriteText(w io.Writer, p Plan) {
+	for _, a := range p.Actions {
+		if a.Op == OpUnchanged {
+			continue
+		}
+		fmt.Fprintf(w, "%s %s (%s)\n", a.Op, rbac_objects.KeyOf(a.Kind, a.Namespace, a.Name), a.Source)
+		for _, c := range a.Changes {
+			fmt.Fprintf(w, "  %s\n", c)
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to replace, %d to prune, %d unchanged\n",
+		p.Count(OpCreate), p.Count(OpUpdate), p.Count(OpReplace), p.Count(OpPrune), p.Count(OpUnchanged))
+}
+
+func WriteJson(w io.Writer, p Plan) error {
+	j, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// SubjectsOf lists the subjects as "<kind> <namespace>/<name>", sorted
+func SubjectsOf(subjects []rbacv1.Subject) []string {
+	var ret []string
+	for _, s := range subjects {
+		if s.Namespace != "" {
+			ret = append(ret, fmt.Sprintf("%s %s/%s", s.Kind, s.Namespace, s.Name))
+		} else {
+			ret = append(ret, fmt.Sprintf("%s %s", s.Kind, s.Name))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// PermissionsOf splits the rules in "<resource or url> -> verbs", so differently grouped rules compare equal
+func PermissionsOf(rules []rbacv1.PolicyRule) map[string][]string {
+	permissions := make(map[string][]string)
+	add := func(key string, verbs []string) {
+		for _, verb := range verbs {
+			if !slices.Contains(permissions[key], verb) {
+				permissions[key] = append(permissions[key], verb)
+			}
+		}
+	}
+	for _, r := range rules {
+		for _, url := range r.NonResourceURLs {
+			add(fmt.Sprintf("nonResourceURL %q", url), r.Verbs)
+		}
+		for _, group := range r.APIGroups {
+			for _, resource := range r.Resources {
+				key := fmt.Sprintf("apigroup %q resource %q", group, resource)
+				if len(r.ResourceNames) > 0 {
+					names := append([]string{}, r.ResourceNames...)
+					sort.Strings(names)
+					for _, name := range names {
+						add(fmt.Sprintf("%s name %q", key, name), r.Verbs)
+					}
+					continue
+				}
+				add(key, r.Verbs)
+			}
+		}
+	}
+	return permissions
+}
+
+// DiffPermissions lists the verbs of a missing from b, sorted, prefixed with marker
+func DiffPermissions(a map[string][]string, b map[string][]string, marker string) []string {
+	var changes []string
+	for key, verbs := range a {
+		var missing []string
+		for _, verb := range verbs {
+			if !slices.Contains(b[key], verb) {
+				missing = append(missing, verb)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			changes = append(changes, fmt.Sprintf("%s %s verbs: [%s]", marker, key, strings.Join(missing, " ")))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// helper functions
+func clusterRoleBinding(objects *rbac_objects.Objects, name string) (rbacv1.ClusterRoleBinding, bool) {
+	for _, b := range objects.ClusterRoleBindings {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return rbacv1.ClusterRoleBinding{}, false
+}
+
+func roleBinding(objects *rbac_objects.Objects, namespace string, name string) (rbacv1.RoleBinding, bool) {
+	for _, b := range objects.RoleBindings {
+		if b.Namespace == namespace && b.Name == name {
+			return b, true
+		}
+	}
+	return rbacv1.RoleBinding{}, false
+}
+
+// convert copies the object of the files, with the ownership label, into its apply configuration
+func convert(obj interface{}, owner string, ac interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	// only the name, namespace, labels and annotations of the metadata are ours
+	meta, _ := fields["metadata"].(map[string]interface{})
+	labels, _ := meta["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = make(map[string]interface{})
+	}
+	labels[OwnerLabel] = owner
+	owned := map[string]interface{}{"name": meta["name"], "labels": labels}
+	for _, key := range []string{"namespace", "annotations"} {
+		if v, ok := meta[key]; ok {
+			owned[key] = v
+		}
+	}
+	fields["metadata"] = owned
+	delete(fields, "apiVersion")
+	delete(fields, "kind")
+
+	if data, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, ac)
+}
+
+// metaChanges lists the labels and annotations of the files, and the ownership label, missing from the live object
+func metaChanges(d metav1.ObjectMeta, l metav1.ObjectMeta, owner string) []string {
+	var changes []string
+	labels := map[string]string{OwnerLabel: owner}
+	for k, v := range d.Labels {
+		labels[k] = v
+	}
+	for _, m := range []struct {
+		what    string
+		desired map[string]string
+		live    map[string]string
+	}{{"label", labels, l.Labels}, {"annotation", d.Annotations, l.Annotations}} {
+		for k, v := range m.desired {
+			if live, ok := m.live[k]; !ok || live != v {
+				changes = append(changes, fmt.Sprintf("~ %s %s: %q", m.what, k, v))
+			}
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func roleChanges(d_meta metav1.ObjectMeta, l_meta metav1.ObjectMeta, d_rules []rbacv1.PolicyRule, l_rules []rbacv1.PolicyRule,
+	d_aggregation *rbacv1.AggregationRule, l_aggregation *rbacv1.AggregationRule, owner string) []string {
+	changes := metaChanges(d_meta, l_meta, owner)
+	if d_aggregation != nil || l_aggregation != nil {
+		if !reflect.DeepEqual(d_aggregation, l_aggregation) {
+			changes = append(changes, "~ aggregationRule")
+		}
+		if d_aggregation != nil {
+			return changes
+		}
+	}
+	desired, live := PermissionsOf(d_rules), PermissionsOf(l_rules)
+	changes = append(changes, DiffPermissions(desired, live, "+")...)
+	changes = append(changes, DiffPermissions(live, desired, "-")...)
+	return changes
+}
+
+func bindingChanges(d_meta metav1.ObjectMeta, l_meta metav1.ObjectMeta, d_ref rbacv1.RoleRef, l_ref rbacv1.RoleRef,
+	d_subjects []rbacv1.Subject, l_subjects []rbacv1.Subject, owner string) ([]string, bool) {
+	changes := metaChanges(d_meta, l_meta, owner)
+	replace := d_ref.Kind != l_ref.Kind || d_ref.Name != l_ref.Name
+	if replace {
+		changes = append(changes, fmt.Sprintf("~ roleRef %s/%s -> %s/%s, immutable", l_ref.Kind, l_ref.Name, d_ref.Kind, d_ref.Name))
+	}
+	desired, live := SubjectsOf(d_subjects), SubjectsOf(l_subjects)
+	for _, s := range desired {
+		if !slices.Contains(live, s) {
+			changes = append(changes, "+ subject "+s)
+		}
+	}
+	for _, s := range live {
+		if !slices.Contains(desired, s) {
+			changes = append(changes, "- subject "+s)
+		}
+	}
+	return changes, replace
+}
@@ -0,0 +1,212 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups: [""]
+  resources: ["pods", "services"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["delete"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: node-viewer
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-namespace-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: namespace-admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: node-viewer
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: node-viewer
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:cluster-operator
+`
+
+var owned = map[string]string{OwnerLabel: DefaultOwner}
+
+// the live objects: namespace-admin with the same rules grouped differently, node-viewer not labeled and missing get,
+// the mldev binding to another role, and a leftover binding of ours
+func liveObjects() []runtime.Object {
+	ref := func(name string) rbacv1.RoleRef {
+		return rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+	}
+	return []runtime.Object{
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "namespace-admin", Labels: owned}, Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"list", "get"}},
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"delete", "get", "list"}},
+		}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "node-viewer"}, Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"list", "watch"}},
+		}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "mldev-namespace-admin", Namespace: "mldev", Labels: owned}, RoleRef: ref("admin"),
+			Subjects: []rbacv1.Subject{{Kind: "Group", Name: "oidc:mldev-namespace-admin"}}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "project-lima-namespace-admin", Namespace: "project-lima", Labels: owned}, RoleRef: ref("namespace-admin")},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "system:basic-user"}, RoleRef: ref("system:basic-user")},
+	}
+}
+
+func setup(t *testing.T) (*rbac_objects.Objects, *fake.Clientset, Plan) {
+	desired := rbac_objects.New()
+	if err := desired.Decode("rbac.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset(liveObjects()...)
+	live, err := rbac_objects.LoadClientset(context.Background(), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desired, clientset, Compute(desired, live, DefaultOwner, true)
+}
+
+func TestCompute(t *testing.T) {
+	_, _, plan := setup(t)
+	var got []string
+	for _, a := range plan.Actions {
+		got = append(got, a.Op+" "+rbac_objects.KeyOf(a.Kind, a.Namespace, a.Name))
+	}
+	expect := []string{
+		"unchanged ClusterRole/namespace-admin",
+		"update ClusterRole/node-viewer",
+		"create ClusterRoleBinding/node-viewer",
+		"replace RoleBinding/mldev/mldev-namespace-admin",
+		"prune RoleBinding/project-lima/project-lima-namespace-admin",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected actions\n%s\ngot\n%s", strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+
+	expect_changes := []string{
+		`~ label app.kubernetes.io/managed-by: "k8s-rbac-verification"`,
+		`+ apigroup "" resource "nodes" verbs: [get]`,
+		`- apigroup "" resource "nodes" verbs: [watch]`,
+	}
+	if !reflect.DeepEqual(plan.Actions[1].Changes, expect_changes) {
+		t.Errorf("expected changes %v, got %v", expect_changes, plan.Actions[1].Changes)
+	}
+	if c := plan.Actions[3].Changes; len(c) != 1 || c[0] != "~ roleRef ClusterRole/admin -> ClusterRole/namespace-admin, immutable" {
+		t.Errorf("unexpected roleRef change %v", c)
+	}
+	if plan.Actions[1].Source != "rbac.yaml: document 1" || plan.Actions[4].Source != "cluster" {
+		t.Errorf("unexpected sources %q, %q", plan.Actions[1].Source, plan.Actions[4].Source)
+	}
+	if !plan.Changed() || plan.Count(OpUnchanged) != 1 {
+		t.Errorf("expected a changed plan")
+	}
+}
+
+func TestComputeWithoutPrune(t *testing.T) {
+	// a subset of the files, only namespace-admin
+	desired := rbac_objects.New()
+	if err := desired.Decode("rbac.yaml", strings.NewReader(strings.Split(objects_yaml_text, "---\n")[0])); err != nil {
+		t.Fatal(err)
+	}
+	live, err := rbac_objects.LoadClientset(context.Background(), fake.NewSimpleClientset(liveObjects()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := Compute(desired, live, DefaultOwner, false); plan.Count(OpPrune) != 0 || len(plan.Actions) != 1 {
+		t.Errorf("expected no prune, got %+v", plan.Actions)
+	}
+	if plan := Compute(desired, live, DefaultOwner, true); plan.Count(OpPrune) != 2 {
+		t.Errorf("expected the 2 other objects of ours pruned, got %+v", plan.Actions)
+	}
+}
+
+func TestApply(t *testing.T) {
+	_, clientset, plan := setup(t)
+	// the fake clientset cannot apply an object it does not have
+	var applied []map[string]interface{}
+	clientset.PrependReactor("patch", "*", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		patch := action.(k8s_testing.PatchAction)
+		var fields map[string]interface{}
+		json.Unmarshal(patch.GetPatch(), &fields)
+		applied = append(applied, fields)
+		return true, nil, nil
+	})
+	clientset.ClearActions()
+
+	if err := Apply(context.Background(), clientset, plan, DefaultFieldManager, false); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range clientset.Actions() {
+		got = append(got, a.GetVerb()+" "+a.GetResource().Resource+" "+a.GetNamespace())
+	}
+	expect := []string{"patch clusterroles ", "patch clusterrolebindings ", "delete rolebindings mldev", "patch rolebindings mldev", "delete rolebindings project-lima"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected requests %v, got %v", expect, got)
+	}
+	if patch := clientset.Actions()[0].(k8s_testing.PatchAction); patch.GetPatchType() != "application/apply-patch+yaml" {
+		t.Errorf("expected a server-side apply, got %s", patch.GetPatchType())
+	}
+
+	meta := applied[0]["metadata"].(map[string]interface{})
+	if meta["labels"].(map[string]interface{})[OwnerLabel] != DefaultOwner || applied[0]["kind"] != "ClusterRole" {
+		t.Errorf("expected the ownership label and kind, got %v", applied[0])
+	}
+	if _, ok := meta["creationTimestamp"]; ok {
+		t.Errorf("expected only our metadata, got %v", meta)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	_, clientset, plan := setup(t)
+	clientset.PrependReactor("patch", "*", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	clientset.ClearActions()
+	if err := Apply(context.Background(), clientset, plan, DefaultFieldManager, true); err != nil {
+		t.Fatal(err)
+	}
+	// a replaced binding is only deleted
+	if n := len(clientset.Actions()); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+	for _, a := range clientset.Actions() {
+		if d, ok := a.(k8s_testing.DeleteAction); ok && !reflect.DeepEqual(d.GetDeleteOptions().DryRun, []string{metav1.DryRunAll}) {
+			t.Errorf("expected a dry run delete, got %+v", d.GetDeleteOptions())
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package results_diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+)
+
+/*
+Compare two verification runs, reviews are matched by {persona, apigroup, resource, subresource, name, namespace, verb}:
+
+	*a review found in both runs with a different verdict or outcome is "flipped", i.e. allowed in both runs but no more
+	 expected, it is a regression when the new review failed
+	*a review found in only one of the runs is "added" or "removed", i.e. a new resource in the cluster after an upgrade
+	*a review found in both runs with the same verdict but a different reason or evaluation error is "reason changed"
+*/
+type ReviewKey struct {
+	Persona     string `json:"persona,omitempty"`
+	Group       string `json:"group"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource"`
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	Verb        string `json:"verb"`
+}
+
+type ChangedReview struct {
+	ReviewKey
+	Old        verify.ReviewResult `json:"old"`
+	New        verify.ReviewResult `json:"new"`
+	Regression bool                `json:"regression"`
+}
+
+type Report struct {
+	Flipped       []ChangedReview       `json:"flipped"`
+	Added         []verify.ReviewResult `json:"added"`
+	Removed       []verify.ReviewResult `json:"removed"`
+	ReasonChanged []ChangedReview       `json:"reasonChanged"`
+}
+
+func keyOf(r verify.ReviewResult) ReviewKey {
+	return ReviewKey{r.Persona, r.Group, r.Resource, r.Subresource, r.Name, r.Namespace, r.Verb}
+}
+
+func (k ReviewKey) String() string {
+	if k.Persona != "" {
+		return fmt.Sprintf("{persona: %s, apigroup: %s, resource: %s, name: %s, namespace: %s, verb: %s}", k.Persona, k.Group, k.Resource, k.Name, k.Namespace, k.Verb)
+	}
+	return fmt.Sprintf("{apigroup: %s, resource: %s, name: %s, namespace: %s, verb: %s}", k.Group, k.Resource, k.Name, k.Namespace, k.Verb)
+}
+
+func Diff(old_run verify.RunResult, new_run verify.RunResult) Report {
+	old_reviews := make(map[ReviewKey]verify.ReviewResult)
+	for _, r := range old_run.Results {
+		old_reviews[keyOf(r)] = r
+	}
+	new_reviews := make(map[ReviewKey]verify.ReviewResult)
+	for _, r := range new_run.Results {
+		new_reviews[keyOf(r)] = r
+	}
+
+	var report Report
+	for k, n := range new_reviews {
+		o, ok := old_reviews[k]
+		if !ok {
+			report.Added = append(report.Added, n)
+		} else if o.Allowed != n.Allowed || o.Expected != n.Expected || o.Outcome != n.Outcome {
+			report.Flipped = append(report.Flipped, ChangedReview{k, o, n, n.Outcome == verify.OutcomeFailed})
+		} else if o.Reason != n.Reason || o.EvaluationError != n.EvaluationError {
+			report.ReasonChanged = append(report.ReasonChanged, ChangedReview{k, o, n, false})
+		}
+	}
+	for k, o := range old_reviews {
+		if _, ok := new_reviews[k]; !ok {
+			report.Removed = append(report.Removed, o)
+		}
+	}
+
+	sortResults(report.Added)
+	sortResults(report.Removed)
+	sortChanged(report.Flipped)
+	sortChanged(report.ReasonChanged)
+	return report
+}
+
+// Regressions counts the flipped reviews which failed in the new run
+func (r Report) Regressions() int {
+	count := 0
+	for _, c := range r.Flipped {
+		if c.Regression {
+			count++
+		}
+	}
+	return count
+}
+
+func (r Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Flipped verdicts: %d (regressions: %d)\n", len(r.Flipped), r.Regressions())
+	for _, c := range r.Flipped {
+		marker := "  "
+		if c.Regression {
+			marker = "+ "
+		}
+		fmt.Fprintf(w, "%s%s %t -> %t (%s -> %s)\n", marker, c.ReviewKey, c.Old.Allowed, c.New.Allowed, c.Old.Outcome, c.New.Outcome)
+	}
+	fmt.Fprintf(w, "Added reviews: %d\n", len(r.Added))
+	for _, a := range r.Added {
+		fmt.Fprintf(w, "  %s allowed: %t (%s)\n", keyOf(a), a.Allowed, a.Outcome)
+	}
+	fmt.Fprintf(w, "Removed reviews: %d\n", len(r.Removed))
+	for _, a := range r.Removed {
+		fmt.Fprintf(w, "  %s allowed: %t (%s)\n", keyOf(a), a.Allowed, a.Outcome)
+	}
+	fmt.Fprintf(w, "Changed reasons: %d\n", len(r.ReasonChanged))
+	for _, c := range r.ReasonChanged {
+		fmt.Fprintf(w, "  %s\n    - %s\n    + %s\n", c.ReviewKey, reasonOf(c.Old), reasonOf(c.New))
+	}
+}
+
+func (r Report) WriteJson(w io.Writer) error {
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func reasonOf(r verify.ReviewResult) string {
+	if r.EvaluationError != "" {
+		return fmt.Sprintf("%q, evaluation error: %q", r.Reason, r.EvaluationError)
+	}
+	return fmt.Sprintf("%q", r.Reason)
+}
+
+func lessKey(a ReviewKey, b ReviewKey) bool {
+	if a.Persona != b.Persona {
+		return a.Persona < b.Persona
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	if a.Group != b.Group {
+		return a.Group < b.Group
+	}
+	if a.Resource != b.Resource {
+		return a.Resource < b.Resource
+	}
+	if a.Subresource != b.Subresource {
+		return a.Subresource < b.Subresource
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Verb < b.Verb
+}
+
+func sortResults(l []verify.ReviewResult) {
+	sort.Slice(l, func(i, j int) bool { return lessKey(keyOf(l[i]), keyOf(l[j])) })
+}
+
+func sortChanged(l []ChangedReview) {
+	sort.Slice(l, func(i, j int) bool { return lessKey(l[i].ReviewKey, l[j].ReviewKey) })
+}
@@ -0,0 +1,108 @@
+package results_diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	verify "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_rules_verification"
+)
+
+func review(resource string, verb string, expected bool, allowed bool, reason string) verify.ReviewResult {
+	outcome := verify.OutcomePassed
+	if expected != allowed {
+		outcome = verify.OutcomeFailed
+	}
+	return verify.ReviewResult{
+		Resource:  resource,
+		Namespace: "smoke-test",
+		Verb:      verb,
+		Expected:  expected,
+		Allowed:   allowed,
+		Reason:    reason,
+		Outcome:   outcome,
+	}
+}
+
+var old_run = verify.RunResult{
+	Results: []verify.ReviewResult{
+		review("pods", "get", true, true, "allowed by RoleBinding"),
+		review("pods", "create", false, false, ""),
+		review("secrets", "delete", false, true, "allowed by ClusterRoleBinding"),
+		review("configmaps", "list", true, true, "allowed by RoleBinding \"a\""),
+		review("podsecuritypolicies", "use", false, false, ""),
+	},
+}
+
+var new_run = verify.RunResult{
+	Results: []verify.ReviewResult{
+		review("pods", "get", true, true, "allowed by RoleBinding"),
+		review("pods", "create", false, true, "allowed by ClusterRoleBinding"),
+		review("secrets", "delete", false, false, ""),
+		review("configmaps", "list", true, true, "allowed by RoleBinding \"b\""),
+		review("volcano-jobs", "get", false, false, ""),
+	},
+}
+
+func TestDiff(t *testing.T) {
+	report := Diff(old_run, new_run)
+
+	if len(report.Flipped) != 2 {
+		t.Fatalf("expected 2 flipped reviews, got %d", len(report.Flipped))
+	}
+	// sorted by resource
+	if report.Flipped[0].Resource != "pods" || !report.Flipped[0].Regression {
+		t.Errorf("expected pods create to be a regression, got %+v", report.Flipped[0])
+	}
+	if report.Flipped[1].Resource != "secrets" || report.Flipped[1].Regression {
+		t.Errorf("expected secrets delete to be fixed, got %+v", report.Flipped[1])
+	}
+	if report.Regressions() != 1 {
+		t.Errorf("expected 1 regression, got %d", report.Regressions())
+	}
+	if !reflect.DeepEqual(report.Added, []verify.ReviewResult{new_run.Results[4]}) {
+		t.Errorf("unexpected added reviews %+v", report.Added)
+	}
+	if !reflect.DeepEqual(report.Removed, []verify.ReviewResult{old_run.Results[4]}) {
+		t.Errorf("unexpected removed reviews %+v", report.Removed)
+	}
+	if len(report.ReasonChanged) != 1 || report.ReasonChanged[0].Resource != "configmaps" {
+		t.Errorf("unexpected changed reasons %+v", report.ReasonChanged)
+	}
+
+	// the same verdict, no more expected
+	expectation_run := verify.RunResult{Results: []verify.ReviewResult{review("pods", "get", false, true, "allowed by RoleBinding")}}
+	if report := Diff(old_run, expectation_run); len(report.Flipped) != 1 || report.Regressions() != 1 || report.Flipped[0].Old.Outcome != verify.OutcomePassed {
+		t.Errorf("expected pods get passed then failed to be a regression, got %+v", report.Flipped)
+	}
+
+	if report := Diff(old_run, old_run); len(report.Flipped)+len(report.Added)+len(report.Removed)+len(report.ReasonChanged) != 0 {
+		t.Errorf("expected no difference for the same run, got %+v", report)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := Diff(old_run, new_run)
+
+	var text bytes.Buffer
+	report.WriteText(&text)
+	for _, s := range []string{"Flipped verdicts: 2 (regressions: 1)", "Added reviews: 1", "Removed reviews: 1", "Changed reasons: 1", "volcano-jobs"} {
+		if !strings.Contains(text.String(), s) {
+			t.Errorf("expected %q in text report:\n%s", s, text.String())
+		}
+	}
+
+	var j bytes.Buffer
+	if err := report.WriteJson(&j); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(j.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode json report: %s", err.Error())
+	}
+	if !reflect.DeepEqual(decoded, report) {
+		t.Errorf("json report does not round trip:\n%s", j.String())
+	}
+}
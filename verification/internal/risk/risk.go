@@ -0,0 +1,176 @@
+package risk
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"golang.org/x/exp/slices"
+)
+
+/*
+Flag the escalation primitives in an expanded allowed set, each finding is reported on the rule granting it, as
+recorded in RbacRulesOrigin. A check matches any of its apigroups, resources and verbs, "*" matching all of them.
+*/
+type Check struct {
+	RuleID    string
+	Severity  diagnostics.Severity
+	Groups    []string
+	Resources []string
+	Verbs     []string
+	Risk      string
+}
+
+// RuleWildcard flags the rules granting through "*" apiGroups, resources or verbs
+const RuleWildcard = "risk-wildcard"
+
+var workloads = []string{"pods", "deployments", "replicasets", "statefulsets", "daemonsets", "jobs", "cronjobs", "replicationcontrollers"}
+
+var Checks = []Check{
+	{
+		"risk-escalate", diagnostics.SeverityError,
+		[]string{"rbac.authorization.k8s.io"}, []string{"roles", "clusterroles"}, []string{"escalate"},
+		"can create or update roles with permissions the subject does not hold itself",
+	},
+	{
+		"risk-bind", diagnostics.SeverityError,
+		[]string{"rbac.authorization.k8s.io"}, []string{"roles", "clusterroles"}, []string{"bind"},
+		"can bind any role to any subject, including permissions the subject does not hold itself",
+	},
+	{
+		"risk-impersonate", diagnostics.SeverityError,
+		[]string{"", "authentication.k8s.io"}, []string{"users", "groups", "serviceaccounts", "userextras", "uids"}, []string{"impersonate"},
+		"can act as other users, groups or service accounts and gain all of their permissions",
+	},
+	{
+		"risk-secrets-read", diagnostics.SeverityWarning,
+		[]string{""}, []string{"secrets"}, []string{"get", "list", "watch"},
+		"can read secrets, including service account tokens and credentials of other workloads",
+	},
+	{
+		"risk-pods-exec", diagnostics.SeverityWarning,
+		[]string{""}, []string{"pods/exec", "pods/attach"}, []string{"create", "get"},
+		"can run commands in running containers, and use their service account tokens and mounted secrets",
+	},
+	{
+		"risk-nodes-proxy", diagnostics.SeverityError,
+		[]string{""}, []string{"nodes/proxy"}, []string{"*"},
+		"can reach the kubelet api of the nodes, bypassing admission and audit, i.e. to exec into any pod",
+	},
+	{
+		"risk-create-pods", diagnostics.SeverityWarning,
+		[]string{"", "apps", "batch", "extensions"}, workloads, []string{"create"},
+		"can run pods mounting any service account of the namespace, stealing its token and inheriting its permissions",
+	},
+	{
+		"risk-serviceaccount-token", diagnostics.SeverityError,
+		[]string{""}, []string{"serviceaccounts/token"}, []string{"create"},
+		"can request tokens of service accounts and act as them",
+	},
+	{
+		"risk-csr-approval", diagnostics.SeverityError,
+		[]string{"certificates.k8s.io"}, []string{"certificatesigningrequests/approval", "signers"}, []string{"update", "patch", "approve"},
+		"can approve certificate signing requests, i.e. issuing client certificates for any user or group",
+	},
+	{
+		"risk-webhook-write", diagnostics.SeverityError,
+		[]string{"admissionregistration.k8s.io"}, []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"}, []string{"create", "update", "patch", "delete", "deletecollection"},
+		"can intercept or alter every object written to the cluster, or disable admission policies",
+	},
+}
+
+// Rules describes the rule IDs of Analyze, with their default severity
+func Rules() []diagnostics.Rule {
+	var rules []diagnostics.Rule
+	for _, c := range Checks {
+		rules = append(rules, diagnostics.Rule{ID: c.RuleID, Severity: c.Severity, Description: fmt.Sprintf("Grants %v on %v: %s.", c.Verbs, c.Resources, c.Risk)})
+	}
+	return append(rules, diagnostics.Rule{
+		ID:          RuleWildcard,
+		Severity:    diagnostics.SeverityWarning,
+		Description: "Grants through \"*\" wildcards: also grants every resource or verb added to the cluster in the future.",
+	})
+}
+
+// Analyze checks the allowed set against Checks, one diagnostic per check, granting rule and resource
+func Analyze(allowed map[string]proc_rules.ApiGroupValueType, origins map[proc_rules.PermissionKey][]proc_rules.RuleRef) []diagnostics.Diagnostic {
+	type findingKey struct {
+		rule_id  string
+		ref      proc_rules.RuleRef
+		group    string
+		resource string
+	}
+	findings := make(map[findingKey][]string)
+	wildcards := make(map[proc_rules.RuleRef]bool)
+
+	for k, v := range allowed {
+		for kk, vv := range v.Resource {
+			for _, verb := range vv.Verbs {
+				refs := origins[proc_rules.PermissionKey{Group: k, Resource: kk, Verb: verb}]
+				for _, ref := range refs {
+					if ref.Wildcard {
+						wildcards[ref] = true
+					}
+				}
+				for _, c := range Checks {
+					if !matches(c.Groups, k) || !matches(c.Resources, kk) || !matches(c.Verbs, verb) {
+						continue
+					}
+					for _, ref := range refs {
+						fk := findingKey{c.RuleID, ref, k, kk}
+						findings[fk] = append(findings[fk], verb)
+					}
+				}
+			}
+		}
+	}
+
+	var diags []diagnostics.Diagnostic
+	for fk, verbs := range findings {
+		c := checkOf(fk.rule_id)
+		sort.Strings(verbs)
+		diags = append(diags, diagnostics.Diagnostic{
+			RuleID:    c.RuleID,
+			Severity:  c.Severity,
+			Message:   fmt.Sprintf("grants %v on %q in apigroup %q: %s", verbs, fk.resource, fk.group, c.Risk),
+			File:      fk.ref.File,
+			Document:  fk.ref.Document,
+			RuleIndex: fk.ref.RuleIndex,
+		})
+	}
+	for ref := range wildcards {
+		diags = append(diags, diagnostics.Diagnostic{
+			RuleID:    RuleWildcard,
+			Severity:  diagnostics.SeverityWarning,
+			Message:   "grants through \"*\" wildcards: also grants every resource or verb added to the cluster in the future",
+			File:      ref.File,
+			Document:  ref.Document,
+			RuleIndex: ref.RuleIndex,
+		})
+	}
+
+	// sort by location, then rule ID and message for a stable output
+	sort.Slice(diags, func(i, j int) bool {
+		if diags[i].RuleID != diags[j].RuleID {
+			return diags[i].RuleID < diags[j].RuleID
+		}
+		return diags[i].Message < diags[j].Message
+	})
+	diagnostics.Sort(diags)
+	return diags
+}
+
+// helper functions
+func matches(patterns []string, value string) bool {
+	return slices.Contains(patterns, "*") || slices.Contains(patterns, value)
+}
+
+func checkOf(rule_id string) Check {
+	for _, c := range Checks {
+		if c.RuleID == rule_id {
+			return c
+		}
+	}
+	return Check{}
+}
@@ -0,0 +1,119 @@
+package risk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+pods/exec                                               v1                                     true         PodExecOptions                   [create get]
+secrets                                                 v1                                     true         Secret                           [create delete deletecollection get list patch update watch]
+serviceaccounts                   sa                    v1                                     true         ServiceAccount                   [create delete deletecollection get list patch update watch]
+configmaps                        cm                    v1                                     true         ConfigMap                        [create delete deletecollection get list patch update watch]
+clusterroles                                            rbac.authorization.k8s.io/v1           false        ClusterRole                      [create delete deletecollection get list patch update watch]
+mutatingwebhookconfigurations                           admissionregistration.k8s.io/v1        false        MutatingWebhookConfiguration     [create delete deletecollection get list patch update watch]
+certificatesigningrequests        csr                   certificates.k8s.io/v1                 false        CertificateSigningRequest        [create delete deletecollection get list patch update watch]`
+
+var role_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - configmaps
+  - secrets
+  verbs:
+  - get
+  - list
+- apiGroups:
+  - ""
+  resources:
+  - serviceaccounts
+  verbs:
+  - impersonate
+- apiGroups:
+  - ""
+  resources:
+  - pods
+  - pods/exec
+  verbs:
+  - create
+- apiGroups:
+  - admissionregistration.k8s.io
+  resources:
+  - "*"
+  verbs:
+  - get
+- apiGroups:
+  - rbac.authorization.k8s.io
+  resources:
+  - clusterroles
+  verbs:
+  - "*"
+- apiGroups:
+  - ""
+  resources:
+  - users
+  - groups
+  verbs:
+  - impersonate
+- apiGroups:
+  - certificates.k8s.io
+  resources:
+  - signers
+  verbs:
+  - approve
+`
+
+func TestAnalyze(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	os.WriteFile(filepath.Join(dir, "role.yaml"), []byte(role_yaml_text), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+	if diags := proc_rules.ParseK8sRbacYaml(utils.DiscardLogger(), filepath.Join(dir, "role.yaml")); len(diags) != 0 {
+		t.Fatalf("expected the impersonate and approve verbs to be valid, got %v", diags)
+	}
+
+	diags := Analyze(proc_rules.RbacRulesMap, proc_rules.RbacRulesOrigin)
+	expect := []struct {
+		rule_id    string
+		rule_index int
+	}{
+		{"risk-secrets-read", 0},
+		{"risk-impersonate", 1},
+		{"risk-create-pods", 2},
+		{"risk-pods-exec", 2},
+		{"risk-wildcard", 3},
+		{"risk-bind", 4},
+		{"risk-escalate", 4},
+		{"risk-wildcard", 4},
+		{"risk-impersonate", 5},
+		{"risk-impersonate", 5},
+		{"risk-csr-approval", 6},
+	}
+	if len(diags) != len(expect) {
+		t.Fatalf("expected %d findings, got %d:\n%v", len(expect), len(diags), diags)
+	}
+	for i, e := range expect {
+		if diags[i].RuleID != e.rule_id || diags[i].RuleIndex != e.rule_index {
+			t.Errorf("expected %s at rule %d, got %s", e.rule_id, e.rule_index, diags[i])
+		}
+	}
+	if diags[0].Message != `grants [get list] on "secrets" in apigroup "": can read secrets, including service account tokens and credentials of other workloads` {
+		t.Errorf("unexpected message %s", diags[0].Message)
+	}
+	if diags[9].Message != `grants [impersonate] on "users" in apigroup "": can act as other users, groups or service accounts and gain all of their permissions` {
+		t.Errorf("unexpected message %s", diags[9].Message)
+	}
+	if diagnostics.Count(diags, diagnostics.SeverityError) != 6 {
+		t.Errorf("expected 6 errors, got %v", diags)
+	}
+}
@@ -0,0 +1,113 @@
+package role_diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+)
+
+/*
+Compare two versions of a role by their expanded permissions rather than their text, so reordered or regrouped rules
+do not show up as changes. Both versions must be expanded against the same resource catalog.
+
+	*a {apigroup, resource, verb} tuple only found in the new version is "granted"
+	*a tuple only found in the old version is "revoked"
+
+A change is "wildcard only" when all the rules granting the tuple, in the new version for a grant and in the old version
+for a revocation, use "*" apiGroups, resources or verbs, i.e. a new resource in the catalog silently granted by "*".
+*/
+type Change struct {
+	proc_rules.PermissionKey
+	WildcardOnly bool                 `json:"wildcardOnly"`
+	Rules        []proc_rules.RuleRef `json:"rules"`
+}
+
+type Report struct {
+	Old     string   `json:"old"`
+	New     string   `json:"new"`
+	Granted []Change `json:"granted"`
+	Revoked []Change `json:"revoked"`
+}
+
+func Diff(old_role proc_rules.ExpandedRole, new_role proc_rules.ExpandedRole) Report {
+	return Report{
+		Old:     old_role.File,
+		New:     new_role.File,
+		Granted: changesOf(proc_rules.SubtractRules(new_role.Rules, old_role.Rules), new_role.Origin),
+		Revoked: changesOf(proc_rules.SubtractRules(old_role.Rules, new_role.Rules), old_role.Origin),
+	}
+}
+
+func (r Report) Empty() bool {
+	return len(r.Granted) == 0 && len(r.Revoked) == 0
+}
+
+func (r Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", r.Old, r.New)
+	writeSection(w, "Granted", "+", r.Granted, false)
+	writeSection(w, "Granted through wildcards only", "+", r.Granted, true)
+	writeSection(w, "Revoked", "-", r.Revoked, false)
+	writeSection(w, "Revoked through wildcards only", "-", r.Revoked, true)
+}
+
+func (r Report) WriteJson(w io.Writer) error {
+	if r.Granted == nil {
+		r.Granted = []Change{}
+	}
+	if r.Revoked == nil {
+		r.Revoked = []Change{}
+	}
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func changesOf(rules map[string]proc_rules.ApiGroupValueType, origins map[proc_rules.PermissionKey][]proc_rules.RuleRef) []Change {
+	var changes []Change
+	for k, v := range rules {
+		for kk, vv := range v.Resource {
+			for _, verb := range vv.Verbs {
+				key := proc_rules.PermissionKey{Group: k, Resource: kk, Verb: verb}
+				refs := origins[key]
+				wildcard_only := len(refs) > 0
+				for _, ref := range refs {
+					if !ref.Wildcard {
+						wildcard_only = false
+					}
+				}
+				changes = append(changes, Change{key, wildcard_only, refs})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		return a.Verb < b.Verb
+	})
+	return changes
+}
+
+func writeSection(w io.Writer, title string, marker string, changes []Change, wildcard_only bool) {
+	var found []Change
+	for _, c := range changes {
+		if c.WildcardOnly == wildcard_only {
+			found = append(found, c)
+		}
+	}
+	fmt.Fprintf(w, "%s: %d\n", title, len(found))
+	for _, c := range found {
+		fmt.Fprintf(w, "%s apigroup: %q, resource: %q, verb: %q\n", marker, c.Group, c.Resource, c.Verb)
+	}
+}
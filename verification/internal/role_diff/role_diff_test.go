@@ -0,0 +1,93 @@
+package role_diff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+)
+
+var api_resource_txt = `NAME                              SHORTNAMES            APIVERSION                             NAMESPACED   KIND                             VERBS
+pods                              po                    v1                                     true         Pod                              [create delete deletecollection get list patch update watch]
+resourcequotas                    quota                 v1                                     true         ResourceQuota                    [create delete deletecollection get list patch update watch]
+statefulsets                      sts                   apps/v1                                true         StatefulSet                      [create delete deletecollection get list patch update watch]`
+
+var old_text = `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-operator
+rules:
+- apiGroups: [""]
+  resources: ["pods", "resourcequotas"]
+  verbs: ["get", "list"]
+- apiGroups: ["apps"]
+  resources: ["statefulsets"]
+  verbs: ["get"]
+`
+
+// the rules are reordered, resourcequotas "list" is revoked and apps is granted through "*"
+var new_text = `kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: cluster-operator
+rules:
+- apiGroups: ["apps"]
+  resources: ["*"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["resourcequotas"]
+  verbs: ["get"]
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["list", "get", "create"]
+`
+
+func expand(t *testing.T, text string) proc_rules.ExpandedRole {
+	path := filepath.Join(t.TempDir(), "role.yaml")
+	os.WriteFile(path, []byte(text), 0644)
+	role, _ := proc_rules.ExpandRbacYaml(utils.DiscardLogger(), path)
+	return role
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_resources.txt"), []byte(api_resource_txt), 0644)
+	proc_rules.ParseAllApiresources(filepath.Join(dir, "api_resources.txt"))
+
+	old_role, new_role := expand(t, old_text), expand(t, new_text)
+	report := Diff(old_role, new_role)
+
+	key := func(group, resource, verb string) proc_rules.PermissionKey {
+		return proc_rules.PermissionKey{Group: group, Resource: resource, Verb: verb}
+	}
+	expect_granted := []Change{
+		{key("", "pods", "create"), false, []proc_rules.RuleRef{{File: new_role.File, Document: 0, RuleIndex: 2}}},
+		{key("apps", "statefulsets", "list"), true, []proc_rules.RuleRef{{File: new_role.File, Document: 0, RuleIndex: 0, Wildcard: true}}},
+	}
+	expect_revoked := []Change{
+		{key("", "resourcequotas", "list"), false, []proc_rules.RuleRef{{File: old_role.File, Document: 0, RuleIndex: 0}}},
+	}
+	if !reflect.DeepEqual(report.Granted, expect_granted) {
+		t.Errorf("expected granted:\n%+v\ngot\n%+v", expect_granted, report.Granted)
+	}
+	if !reflect.DeepEqual(report.Revoked, expect_revoked) {
+		t.Errorf("expected revoked:\n%+v\ngot\n%+v", expect_revoked, report.Revoked)
+	}
+
+	var b bytes.Buffer
+	report.WriteText(&b)
+	for _, s := range []string{"Granted: 1\n+ apigroup: \"\", resource: \"pods\", verb: \"create\"", "Granted through wildcards only: 1", "Revoked: 1", "Revoked through wildcards only: 0"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in:\n%s", s, b.String())
+		}
+	}
+
+	if report := Diff(old_role, expand(t, old_text)); !report.Empty() {
+		t.Errorf("expected no change, got %+v", report)
+	}
+}
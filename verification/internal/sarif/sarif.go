@@ -0,0 +1,141 @@
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+)
+
+/*
+Write diagnostics as a SARIF 2.1.0 log, i.e. for GitHub code scanning, one run with the rules metadata and one result
+per diagnostic. File paths are reported as given, run from the repository root for annotations on pull requests.
+
+Severities map to SARIF levels: error to "error", warning to "warning", info to "note".
+*/
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// Locate returns the 1-based line and column of a diagnostic, 0 when unknown
+type Locate func(d diagnostics.Diagnostic) (int, int)
+
+type message struct {
+	Text string `json:"text"`
+}
+
+type reportingConfiguration struct {
+	Level string `json:"level"`
+}
+
+type reportingDescriptor struct {
+	ID                   string                 `json:"id"`
+	ShortDescription     message                `json:"shortDescription"`
+	Help                 message                `json:"help"`
+	DefaultConfiguration reportingConfiguration `json:"defaultConfiguration"`
+}
+
+type region struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type artifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type physicalLocation struct {
+	ArtifactLocation artifactLocation `json:"artifactLocation"`
+	Region           region           `json:"region"`
+}
+
+type location struct {
+	PhysicalLocation physicalLocation `json:"physicalLocation"`
+}
+
+type result struct {
+	RuleID    string     `json:"ruleId"`
+	RuleIndex *int       `json:"ruleIndex,omitempty"`
+	Level     string     `json:"level"`
+	Message   message    `json:"message"`
+	Locations []location `json:"locations"`
+}
+
+type driver struct {
+	Name           string                `json:"name"`
+	InformationURI string                `json:"informationUri,omitempty"`
+	Rules          []reportingDescriptor `json:"rules"`
+}
+
+type tool struct {
+	Driver driver `json:"driver"`
+}
+
+type run struct {
+	Tool    tool     `json:"tool"`
+	Results []result `json:"results"`
+}
+
+type log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []run  `json:"runs"`
+}
+
+func Write(w io.Writer, tool_name string, rules []diagnostics.Rule, diags []diagnostics.Diagnostic, locate Locate) error {
+	r := run{Tool: tool{Driver: driver{Name: tool_name, Rules: []reportingDescriptor{}}}, Results: []result{}}
+	indexes := make(map[string]int)
+	for _, rule := range rules {
+		indexes[rule.ID] = len(r.Tool.Driver.Rules)
+		r.Tool.Driver.Rules = append(r.Tool.Driver.Rules, reportingDescriptor{
+			ID:                   rule.ID,
+			ShortDescription:     message{rule.Description},
+			Help:                 message{rule.Description},
+			DefaultConfiguration: reportingConfiguration{levelOf(rule.Severity)},
+		})
+	}
+
+	for _, d := range diags {
+		line, column := locate(d)
+		if line <= 0 {
+			line, column = 1, 0
+		}
+		res := result{
+			RuleID:  d.RuleID,
+			Level:   levelOf(d.Severity),
+			Message: message{d.Message},
+			Locations: []location{{physicalLocation{
+				ArtifactLocation: artifactLocation{filepath.ToSlash(d.File)},
+				Region:           region{line, column},
+			}}},
+		}
+		if i, ok := indexes[d.RuleID]; ok {
+			res.RuleIndex = &i
+		}
+		r.Results = append(r.Results, res)
+	}
+
+	j, err := json.MarshalIndent(log{Schema, Version, []run{r}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func levelOf(s diagnostics.Severity) string {
+	switch s {
+	case diagnostics.SeverityError:
+		return "error"
+	case diagnostics.SeverityWarning:
+		return "warning"
+	case diagnostics.SeverityInfo:
+		return "note"
+	default:
+		return "none"
+	}
+}
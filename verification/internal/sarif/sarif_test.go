@@ -0,0 +1,61 @@
+package sarif
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/diagnostics"
+)
+
+func TestWrite(t *testing.T) {
+	rules := []diagnostics.Rule{
+		{ID: "unknown-resource", Severity: diagnostics.SeverityError, Description: "The resource does not exist."},
+		{ID: "non-resource-url", Severity: diagnostics.SeverityInfo, Description: "nonResourceURLs rules are not verified."},
+	}
+	diags := []diagnostics.Diagnostic{
+		{RuleID: "unknown-resource", Severity: diagnostics.SeverityWarning, Message: "unknown resource pod", File: "rbac/role.yaml", Document: 1, RuleIndex: 0},
+		{RuleID: "custom", Severity: diagnostics.SeverityInfo, Message: "custom finding", File: "rbac/role.yaml", Document: 0, RuleIndex: -1},
+	}
+	locate := func(d diagnostics.Diagnostic) (int, int) {
+		if d.RuleIndex < 0 {
+			return 0, 0
+		}
+		return 12, 3
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "k8s-rbac-verification", rules, diags, locate); err != nil {
+		t.Fatal(err)
+	}
+	var got log
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Version != Version || len(got.Runs) != 1 {
+		t.Fatalf("expected one run of version %s, got %+v", Version, got)
+	}
+	driver := got.Runs[0].Tool.Driver
+	if len(driver.Rules) != 2 || driver.Rules[1].ID != "non-resource-url" || driver.Rules[1].DefaultConfiguration.Level != "note" {
+		t.Errorf("unexpected rules %+v", driver.Rules)
+	}
+	results := got.Runs[0].Results
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	first := results[0]
+	if first.Level != "warning" || first.RuleIndex == nil || *first.RuleIndex != 0 {
+		t.Errorf("expected overridden level warning and rule index 0, got %+v", first)
+	}
+	if r := first.Locations[0].PhysicalLocation.Region; r.StartLine != 12 || r.StartColumn != 3 {
+		t.Errorf("expected region 12:3, got %+v", r)
+	}
+	second := results[1]
+	if second.RuleIndex != nil {
+		t.Errorf("expected no rule index for an unknown rule, got %d", *second.RuleIndex)
+	}
+	if r := second.Locations[0].PhysicalLocation.Region; r.StartLine != 1 || r.StartColumn != 0 {
+		t.Errorf("expected an unknown position on line 1, got %+v", r)
+	}
+}
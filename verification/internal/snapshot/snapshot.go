@@ -0,0 +1,282 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+/*
+Capture what the offline subcommands read from a live cluster into a single gzipped tar archive, for the laptops that
+cannot reach it:
+
+	*snapshot.json: the capture time and the server version
+	*api-resources.txt: the discovery catalog with the subresources, in the format of scripts/k8s/print-all-res.sh
+	*roles.json, clusterroles.json, rolebindings.json, clusterrolebindings.json, namespaces.json, serviceaccounts.json:
+	 the lists of the objects, like "kubectl get -o json"
+
+The objects of a loaded snapshot are those of the cluster, their origin is rbac_objects.OriginCluster.
+*/
+type Snapshot struct {
+	Captured      time.Time             `json:"captured"`
+	ServerVersion version.Info          `json:"serverVersion"`
+	Catalog       []byte                `json:"-"`
+	Objects       *rbac_objects.Objects `json:"-"`
+}
+
+const (
+	manifestEntry = "snapshot.json"
+	catalogEntry  = "api-resources.txt"
+)
+
+// the entries of an archive, in their order
+var entries = []string{manifestEntry, catalogEntry, "roles.json", "clusterroles.json", "rolebindings.json",
+	"clusterrolebindings.json", "namespaces.json", "serviceaccounts.json"}
+
+// Capture lists the discovery catalog and the rbac objects of the cluster, a group failing discovery is skipped with a warning
+func Capture(ctx context.Context, logger *slog.Logger, clientset kubernetes.Interface) (*Snapshot, error) {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the server version: %w", err)
+	}
+	groups, lists, err := clientset.Discovery().ServerGroupsAndResources()
+	if discovery.IsGroupDiscoveryFailedError(err) {
+		logger.Warn("Skipped api groups failing discovery", "error", err.Error())
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to discover the api resources: %w", err)
+	}
+	var catalog bytes.Buffer
+	if err := WriteCatalog(&catalog, groups, lists); err != nil {
+		return nil, err
+	}
+	objects, err := rbac_objects.LoadClientset(ctx, clientset)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Captured: time.Now().UTC(), ServerVersion: *info, Catalog: catalog.Bytes(), Objects: objects}, nil
+}
+
+/*
+WriteCatalog writes the resources of every version in the columns of "kubectl api-resources -o wide", sorted by apigroup
+and name. ParseAllApiresources keeps the last version of a resource, the preferred version of each group is written last.
+*/
+func WriteCatalog(w io.Writer, groups []*metav1.APIGroup, lists []*metav1.APIResourceList) error {
+	preferred := make(map[string]string)
+	for _, g := range groups {
+		if g != nil {
+			preferred[g.Name] = g.PreferredVersion.GroupVersion
+		}
+	}
+	type line struct {
+		group, group_version string
+		resource             metav1.APIResource
+	}
+	var lines []line
+	for _, list := range lists {
+		if list == nil {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return err
+		}
+		for _, r := range list.APIResources {
+			lines = append(lines, line{gv.Group, list.GroupVersion, r})
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		if a.resource.Name != b.resource.Name {
+			return a.resource.Name < b.resource.Name
+		}
+		a_preferred, b_preferred := a.group_version == preferred[a.group], b.group_version == preferred[b.group]
+		if a_preferred != b_preferred {
+			return b_preferred
+		}
+		return a.group_version < b.group_version
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSHORTNAMES\tAPIVERSION\tNAMESPACED\tKIND\tVERBS")
+	for _, l := range lines {
+		r := l.resource
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t[%s]\n", r.Name, strings.Join(r.ShortNames, ","), l.group_version, r.Namespaced, r.Kind, strings.Join(r.Verbs, " "))
+	}
+	return tw.Flush()
+}
+
+// ParseCatalog parses the resource catalog of the snapshot, in place of ParseAllApiresources
+func (s *Snapshot) ParseCatalog() {
+	proc_rules.ParseAllApiresourcesReader(bytes.NewReader(s.Catalog))
+}
+
+// Write writes the snapshot as a gzipped tar archive
+func (s *Snapshot) Write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	o := s.Objects
+	values := map[string]interface{}{
+		manifestEntry:              s,
+		"roles.json":               rbacv1.RoleList{TypeMeta: listMeta("RoleList", rbacv1.SchemeGroupVersion.String()), Items: o.Roles},
+		"clusterroles.json":        rbacv1.ClusterRoleList{TypeMeta: listMeta("ClusterRoleList", rbacv1.SchemeGroupVersion.String()), Items: o.ClusterRoles},
+		"rolebindings.json":        rbacv1.RoleBindingList{TypeMeta: listMeta("RoleBindingList", rbacv1.SchemeGroupVersion.String()), Items: o.RoleBindings},
+		"clusterrolebindings.json": rbacv1.ClusterRoleBindingList{TypeMeta: listMeta("ClusterRoleBindingList", rbacv1.SchemeGroupVersion.String()), Items: o.ClusterRoleBindings},
+		"namespaces.json":          corev1.NamespaceList{TypeMeta: listMeta("NamespaceList", "v1"), Items: o.Namespaces},
+		"serviceaccounts.json":     corev1.ServiceAccountList{TypeMeta: listMeta("ServiceAccountList", "v1"), Items: o.ServiceAccounts},
+	}
+	for _, name := range entries {
+		data := s.Catalog
+		if name != catalogEntry {
+			if data, err = json.MarshalIndent(values[name], "", "  "); err != nil {
+				return err
+			}
+		}
+		if err := writeEntry(tw, name, s.Captured, data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads a snapshot archive written by Write
+func Load(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func Read(r io.Reader) (*Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	s := &Snapshot{Objects: rbac_objects.New()}
+	var lists struct {
+		roles                 rbacv1.RoleList
+		cluster_roles         rbacv1.ClusterRoleList
+		role_bindings         rbacv1.RoleBindingList
+		cluster_role_bindings rbacv1.ClusterRoleBindingList
+		namespaces            corev1.NamespaceList
+		accounts              corev1.ServiceAccountList
+	}
+	targets := map[string]interface{}{
+		manifestEntry:              s,
+		"roles.json":               &lists.roles,
+		"clusterroles.json":        &lists.cluster_roles,
+		"rolebindings.json":        &lists.role_bindings,
+		"clusterrolebindings.json": &lists.cluster_role_bindings,
+		"namespaces.json":          &lists.namespaces,
+		"serviceaccounts.json":     &lists.accounts,
+	}
+	found := make(map[string]bool)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		found[header.Name] = true
+		if header.Name == catalogEntry {
+			s.Catalog = data
+		} else if target, ok := targets[header.Name]; ok {
+			if err := json.Unmarshal(data, target); err != nil {
+				return nil, fmt.Errorf("%s: %w", header.Name, err)
+			}
+		}
+	}
+	for _, name := range entries {
+		if !found[name] {
+			return nil, fmt.Errorf("%s is missing, not a snapshot archive", name)
+		}
+	}
+
+	for _, role := range lists.roles.Items {
+		s.Objects.AddRole(role, rbac_objects.OriginCluster)
+	}
+	for _, role := range lists.cluster_roles.Items {
+		s.Objects.AddClusterRole(role, rbac_objects.OriginCluster)
+	}
+	for _, binding := range lists.role_bindings.Items {
+		s.Objects.AddRoleBinding(binding, rbac_objects.OriginCluster)
+	}
+	for _, binding := range lists.cluster_role_bindings.Items {
+		s.Objects.AddClusterRoleBinding(binding, rbac_objects.OriginCluster)
+	}
+	for _, namespace := range lists.namespaces.Items {
+		s.Objects.AddNamespace(namespace, rbac_objects.OriginCluster)
+	}
+	for _, account := range lists.accounts.Items {
+		s.Objects.AddServiceAccount(account, rbac_objects.OriginCluster)
+	}
+	return s, nil
+}
+
+func WriteText(w io.Writer, s *Snapshot) {
+	o := s.Objects
+	fmt.Fprintf(w, "Server %s, captured %s\n", s.ServerVersion.GitVersion, s.Captured.Format(time.RFC3339))
+	fmt.Fprintf(w, "%d resource(s), %d role(s), %d clusterrole(s), %d rolebinding(s), %d clusterrolebinding(s), %d namespace(s), %d serviceaccount(s)\n",
+		strings.Count(string(s.Catalog), "\n")-1, len(o.Roles), len(o.ClusterRoles), len(o.RoleBindings), len(o.ClusterRoleBindings),
+		len(o.Namespaces), len(o.ServiceAccounts))
+}
+
+// helper functions
+func listMeta(kind string, api_version string) metav1.TypeMeta {
+	return metav1.TypeMeta{Kind: kind, APIVersion: api_version}
+}
+
+func writeEntry(tw *tar.Writer, name string, mod_time time.Time, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: mod_time}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,97 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	proc_rules "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/process_rules"
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	utils "github.com/lamatriz/ra2-auth/k8s-rbac-verfication/utils"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var resources = []*metav1.APIResourceList{
+	{GroupVersion: "v1", APIResources: []metav1.APIResource{
+		{Name: "pods", ShortNames: []string{"po"}, Namespaced: true, Kind: "Pod", Verbs: []string{"create", "delete", "get", "list"}},
+		{Name: "pods/log", Namespaced: true, Kind: "Pod", Verbs: []string{"get"}},
+		{Name: "nodes", ShortNames: []string{"no"}, Namespaced: false, Kind: "Node", Verbs: []string{"get", "list"}},
+	}},
+	{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+		{Name: "statefulsets", ShortNames: []string{"sts"}, Namespaced: true, Kind: "StatefulSet", Verbs: []string{"get", "list"}},
+	}},
+	{GroupVersion: "apps/v1beta1", APIResources: []metav1.APIResource{
+		{Name: "statefulsets", Namespaced: true, Kind: "StatefulSet", Verbs: []string{"get"}},
+	}},
+}
+
+func TestCaptureWriteLoad(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "namespace-admin"}, Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+		}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "mldev-namespace-admin", Namespace: "mldev"},
+			RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "namespace-admin"},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "oidc:mldev-namespace-admin"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "mldev"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "mldev"}},
+	)
+	discovery := clientset.Discovery().(*fakediscovery.FakeDiscovery)
+	discovery.Resources = resources
+	discovery.FakedServerVersion = &version.Info{Major: "1", Minor: "26", GitVersion: "v1.26.3"}
+
+	captured, err := Capture(context.Background(), utils.DiscardLogger(), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.tar.gz")
+	if err := captured.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ServerVersion.GitVersion != "v1.26.3" || !s.Captured.Equal(captured.Captured) || !bytes.Equal(s.Catalog, captured.Catalog) {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+	o := s.Objects
+	if len(o.ClusterRoles) != 1 || len(o.RoleBindings) != 1 || len(o.Namespaces) != 1 || len(o.ServiceAccounts) != 1 {
+		t.Fatalf("unexpected objects %+v", o)
+	}
+	if !reflect.DeepEqual(o.ClusterRoles[0].Rules, captured.Objects.ClusterRoles[0].Rules) {
+		t.Errorf("expected rules %v, got %v", captured.Objects.ClusterRoles[0].Rules, o.ClusterRoles[0].Rules)
+	}
+	if source := o.Source[rbac_objects.KeyOf("RoleBinding", "mldev", "mldev-namespace-admin")]; source != rbac_objects.OriginCluster {
+		t.Errorf("expected a cluster origin, got %v", source)
+	}
+
+	s.ParseCatalog()
+	pods := proc_rules.AllResourcesMap[""].Resource["pods/log"]
+	if pods.SubResource != "log" || !pods.Namespaced || !reflect.DeepEqual(pods.Verbs, []string{"get"}) {
+		t.Errorf("unexpected pods/log %+v", pods)
+	}
+	statefulsets := proc_rules.AllResourcesMap["apps"].Resource["statefulsets"]
+	// the preferred version is parsed last
+	if !reflect.DeepEqual(statefulsets.Versions, []string{"v1beta1", "v1"}) || !reflect.DeepEqual(statefulsets.ShortNames, []string{"sts"}) {
+		t.Errorf("unexpected statefulsets %+v", statefulsets)
+	}
+	if nodes := proc_rules.AllResourcesMap[""].Resource["nodes"]; nodes.Namespaced || nodes.Kind != "Node" {
+		t.Errorf("unexpected nodes %+v", nodes)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Read(strings.NewReader("not an archive")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
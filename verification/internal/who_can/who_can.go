@@ -0,0 +1,153 @@
+package who_can
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+/*
+Query lists the subjects granted a verb on a resource in a namespace, with the granting chain:
+
+	subject <- RoleBinding or ClusterRoleBinding -> Role or ClusterRole [-> aggregated ClusterRole] -> rule
+
+ClusterRoleBindings grant in every namespace, RoleBindings only in their own. A cluster-scoped request, namespace "",
+is only granted by ClusterRoleBindings. Bindings to a missing role grant nothing.
+*/
+type Request struct {
+	Verb        string `json:"verb"`
+	Group       string `json:"group"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource"`
+	Namespace   string `json:"namespace"`
+}
+
+type Subject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type Grant struct {
+	Subject        Subject `json:"subject"`
+	Binding        string  `json:"binding"`                  // i.e. "RoleBinding/mldev/mldev-namespace-admin"
+	RoleRef        string  `json:"roleRef"`                  // i.e. "ClusterRole/namespace-admin"
+	AggregatedRole string  `json:"aggregatedRole,omitempty"` // the role holding the rule, when aggregated into RoleRef
+	RuleIndex      int     `json:"ruleIndex"`
+	Source         string  `json:"source"` // where the role holding the rule was loaded from
+}
+
+func Query(objects *rbac_objects.Objects, req Request) []Grant {
+	var grants []Grant
+	collect := func(binding string, namespace string, ref rbacv1.RoleRef, subjects []rbacv1.Subject) {
+		role_rules, ok := objects.RulesOf(ref, namespace)
+		if !ok {
+			return
+		}
+		role_ref := rbac_objects.KeyOf(ref.Kind, namespaceOf(ref.Kind, namespace), ref.Name)
+		for _, rr := range role_rules {
+			for rule_index, rule := range rr.Rules {
+				if !rbac_objects.RuleAllows(rule, req.Verb, req.Group, req.Resource, req.Subresource) {
+					continue
+				}
+				role := rbac_objects.KeyOf(rr.Kind, namespaceOf(rr.Kind, namespace), rr.Name)
+				aggregated := ""
+				if role != role_ref {
+					aggregated = role
+				}
+				for _, s := range subjects {
+					grants = append(grants, Grant{
+						Subject:        Subject{s.Kind, s.Name, s.Namespace},
+						Binding:        binding,
+						RoleRef:        role_ref,
+						AggregatedRole: aggregated,
+						RuleIndex:      rule_index,
+						Source:         objects.Source[role].String(),
+					})
+				}
+			}
+		}
+	}
+
+	for _, b := range objects.ClusterRoleBindings {
+		collect(rbac_objects.KeyOf("ClusterRoleBinding", "", b.Name), "", b.RoleRef, b.Subjects)
+	}
+	if req.Namespace != "" {
+		for _, b := range objects.RoleBindings {
+			if b.Namespace == req.Namespace {
+				collect(rbac_objects.KeyOf("RoleBinding", b.Namespace, b.Name), b.Namespace, b.RoleRef, b.Subjects)
+			}
+		}
+	}
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.Subject.Kind != b.Subject.Kind {
+			return a.Subject.Kind < b.Subject.Kind
+		}
+		if a.Subject.Namespace != b.Subject.Namespace {
+			return a.Subject.Namespace < b.Subject.Namespace
+		}
+		if a.Subject.Name != b.Subject.Name {
+			return a.Subject.Name < b.Subject.Name
+		}
+		return a.Binding < b.Binding
+	})
+	return grants
+}
+
+func WriteText(w io.Writer, req Request, grants []Grant) {
+	resource := req.Resource
+	if req.Subresource != "" {
+		resource += "/" + req.Subresource
+	}
+	scope := "cluster-wide"
+	if req.Namespace != "" {
+		scope = fmt.Sprintf("in namespace %q", req.Namespace)
+	}
+	fmt.Fprintf(w, "Who can %q apigroup %q resource %q %s: %d grant(s)\n", req.Verb, req.Group, resource, scope, len(grants))
+
+	var previous *Subject
+	for i, g := range grants {
+		if previous == nil || *previous != g.Subject {
+			fmt.Fprintf(w, "%s %s\n", g.Subject.Kind, nameOf(g.Subject))
+			previous = &grants[i].Subject
+		}
+		chain := fmt.Sprintf("%s -> %s", g.Binding, g.RoleRef)
+		if g.AggregatedRole != "" {
+			chain += fmt.Sprintf(" -> aggregated %s", g.AggregatedRole)
+		}
+		fmt.Fprintf(w, "  %s, rule %d (%s)\n", chain, g.RuleIndex, g.Source)
+	}
+}
+
+func WriteJson(w io.Writer, grants []Grant) error {
+	if grants == nil {
+		grants = []Grant{}
+	}
+	j, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+// helper functions
+func namespaceOf(kind string, namespace string) string {
+	if kind == "Role" {
+		return namespace
+	}
+	return ""
+}
+
+func nameOf(s Subject) string {
+	if s.Namespace != "" {
+		return s.Namespace + "/" + s.Name
+	}
+	return s.Name
+}
@@ -0,0 +1,149 @@
+package who_can
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lamatriz/ra2-auth/k8s-rbac-verfication/internal/rbac_objects"
+)
+
+var objects_yaml_text = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: admin
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.authorization.k8s.io/aggregate-to-admin: "true"
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: namespace-admin
+  labels:
+    rbac.authorization.k8s.io/aggregate-to-admin: "true"
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  resources: ["pods/exec"]
+  verbs: ["create"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: cluster-operator
+rules:
+- apiGroups: ["*"]
+  resources: ["*"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: exec
+  namespace: smoke-test
+rules:
+- apiGroups: [""]
+  resources: ["pods/exec"]
+  verbs: ["create"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: mldev-admin
+  namespace: mldev
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: admin
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:mldev-namespace-admin
+- kind: ServiceAccount
+  name: deployer
+  namespace: mldev
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: smoke-test-exec
+  namespace: smoke-test
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: exec
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: User
+  name: alice
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cluster-operator
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-operator
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:cluster-operator
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: dangling
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: decommissioned
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: oidc:project-lima-namespace-admin
+`
+
+func load(t *testing.T) *rbac_objects.Objects {
+	objects := rbac_objects.New()
+	if err := objects.Decode("objects.yaml", strings.NewReader(objects_yaml_text)); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestQuery(t *testing.T) {
+	objects := load(t)
+
+	grants := Query(objects, Request{Verb: "create", Resource: "pods", Subresource: "exec", Namespace: "mldev"})
+	expect := []Grant{
+		{Subject{"Group", "oidc:mldev-namespace-admin", ""}, "RoleBinding/mldev/mldev-admin", "ClusterRole/admin", "ClusterRole/namespace-admin", 1, "objects.yaml: document 1"},
+		{Subject{"ServiceAccount", "deployer", "mldev"}, "RoleBinding/mldev/mldev-admin", "ClusterRole/admin", "ClusterRole/namespace-admin", 1, "objects.yaml: document 1"},
+	}
+	if !reflect.DeepEqual(grants, expect) {
+		t.Errorf("expected:\n%+v\ngot\n%+v", expect, grants)
+	}
+
+	grants = Query(objects, Request{Verb: "create", Resource: "pods", Subresource: "exec", Namespace: "smoke-test"})
+	if len(grants) != 1 || grants[0].Subject.Name != "alice" || grants[0].RoleRef != "Role/smoke-test/exec" || grants[0].AggregatedRole != "" {
+		t.Errorf("expected alice through the smoke-test Role, got %+v", grants)
+	}
+
+	grants = Query(objects, Request{Verb: "get", Resource: "pods", Namespace: ""})
+	if len(grants) != 1 || grants[0].Subject.Name != "oidc:cluster-operator" {
+		t.Errorf("expected only the ClusterRoleBinding cluster-wide, got %+v", grants)
+	}
+
+	var b bytes.Buffer
+	WriteText(&b, Request{Verb: "create", Resource: "pods", Subresource: "exec", Namespace: "mldev"}, expect)
+	for _, s := range []string{`resource "pods/exec" in namespace "mldev": 2 grant(s)`, "ServiceAccount mldev/deployer", "-> aggregated ClusterRole/namespace-admin, rule 1"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in:\n%s", s, b.String())
+		}
+	}
+}
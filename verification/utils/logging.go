@@ -1,25 +1,77 @@
 package utils
 
 import (
-	"log"
+	"fmt"
+	"io"
 	"os"
-)
+	"strings"
 
-var (
-	InfoLogger  *log.Logger
-	ErrorLogger *log.Logger
-	FatalLogger *log.Logger
+	"golang.org/x/exp/slog"
 )
 
-func Set_logging(path string) error {
-	file, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatal(err)
+// LogOptions configures the leveled structured logger
+type LogOptions struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+	File   string // appended to, no file logging when empty
+	Stderr bool   // also log to stderr
+}
+
+/*
+NewLogger creates the logger passed explicitly into the packages, records are written to the log file in append mode
+and/or to stderr. Log with key/value pairs rather than formatted strings, i.e.
+
+	logger.Info("review", "apigroup", "apps", "resource", "statefulsets", "verb", "get", "verdict", true)
+
+The returned io.Closer closes the log file, it is never nil.
+*/
+func NewLogger(opts LogOptions) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	switch strings.ToLower(opts.Level) {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return nil, nopCloser{}, fmt.Errorf("unknown log level %q, expecting debug, info, warn or error", opts.Level)
+	}
+
+	var writers []io.Writer
+	var closer io.Closer = nopCloser{}
+	if opts.File != "" {
+		file, err := os.OpenFile(opts.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, closer, fmt.Errorf("failed to open log file: %w", err)
+		}
+		writers = append(writers, file)
+		closer = file
+	}
+	if opts.Stderr {
+		writers = append(writers, os.Stderr)
 	}
 
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime)
-	FatalLogger = log.New(file, "FATAL: ", log.Ldate|log.Ltime|log.Lshortfile)
+	handler_opts := slog.HandlerOptions{Level: level}
+	w := io.MultiWriter(writers...)
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		return slog.New(handler_opts.NewTextHandler(w)), closer, nil
+	case "json":
+		return slog.New(handler_opts.NewJSONHandler(w)), closer, nil
+	default:
+		closer.Close()
+		return nil, nopCloser{}, fmt.Errorf("unknown log format %q, expecting text or json", opts.Format)
+	}
+}
 
-	return err
+// DiscardLogger drops every record, for unit tests and callers without logging
+func DiscardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard))
 }
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verify.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, closer, err := NewLogger(LogOptions{Level: "warn", Format: "json", File: path})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %s", err.Error())
+	}
+	logger.Info("Review", "resource", "pods")
+	logger.Warn("Found nonexisting resource, skipping", "apigroup", "", "resource", "pod")
+	closer.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "previous run" {
+		t.Fatalf("expected the log file to be appended with one record, got:\n%s", string(data))
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("expected a json record, got %s", lines[1])
+	}
+	if record["level"] != "WARN" || record["resource"] != "pod" || record["apigroup"] != "" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestNewLoggerErrors(t *testing.T) {
+	invalid := []LogOptions{
+		{Level: "verbose"},
+		{Format: "xml"},
+		{File: filepath.Join(t.TempDir(), "missing", "verify.log")},
+	}
+	for _, opts := range invalid {
+		if _, closer, err := NewLogger(opts); err == nil || closer == nil {
+			t.Errorf("expected an error and a closer for %+v", opts)
+		}
+	}
+}